@@ -1,9 +1,30 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/config"
+	"github.com/jamadeu/accounts/metrics"
+	"github.com/jamadeu/accounts/notify"
+	"github.com/jamadeu/accounts/password"
+	"github.com/jamadeu/accounts/schemas"
 	"github.com/jamadeu/accounts/services/account"
+	"github.com/jamadeu/accounts/services/apikey"
+	"github.com/jamadeu/accounts/services/auth"
+	"github.com/jamadeu/accounts/services/idempotency"
+	"github.com/jamadeu/accounts/services/uow"
 	"github.com/jamadeu/accounts/services/user"
+	"github.com/jamadeu/accounts/token"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"gorm.io/gorm"
 )
 
@@ -12,27 +33,155 @@ const (
 )
 
 type APIServer struct {
-	port string
-	db   *gorm.DB
+	cfg       config.Config
+	db        *gorm.DB
+	issuer    *token.Issuer
+	passwords *password.Hasher
+	// notifier delivers password reset tokens. No delivery channel is
+	// configured yet, so messages are logged.
+	notifier notify.Notifier
+	health   *health
+	logger   *slog.Logger
 }
 
-func NewApiServer(addr string, db *gorm.DB) *APIServer {
+func NewApiServer(cfg config.Config, db *gorm.DB, issuer *token.Issuer, passwords *password.Hasher) *APIServer {
 	return &APIServer{
-		port: addr,
-		db:   db,
+		cfg:       cfg,
+		db:        db,
+		issuer:    issuer,
+		passwords: passwords,
+		notifier:  notify.NewLog(slog.Default()),
+		health:    newHealth(databaseChecks(db)...),
+		logger:    slog.Default(),
 	}
 }
 
+// Run serves the API until SIGINT or SIGTERM, then drains in-flight requests
+// and closes the database pool.
 func (s *APIServer) Run() error {
-	router := gin.Default()
+	// Gin's debug output is not structured; keep it for explicit GIN_MODE=debug
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", s.cfg.Server.Addr())
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves the API on listener until ctx is done. On shutdown readiness
+// fails first, for the configured shutdown delay, then the server waits up to
+// the shutdown timeout for in-flight requests before closing the remaining
+// connections. The database pool is always closed last.
+func (s *APIServer) Serve(ctx context.Context, listener net.Listener) error {
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	err := s.serve(ctx, listener, s.routes(workers))
+	stopWorkers()
+
+	if s.db != nil {
+		sqlDB, dbErr := s.db.DB()
+		if dbErr == nil {
+			dbErr = sqlDB.Close()
+		}
+		err = errors.Join(err, dbErr)
+	}
+	return err
+}
+
+func (s *APIServer) serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       s.cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: s.cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      s.cfg.Server.WriteTimeout,
+		IdleTimeout:       s.cfg.Server.IdleTimeout,
+	}
+
+	db := s.cfg.DB
+	s.logger.Info("listening",
+		slog.String("addr", listener.Addr().String()),
+		slog.Int("db_max_open_conns", db.MaxOpenConns),
+		slog.Int("db_max_idle_conns", db.MaxIdleConns),
+		slog.Duration("db_conn_max_lifetime", db.ConnMaxLifetime),
+		slog.Duration("db_conn_max_idle_time", db.ConnMaxIdleTime),
+	)
 
-	userRepo := user.NewUserRepository(s.db)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("shutting down, failing readiness", slog.Duration("delay", s.cfg.Server.ShutdownDelay))
+	s.health.drain()
+	time.Sleep(s.cfg.Server.ShutdownDelay)
+
+	s.logger.Info("draining requests", slog.Duration("timeout", s.cfg.Server.ShutdownTimeout))
+	drain, cancel := context.WithTimeout(context.Background(), s.cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(drain); err != nil {
+		server.Close()
+		return err
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// routes builds the router. Background workers stop when ctx is done.
+func (s *APIServer) routes(ctx context.Context) *gin.Engine {
+	apiKeyRepo := apikey.NewTracedAPIKeyRepository(apikey.NewAPIKeyRepository(s.db))
+	router := gin.New()
+	router.Use(otelgin.Middleware(s.cfg.Tracing.ServiceName), requestID(), accessLog(s.logger), recovery(s.logger), metrics.Middleware())
+	// Probes and scrapes are registered before authenticate, so credentials
+	// they happen to carry are never looked at
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	s.health.RegisterRoutes(router)
+	router.Use(authenticate(s.issuer, apikey.NewVerifier(apiKeyRepo)))
+
+	if s.cfg.Features.Idempotency {
+		idempotencyRepo := idempotency.NewIdempotencyRepository(s.db)
+		router.Use(idempotencyMiddleware(idempotencyRepo, s.cfg.Idempotency.TTL, basePath+"/v1/auth", basePath+"/v1/api-keys"))
+		go purgeIdempotencyKeys(ctx, idempotencyRepo, s.cfg.Idempotency.TTL)
+	}
+
+	userRepo := user.NewTracedUserRepository(user.NewUserRepository(s.db))
 	userHandler := user.NewUserHandler(userRepo)
 	userHandler.RegisterRoutes(router, basePath)
 
-	accountRepo := account.NewAccountRepository(s.db)
-	accountHandler := account.NewAccountHandler(accountRepo, userRepo)
+	credentialRepo := auth.NewTracedCredentialRepository(auth.NewCredentialRepository(s.db))
+	twoFactorRepo := auth.NewTracedTwoFactorRepository(auth.NewTwoFactorRepository(s.db))
+	authHandler := auth.NewAuthHandler(s.issuer, userRepo, credentialRepo, twoFactorRepo, s.passwords, s.notifier, auth.Settings{
+		TOTPIssuer:      s.cfg.Auth.Issuer,
+		Policy:          password.Policy{MinLength: s.cfg.Auth.PasswordMinLength, MinClasses: s.cfg.Auth.PasswordMinClasses},
+		MaxFailedLogins: s.cfg.Auth.MaxFailedLogins,
+		LockoutDuration: s.cfg.Auth.LockoutDuration,
+		ResetTokenTTL:   s.cfg.Auth.ResetTokenTTL,
+	})
+	authHandler.RegisterRoutes(router, basePath)
+
+	apiKeyHandler := apikey.NewAPIKeyHandler(apiKeyRepo)
+	apiKeyHandler.RegisterRoutes(router, basePath)
+
+	accountRepo := account.NewTracedAccountRepository(account.NewAccountRepository(s.db))
+	transactions := uow.New(s.db, func(tx *gorm.DB) schemas.Repositories {
+		return schemas.Repositories{
+			Users:    user.NewTracedUserRepository(user.NewUserRepository(tx)),
+			Accounts: account.NewTracedAccountRepository(account.NewAccountRepository(tx)),
+		}
+	})
+	accountHandler := account.NewAccountHandler(accountRepo, transactions)
 	accountHandler.RegisterRoutes(router, basePath)
 
-	return router.Run(s.port)
+	return router
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jamadeu/accounts/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.ShutdownTimeout = 5 * time.Second
+	cfg.Server.ShutdownDelay = 0
+	server := NewApiServer(cfg, nil, nil, nil)
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.serve(ctx, listener, handler) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	response := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			response <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		response <- result{string(body), err}
+	}()
+
+	<-started
+	cancel()
+
+	got := <-response
+	require.NoError(t, got.err)
+	assert.Equal(t, "done", got.body)
+	assert.NoError(t, <-served)
+
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.Error(t, err, "listener should be closed after shutdown")
+}
+
+func TestServeGivesUpAfterShutdownTimeout(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.ShutdownTimeout = 50 * time.Millisecond
+	cfg.Server.ShutdownDelay = 0
+	server := NewApiServer(cfg, nil, nil, nil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.serve(ctx, listener, handler) }()
+	go http.Get("http://" + listener.Addr().String())
+
+	<-started
+	cancel()
+	assert.ErrorIs(t, <-served, context.DeadlineExceeded)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/services/apikey"
+	"github.com/jamadeu/accounts/token"
+)
+
+// authenticate identifies the caller from either credential a request may
+// carry as its Bearer token: an API key, recognised by its prefix, or a
+// signed access token, which token.Middleware handles. Requests made with an
+// API key act as the key's user, limited to the key's scopes.
+func authenticate(issuer *token.Issuer, keys *apikey.Verifier) gin.HandlerFunc {
+	bearer := token.Middleware(issuer)
+	return func(ctx *gin.Context) {
+		scheme, raw, _ := strings.Cut(ctx.GetHeader("Authorization"), " ")
+		raw = strings.TrimSpace(raw)
+		if !strings.EqualFold(scheme, "Bearer") || !apikey.IsKey(raw) {
+			bearer(ctx)
+			return
+		}
+		c := ctx.Request.Context()
+		key, err := keys.Verify(c, raw)
+		if err != nil {
+			if errors.Is(err, schemas.ErrAPIKeyInvalid) {
+				slog.InfoContext(c, "rejected api key", "error", err)
+				ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				services.SendError(ctx, http.StatusUnauthorized, "invalid, expired or revoked api key")
+			} else if !services.SendContextError(ctx, err) {
+				slog.ErrorContext(c, "error verifying api key", "error", err)
+				services.SendError(ctx, http.StatusInternalServerError, "error verifying api key")
+			}
+			ctx.Abort()
+			return
+		}
+		c = services.WithRole(services.WithCaller(c, key.UserID), key.User.Role)
+		ctx.Request = ctx.Request.WithContext(services.WithScopes(c, key.ScopeList()))
+		ctx.Next()
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/services/apikey"
+	"github.com/jamadeu/accounts/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const (
+	testKeyPrefix = "ak_0123abcd"
+	testKeySecret = "partner-secret-with-enough-entropy-for-a-test"
+)
+
+func TestAuthenticate(t *testing.T) {
+	signing, err := token.NewHMACKey("k", []byte(strings.Repeat("k", 32)))
+	require.NoError(t, err)
+	keys, err := token.NewKeySet(signing)
+	require.NoError(t, err)
+	issuer := token.NewIssuer(keys, "accounts", time.Minute, time.Hour)
+	sum := sha256.Sum256([]byte(testKeySecret))
+	repo := &mockAPIKeyRepository{key: schemas.APIKey{
+		ID:         1,
+		UserID:     7,
+		User:       schemas.User{Model: gorm.Model{ID: 7}, Role: schemas.RoleCustomer},
+		Prefix:     testKeyPrefix,
+		SecretHash: hex.EncodeToString(sum[:]),
+		Scopes:     "accounts:read",
+	}}
+
+	router := gin.New()
+	router.Use(authenticate(issuer, apikey.NewVerifier(repo)))
+	whoami := func(ctx *gin.Context) {
+		caller, _ := services.Caller(ctx.Request.Context())
+		scopes, _ := services.Scopes(ctx.Request.Context())
+		ctx.JSON(http.StatusOK, gin.H{"caller": caller, "scopes": scopes})
+	}
+	router.GET("/accounts", services.Require(services.PermAccountsRead), whoami)
+	router.POST("/transfers", services.Require(services.PermTransfersWrite), whoami)
+
+	send := func(method, path, credential string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+credential)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("should accept access tokens", func(t *testing.T) {
+		pair, err := issuer.Issue(7, schemas.RoleCustomer)
+		require.NoError(t, err)
+		w := send("POST", "/transfers", pair.AccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"caller":7,"scopes":null}`, w.Body.String())
+	})
+
+	t.Run("should accept api keys within their scopes", func(t *testing.T) {
+		w := send("GET", "/accounts", testKeyPrefix+"_"+testKeySecret)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"caller":7,"scopes":["accounts:read"]}`, w.Body.String())
+		assert.NotNil(t, repo.key.LastUsedAt)
+
+		w = send("POST", "/transfers", testKeyPrefix+"_"+testKeySecret)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, `{"errorCode":403,"message":"api key lacks scope transfers:write"}`, w.Body.String())
+	})
+
+	t.Run("should reject invalid credentials of either type", func(t *testing.T) {
+		for _, credential := range []string{testKeyPrefix + "_wrong", "ak_ffffffff_" + testKeySecret, "not-a-jwt"} {
+			w := send("GET", "/accounts", credential)
+			assert.Equal(t, http.StatusUnauthorized, w.Code, credential)
+			assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+		}
+	})
+}
+
+type mockAPIKeyRepository struct {
+	key schemas.APIKey
+}
+
+func (m *mockAPIKeyRepository) Create(ctx context.Context, key *schemas.APIKey) error {
+	return nil
+}
+
+func (m *mockAPIKeyRepository) ListByUser(ctx context.Context, userID uint) ([]schemas.APIKey, error) {
+	return []schemas.APIKey{m.key}, nil
+}
+
+func (m *mockAPIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*schemas.APIKey, error) {
+	if prefix != m.key.Prefix {
+		return nil, schemas.ErrAPIKeyNotFound
+	}
+	key := m.key
+	return &key, nil
+}
+
+func (m *mockAPIKeyRepository) Rotate(ctx context.Context, id, userID uint, replacement *schemas.APIKey, overlap time.Duration) error {
+	return nil
+}
+
+func (m *mockAPIKeyRepository) Revoke(ctx context.Context, id, userID uint) error {
+	return nil
+}
+
+func (m *mockAPIKeyRepository) RecordUse(ctx context.Context, id uint, at time.Time) error {
+	m.key.LastUsedAt = &at
+	return nil
+}
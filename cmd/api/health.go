@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/migrations"
+	"gorm.io/gorm"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+var errDraining = errors.New("server is shutting down")
+
+// healthCheck is a dependency whose failure makes the service not ready.
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+type checkStatus struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+type readinessResponse struct {
+	Status string                 `json:"status"`
+	Error  string                 `json:"error,omitempty"`
+	Checks map[string]checkStatus `json:"checks,omitempty"`
+}
+
+// health serves the liveness and readiness probes.
+type health struct {
+	checks   []healthCheck
+	draining atomic.Bool
+}
+
+func newHealth(checks ...healthCheck) *health {
+	return &health{checks: checks}
+}
+
+// databaseChecks returns the readiness checks for Postgres and its schema.
+// The embedded migrations are parsed once, here, rather than on every probe.
+func databaseChecks(db *gorm.DB) []healthCheck {
+	embedded, embeddedErr := migrations.Embedded()
+	return []healthCheck{
+		{"database", func(ctx context.Context) error {
+			sqlDB, err := db.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.PingContext(ctx)
+		}},
+		{"migrations", func(ctx context.Context) error {
+			if embeddedErr != nil {
+				return embeddedErr
+			}
+			sqlDB, err := db.DB()
+			if err != nil {
+				return err
+			}
+			return migrations.NewWith(sqlDB, embedded).EnsureCurrent(ctx)
+		}},
+	}
+}
+
+// drain makes readiness fail from now on.
+func (h *health) drain() {
+	h.draining.Store(true)
+}
+
+func (h *health) RegisterRoutes(router *gin.Engine) {
+	router.GET("/healthz", h.handleLive)
+	router.GET("/readyz", h.handleReady)
+}
+
+// handleLive reports that the process is up. It checks no dependencies, so a
+// database outage does not get the service restarted.
+func (h *health) handleLive(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReady runs every check concurrently and reports each one. Any
+// failure, or a shutdown in progress, answers 503.
+func (h *health) handleReady(ctx *gin.Context) {
+	if h.draining.Load() {
+		ctx.JSON(http.StatusServiceUnavailable, readinessResponse{Status: "failing", Error: errDraining.Error()})
+		return
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	response := readinessResponse{Status: "ok", Checks: make(map[string]checkStatus, len(h.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c healthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.check(checkCtx)
+			status := checkStatus{Status: "ok", LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = "failing"
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[c.name] = status
+			if err != nil {
+				response.Status = "failing"
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	if response.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, response)
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/config"
+	"github.com/jamadeu/accounts/password"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	var dbErr error
+	h := newHealth(
+		healthCheck{"database", func(ctx context.Context) error { return dbErr }},
+		healthCheck{"migrations", func(ctx context.Context) error { return nil }},
+	)
+	router := gin.Default()
+	h.RegisterRoutes(router)
+
+	get := func(path string) (*httptest.ResponseRecorder, readinessResponse) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+		var body readinessResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w, body
+	}
+
+	t.Run("should always be live", func(t *testing.T) {
+		dbErr = errors.New("connection refused")
+		w, body := get("/healthz")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "ok", body.Status)
+	})
+
+	t.Run("should be ready when every check passes", func(t *testing.T) {
+		dbErr = nil
+		w, body := get("/readyz")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "ok", body.Status)
+		assert.Equal(t, "ok", body.Checks["database"].Status)
+		assert.Equal(t, "ok", body.Checks["migrations"].Status)
+	})
+
+	t.Run("should report the failing dependency", func(t *testing.T) {
+		dbErr = errors.New("connection refused")
+		w, body := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "failing", body.Status)
+		assert.Equal(t, checkStatus{Status: "failing", Error: "connection refused"}, body.Checks["database"])
+		assert.Equal(t, "ok", body.Checks["migrations"].Status)
+	})
+
+	t.Run("should fail while draining", func(t *testing.T) {
+		dbErr = nil
+		h.drain()
+		w, body := get("/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, errDraining.Error(), body.Error)
+	})
+}
+
+func TestReadinessFailsBeforeShutdown(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.ShutdownDelay = 300 * time.Millisecond
+	server := NewApiServer(cfg, nil, nil, nil)
+	server.health = newHealth()
+	router := gin.Default()
+	server.health.RegisterRoutes(router)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.serve(ctx, listener, router) }()
+
+	url := "http://" + listener.Addr().String() + "/readyz"
+	res, err := http.Get(url)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		res, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, <-served)
+}
+
+func TestProbesSkipAuthentication(t *testing.T) {
+	passwords, err := password.NewHasher(password.Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	require.NoError(t, err)
+	server := NewApiServer(config.Default(), nil, nil, passwords)
+	server.health = newHealth()
+	router := server.routes(context.Background())
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Basic not-a-bearer-token")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
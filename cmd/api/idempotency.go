@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	replayedHeader    = "Idempotent-Replayed"
+	maxIdempotencyKey = 255
+	// settleTimeout bounds storing or releasing a reservation once the
+	// request is over.
+	settleTimeout = 5 * time.Second
+)
+
+// recordingWriter copies everything written to the response so it can be
+// stored alongside the idempotency key.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// fingerprint identifies a request by method, URI and body, so a key reused
+// for a different request can be told apart from a genuine retry.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// excludedRoute reports whether path is under one of the excluded prefixes.
+func excludedRoute(path string, excluded []string) bool {
+	for _, prefix := range excluded {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotencyMiddleware replays the stored response of a mutating request when it is
+// retried with the same Idempotency-Key header. Responses with a 5xx status
+// are not stored, so the client may retry them.
+//
+// Responses are stored in plaintext, so routes under the excluded prefixes,
+// whose responses carry credentials, pass through. So do anonymous requests,
+// which have no caller to scope the key to.
+func idempotencyMiddleware(repo schemas.IdempotencyRepository, ttl time.Duration, excluded ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(idempotencyHeader)
+		if key == "" || !isMutating(ctx.Request.Method) || excludedRoute(ctx.FullPath(), excluded) {
+			ctx.Next()
+			return
+		}
+		caller, ok := services.Caller(ctx.Request.Context())
+		if !ok || caller == 0 {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			services.SendError(ctx, http.StatusBadRequest, "header: Idempotency-Key must be at most 255 characters")
+			ctx.Abort()
+			return
+		}
+
+		// Keys are scoped to the caller, so a client can never be served
+		// the stored response of another user's request.
+		key = fmt.Sprintf("%d:%s", caller, key)
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err != nil {
+			services.SendError(ctx, http.StatusBadRequest, "error reading request body")
+			ctx.Abort()
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := schemas.IdempotencyKey{
+			Key:         key,
+			Fingerprint: fingerprint(ctx.Request, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		}
+		existing, err := repo.Reserve(ctx.Request.Context(), &record)
+		if err != nil {
+			services.SendError(ctx, http.StatusInternalServerError, "error checking Idempotency-Key")
+			ctx.Abort()
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != record.Fingerprint:
+				services.SendError(ctx, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+			case !existing.Completed:
+				services.SendError(ctx, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+			default:
+				ctx.Header(replayedHeader, "true")
+				ctx.Data(existing.StatusCode, "application/json", existing.Body)
+			}
+			ctx.Abort()
+			return
+		}
+
+		// The request context is done by the time the outcome is known:
+		// route deadlines cancel it when the handler returns, and so does
+		// a client going away, which is when the retry is coming. The
+		// reservation is settled on a context detached from it.
+		detached := context.WithoutCancel(ctx.Request.Context())
+		settle := func(op string, f func(context.Context) error) {
+			c, cancel := context.WithTimeout(detached, settleTimeout)
+			defer cancel()
+			if err := f(c); err != nil {
+				slog.ErrorContext(c, "error settling idempotency key", "op", op, "error", err)
+			}
+		}
+		release := func(c context.Context) error { return repo.Release(c, key) }
+
+		writer := &recordingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		defer func() {
+			// Never keep a reservation for a request that did not finish,
+			// otherwise every retry would be answered with 409.
+			if r := recover(); r != nil {
+				settle("release", release)
+				panic(r)
+			}
+		}()
+		ctx.Next()
+
+		if status := writer.Status(); status >= http.StatusInternalServerError {
+			settle("release", release)
+		} else {
+			settle("complete", func(c context.Context) error {
+				return repo.Complete(c, key, status, writer.body.Bytes())
+			})
+		}
+	}
+}
+
+// purgeIdempotencyKeys periodically deletes expired keys so the table does
+// not grow without bound. It returns when ctx is done.
+func purgeIdempotencyKeys(ctx context.Context, repo schemas.IdempotencyRepository, ttl time.Duration) {
+	interval := ttl / 4
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := repo.DeleteExpired(ctx, now); err != nil {
+				slog.ErrorContext(ctx, "error purging idempotency keys", "error", err)
+			}
+		}
+	}
+}
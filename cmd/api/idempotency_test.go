@@ -0,0 +1,261 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	repo := &mockIdempotencyRepository{keys: map[string]schemas.IdempotencyKey{}}
+	calls := 0
+	// cancelRequest stands in for the client going away
+	cancelRequest := func() {}
+	router := gin.Default()
+	router.Use(idempotencyMiddleware(repo, time.Hour, "/auth"))
+	router.POST("/resource", func(ctx *gin.Context) {
+		calls++
+		services.SendSuccess(ctx, "create-resource", calls)
+	})
+	router.POST("/abandoned", func(ctx *gin.Context) {
+		calls++
+		services.SendSuccess(ctx, "create-resource", calls)
+		cancelRequest()
+	})
+	router.POST("/bounded", services.Deadline(time.Second), func(ctx *gin.Context) {
+		calls++
+		if ctx.GetHeader("X-Fail") != "" {
+			services.SendError(ctx, http.StatusInternalServerError, "boom")
+			return
+		}
+		services.SendSuccess(ctx, "create-resource", calls)
+	})
+	router.POST("/failing", func(ctx *gin.Context) {
+		calls++
+		services.SendError(ctx, http.StatusInternalServerError, "boom")
+	})
+	router.POST("/auth/login", func(ctx *gin.Context) {
+		calls++
+		services.SendSuccess(ctx, "login", calls)
+	})
+
+	// Keys are only honoured for identified callers
+	asCaller := func(req *http.Request) *http.Request {
+		return req.WithContext(services.WithCaller(req.Context(), 7))
+	}
+
+	send := func(path, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set(idempotencyHeader, key)
+		}
+		router.ServeHTTP(w, asCaller(req))
+		return w
+	}
+
+	t.Run("should replay the stored response on retry", func(t *testing.T) {
+		calls = 0
+		first := send("/resource", "key-1", `{"a":1}`)
+		second := send("/resource", "key-1", `{"a":1}`)
+
+		expectedResponseBody := "{\"data\":1,\"message\":\"operation from handler: create-resource successfull\"}"
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, expectedResponseBody, first.Body.String())
+		assert.Equal(t, expectedResponseBody, second.Body.String())
+		assert.Equal(t, "true", second.Header().Get(replayedHeader))
+	})
+
+	t.Run("should return 422 when key is reused with a different body", func(t *testing.T) {
+		calls = 0
+		send("/resource", "key-2", `{"a":1}`)
+		w := send("/resource", "key-2", `{"a":2}`)
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"Idempotency-Key was already used with a different request\"}"
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("should return 409 while the original request is in progress", func(t *testing.T) {
+		repo.Reserve(context.Background(), &schemas.IdempotencyKey{Key: "7:key-3", Fingerprint: "pending"})
+		w := send("/resource", "key-3", `{}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		repo.keys["7:key-3"] = schemas.IdempotencyKey{Key: "7:key-3", Fingerprint: fingerprintOf("/resource", `{}`)}
+		w = send("/resource", "key-3", `{}`)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should not replay the response of another caller", func(t *testing.T) {
+		calls = 0
+		for _, caller := range []uint{1, 2} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/resource", bytes.NewBufferString(`{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(idempotencyHeader, "shared-key")
+			router.ServeHTTP(w, req.WithContext(services.WithCaller(req.Context(), caller)))
+			assert.Empty(t, w.Header().Get(replayedHeader))
+		}
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("should not store server errors", func(t *testing.T) {
+		calls = 0
+		send("/failing", "key-4", `{}`)
+		send("/failing", "key-4", `{}`)
+
+		assert.Equal(t, 2, calls)
+		_, stored := repo.keys["7:key-4"]
+		assert.False(t, stored)
+	})
+
+	t.Run("should store the response when the client went away", func(t *testing.T) {
+		calls = 0
+		c, cancel := context.WithCancel(context.Background())
+		cancelRequest = cancel
+		defer func() { cancelRequest = func() {} }()
+		w := httptest.NewRecorder()
+		req, err := http.NewRequestWithContext(c, "POST", "/abandoned", bytes.NewBufferString(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(idempotencyHeader, "key-5")
+		router.ServeHTTP(w, asCaller(req))
+		retry := send("/abandoned", "key-5", `{}`)
+
+		expectedResponseBody := "{\"data\":1,\"message\":\"operation from handler: create-resource successfull\"}"
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusOK, retry.Code)
+		assert.Equal(t, expectedResponseBody, retry.Body.String())
+		assert.Equal(t, "true", retry.Header().Get(replayedHeader))
+	})
+
+	t.Run("should settle keys of routes with a deadline", func(t *testing.T) {
+		calls = 0
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/bounded", bytes.NewBufferString(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(idempotencyHeader, "key-6")
+		req.Header.Set("X-Fail", "true")
+		router.ServeHTTP(w, asCaller(req))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		_, stored := repo.keys["7:key-6"]
+		assert.False(t, stored, "failed attempts are released")
+
+		send("/bounded", "key-6", `{}`)
+		retry := send("/bounded", "key-6", `{}`)
+
+		expectedResponseBody := "{\"data\":2,\"message\":\"operation from handler: create-resource successfull\"}"
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusOK, retry.Code)
+		assert.Equal(t, expectedResponseBody, retry.Body.String())
+		assert.Equal(t, "true", retry.Header().Get(replayedHeader))
+	})
+
+	t.Run("should pass anonymous requests through", func(t *testing.T) {
+		calls = 0
+		for range 2 {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/resource", bytes.NewBufferString(`{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(idempotencyHeader, "key-7")
+			router.ServeHTTP(w, req)
+			assert.Empty(t, w.Header().Get(replayedHeader))
+		}
+
+		assert.Equal(t, 2, calls)
+		_, stored := repo.keys["0:key-7"]
+		assert.False(t, stored)
+	})
+
+	t.Run("should pass excluded routes through", func(t *testing.T) {
+		calls = 0
+		send("/auth/login", "key-8", `{}`)
+		w := send("/auth/login", "key-8", `{}`)
+
+		assert.Equal(t, 2, calls)
+		assert.Empty(t, w.Header().Get(replayedHeader))
+		_, stored := repo.keys["7:key-8"]
+		assert.False(t, stored)
+	})
+
+	t.Run("should pass requests without a key through", func(t *testing.T) {
+		calls = 0
+		send("/resource", "", `{}`)
+		send("/resource", "", `{}`)
+
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func fingerprintOf(path, body string) string {
+	req, _ := http.NewRequest("POST", path, nil)
+	return fingerprint(req, []byte(body))
+}
+
+type mockIdempotencyRepository struct {
+	mu   sync.Mutex
+	keys map[string]schemas.IdempotencyKey
+}
+
+func (m *mockIdempotencyRepository) Reserve(ctx context.Context, key *schemas.IdempotencyKey) (*schemas.IdempotencyKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.keys[key.Key]; ok {
+		return &existing, nil
+	}
+	m.keys[key.Key] = *key
+	return nil, nil
+}
+
+func (m *mockIdempotencyRepository) Complete(ctx context.Context, key string, statusCode int, body []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record := m.keys[key]
+	record.Completed = true
+	record.StatusCode = statusCode
+	record.Body = body
+	m.keys[key] = record
+	return nil
+}
+
+func (m *mockIdempotencyRepository) Release(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, key)
+	return nil
+}
+
+func (m *mockIdempotencyRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
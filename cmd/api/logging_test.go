@@ -0,0 +1,92 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/logging"
+	"github.com/jamadeu/accounts/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogging(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := logging.New(buf, "json", "info")
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(requestID(), accessLog(logger), recovery(logger))
+	router.GET("/resource/:id", func(ctx *gin.Context) {
+		logger.InfoContext(ctx.Request.Context(), "in handler")
+		services.SendError(ctx, http.StatusNotFound, "not found")
+	})
+	router.GET("/panic", func(ctx *gin.Context) {
+		panic("boom")
+	})
+
+	send := func(path, id string) *httptest.ResponseRecorder {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != "" {
+			req.Header.Set(logging.RequestIDHeader, id)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	records := func() []map[string]any {
+		out := []map[string]any{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			record := map[string]any{}
+			require.NoError(t, json.Unmarshal([]byte(line), &record))
+			out = append(out, record)
+		}
+		return out
+	}
+
+	t.Run("should echo the client request ID in header, body and logs", func(t *testing.T) {
+		w := send("/resource/7", "client-id-1")
+
+		assert.Equal(t, "client-id-1", w.Header().Get(logging.RequestIDHeader))
+		assert.Equal(t, `{"errorCode":404,"message":"not found","requestId":"client-id-1"}`, w.Body.String())
+		logged := records()
+		require.Len(t, logged, 2)
+		assert.Equal(t, "in handler", logged[0]["msg"])
+		assert.Equal(t, "client-id-1", logged[0]["request_id"])
+		assert.Equal(t, "request", logged[1]["msg"])
+		assert.Equal(t, "WARN", logged[1]["level"])
+		assert.Equal(t, "/resource/:id", logged[1]["route"])
+		assert.Equal(t, float64(404), logged[1]["status"])
+		assert.Equal(t, "client-id-1", logged[1]["request_id"])
+	})
+
+	t.Run("should generate a request ID when missing or invalid", func(t *testing.T) {
+		for _, id := range []string{"", "has spaces", strings.Repeat("x", maxRequestID+1)} {
+			w := send("/resource/7", id)
+			generated := w.Header().Get(logging.RequestIDHeader)
+			assert.Len(t, generated, 32)
+			assert.NotEqual(t, id, generated)
+		}
+	})
+
+	t.Run("should log panics with the request ID", func(t *testing.T) {
+		w := send("/panic", "client-id-2")
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), `"requestId":"client-id-2"`)
+		logged := records()
+		require.Len(t, logged, 2)
+		assert.Equal(t, "panic serving request", logged[0]["msg"])
+		assert.Equal(t, "client-id-2", logged[0]["request_id"])
+		assert.Equal(t, "ERROR", logged[1]["level"])
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jamadeu/accounts/config"
+	"github.com/jamadeu/accounts/ledger"
+)
+
+// runCheckLedger implements the check-ledger subcommand. It prints every
+// invariant violation Ledger.Check finds and fails when there is any, so it
+// can run as a scheduled job. args are the configuration flags the server
+// takes.
+func runCheckLedger(args []string) error {
+	cfg, err := config.Load(args)
+	if err != nil {
+		return err
+	}
+	db, err := config.ConnectDb(cfg.DB)
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	report, err := ledger.New(db).Check(context.Background())
+	if err != nil && !errors.Is(err, ledger.ErrInvariantViolation) {
+		return err
+	}
+	currencies := make([]string, 0, len(report.UnbalancedCurrencies))
+	for currency := range report.UnbalancedCurrencies {
+		currencies = append(currencies, currency)
+	}
+	slices.Sort(currencies)
+	for _, currency := range currencies {
+		fmt.Printf("%s postings are off by %s\n", currency, report.UnbalancedCurrencies[currency])
+	}
+	for _, id := range report.UnbalancedEntries {
+		fmt.Printf("journal entry %d does not balance\n", id)
+	}
+	for _, id := range report.StaleBalances {
+		fmt.Printf("account %d balance does not match its postings\n", id)
+	}
+	if err == nil {
+		fmt.Println("ledger is consistent")
+	}
+	return err
+}
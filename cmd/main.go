@@ -1,19 +1,112 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
 	"github.com/jamadeu/accounts/cmd/api"
 	"github.com/jamadeu/accounts/config"
+	"github.com/jamadeu/accounts/logging"
+	"github.com/jamadeu/accounts/migrations"
+	"github.com/jamadeu/accounts/password"
+	"github.com/jamadeu/accounts/token"
+	"github.com/jamadeu/accounts/tracing"
 )
 
+// commands are the subcommands run instead of the server.
+var commands = map[string]func(args []string) error{
+	"migrate":      runMigrate,
+	"check-ledger": runCheckLedger,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal("setting up tracing", err)
+	}
+
+	keys, err := token.LoadKeys(cfg.Auth.Algorithm, cfg.Auth.HMACKeys, cfg.Auth.RSAKeys)
+	if err != nil {
+		fatal("loading signing keys", err)
+	}
+	issuer := token.NewIssuer(keys, cfg.Auth.Issuer, cfg.Auth.AccessTTL, cfg.Auth.RefreshTTL)
+	passwords, err := password.NewHasher(password.Params{
+		Memory:      uint32(cfg.Auth.Argon2Memory),
+		Iterations:  uint32(cfg.Auth.Argon2Iterations),
+		Parallelism: uint8(cfg.Auth.Argon2Parallelism),
+		SaltLength:  password.DefaultParams.SaltLength,
+		KeyLength:   password.DefaultParams.KeyLength,
+	})
+	if err != nil {
+		fatal("configuring password hashing", err)
+	}
 
-	db, err := config.ConnectDb()
+	db, err := config.ConnectDb(cfg.DB)
+	if err != nil {
+		fatal("connecting to database", err)
+	}
+
+	// Refuse to serve against a schema this build does not expect
+	sqlDB, err := db.DB()
+	if err != nil {
+		fatal("connecting to database", err)
+	}
+	migrator, err := migrations.New(sqlDB)
 	if err != nil {
-		panic(err)
+		fatal("loading migrations", err)
+	}
+	if cfg.Features.MigrateOnStart {
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			fatal("applying migrations", err)
+		}
+		for _, m := range applied {
+			slog.Info("applied migration", "version", m.Version, "name", m.Name)
+		}
+	}
+	if err := migrator.EnsureCurrent(context.Background()); err != nil {
+		fatal("checking schema version", err)
 	}
 
-	server := api.NewApiServer(":8080", db)
-	if err := server.Run(); err != nil {
-		panic(err)
+	server := api.NewApiServer(cfg, db, issuer, passwords)
+	err = server.Run()
+	if shutdownErr := shutdownTracing(context.Background()); shutdownErr != nil {
+		slog.Error("flushing traces", "error", shutdownErr)
 	}
+	if err != nil {
+		fatal("serving", err)
+	}
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jamadeu/accounts/config"
+	"github.com/jamadeu/accounts/migrations"
+)
+
+const migrateUsage = `usage: accounts migrate [-dir dir] <command> [configuration flags]
+
+commands:
+  up            apply all pending migrations
+  down [n]      revert the last n applied migrations (default 1)
+  status        list migrations and when they were applied
+  create <name> write an empty up/down pair to -dir
+
+The configuration flags, such as -config, -env-file and -db-host, are the
+ones the server takes.
+`
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := flags.String("dir", migrations.Dir, "directory for new migration files")
+	flags.Usage = func() { fmt.Fprint(flags.Output(), migrateUsage); flags.PrintDefaults() }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("missing migrate command")
+	}
+
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	// Operands of the command come first, configuration flags after them
+	operands := len(rest)
+	for i, arg := range rest {
+		if strings.HasPrefix(arg, "-") {
+			operands = i
+			break
+		}
+	}
+	rest, configArgs := rest[:operands], rest[operands:]
+	if command == "create" {
+		if len(rest) != 1 {
+			return fmt.Errorf("create takes exactly one name")
+		}
+		paths, err := migrations.Create(*dir, rest[0])
+		for _, path := range paths {
+			fmt.Println("created", path)
+		}
+		return err
+	}
+
+	cfg, err := config.Load(configArgs)
+	if err != nil {
+		return err
+	}
+	db, err := config.ConnectDb(cfg.DB)
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(rest) > 0 {
+			if steps, err = strconv.Atoi(rest[0]); err != nil || steps < 1 {
+				return fmt.Errorf("down takes a positive number of steps")
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 -0700")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+}
@@ -0,0 +1,329 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the service configuration. Values are resolved from, in
+// increasing order of precedence: defaults, the YAML file named by
+// CONFIG_FILE or -config, a .env file, environment variables and flags.
+//
+// Every variable can also be read from a file by setting NAME_FILE to its
+// path, e.g. DB_PASSWORD_FILE for container secrets.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	DB          DBConfig          `yaml:"db"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Features    FeaturesConfig    `yaml:"features"`
+	Log         LogConfig         `yaml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Auth        AuthConfig        `yaml:"auth"`
+}
+
+type ServerConfig struct {
+	PublicHost        string        `yaml:"publicHost" env:"PUBLIC_HOST"`
+	Port              int           `yaml:"port" env:"PORT" flag:"port"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"SERVER_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT"`
+	ShutdownTimeout   time.Duration `yaml:"shutdownTimeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+	// ShutdownDelay is how long /readyz reports failure before the server
+	// stops accepting connections, so load balancers can stop routing.
+	ShutdownDelay time.Duration `yaml:"shutdownDelay" env:"SERVER_SHUTDOWN_DELAY"`
+}
+
+// Addr is the address the HTTP server listens on.
+func (c ServerConfig) Addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+type DBConfig struct {
+	Host            string        `yaml:"host" env:"DB_HOST" flag:"db-host"`
+	Port            int           `yaml:"port" env:"DB_PORT" flag:"db-port"`
+	User            string        `yaml:"user" env:"DB_USER" flag:"db-user"`
+	Password        string        `yaml:"password" env:"DB_PASSWORD"`
+	Name            string        `yaml:"name" env:"DB_NAME" flag:"db-name"`
+	SSLMode         string        `yaml:"sslMode" env:"DB_SSLMODE"`
+	TimeZone        string        `yaml:"timeZone" env:"DB_TIMEZONE"`
+	MaxOpenConns    int           `yaml:"maxOpenConns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"maxIdleConns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime" env:"DB_CONN_MAX_IDLE_TIME"`
+	// SlowQueryThreshold logs queries taking longer as warnings.
+	SlowQueryThreshold time.Duration `yaml:"slowQueryThreshold" env:"DB_SLOW_QUERY_THRESHOLD"`
+}
+
+// DSN returns the connection string for the Postgres driver.
+func (c DBConfig) DSN() string {
+	params := []struct{ key, value string }{
+		{"host", c.Host},
+		{"port", fmt.Sprint(c.Port)},
+		{"user", c.User},
+		{"password", c.Password},
+		{"dbname", c.Name},
+		{"sslmode", c.SSLMode},
+		{"TimeZone", c.TimeZone},
+	}
+	parts := make([]string, 0, len(params))
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p.value)
+		parts = append(parts, fmt.Sprintf("%s='%s'", p.key, value))
+	}
+	return strings.Join(parts, " ")
+}
+
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn or error.
+	Level string `yaml:"level" env:"LOG_LEVEL" flag:"log-level"`
+	// Format is json or text.
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp. The OTLP endpoint is read from the
+	// standard OTEL_EXPORTER_OTLP_* variables.
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER"`
+	ServiceName string  `yaml:"serviceName" env:"OTEL_SERVICE_NAME"`
+	SampleRatio float64 `yaml:"sampleRatio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+type AuthConfig struct {
+	// Algorithm is HS256 or RS256.
+	Algorithm string `yaml:"algorithm" env:"AUTH_ALGORITHM"`
+	// HMACKeys is a comma separated list of id:secret pairs used with HS256.
+	// The first key signs new tokens; the others only verify, which allows
+	// rotating keys without invalidating issued tokens.
+	HMACKeys string `yaml:"hmacKeys" env:"AUTH_HMAC_KEYS"`
+	// RSAKeys is a comma separated list of id:path pairs naming PEM private
+	// keys used with RS256, ordered as HMACKeys. Their public parts are
+	// published at /.well-known/jwks.json.
+	RSAKeys    string        `yaml:"rsaKeys" env:"AUTH_RSA_KEYS"`
+	Issuer     string        `yaml:"issuer" env:"AUTH_ISSUER"`
+	AccessTTL  time.Duration `yaml:"accessTTL" env:"AUTH_ACCESS_TTL"`
+	RefreshTTL time.Duration `yaml:"refreshTTL" env:"AUTH_REFRESH_TTL"`
+	// Argon2Memory (in KiB), Argon2Iterations and Argon2Parallelism set the
+	// cost of hashing new passwords. Stored hashes made with other values
+	// are upgraded on the next successful login.
+	Argon2Memory      int `yaml:"argon2Memory" env:"AUTH_ARGON2_MEMORY"`
+	Argon2Iterations  int `yaml:"argon2Iterations" env:"AUTH_ARGON2_ITERATIONS"`
+	Argon2Parallelism int `yaml:"argon2Parallelism" env:"AUTH_ARGON2_PARALLELISM"`
+	// PasswordMinLength and PasswordMinClasses are the password policy; the
+	// classes are lower case, upper case, digits and symbols.
+	PasswordMinLength  int `yaml:"passwordMinLength" env:"AUTH_PASSWORD_MIN_LENGTH"`
+	PasswordMinClasses int `yaml:"passwordMinClasses" env:"AUTH_PASSWORD_MIN_CLASSES"`
+	// MaxFailedLogins consecutive wrong passwords lock the user out for
+	// LockoutDuration.
+	MaxFailedLogins int           `yaml:"maxFailedLogins" env:"AUTH_MAX_FAILED_LOGINS"`
+	LockoutDuration time.Duration `yaml:"lockoutDuration" env:"AUTH_LOCKOUT_DURATION"`
+	ResetTokenTTL   time.Duration `yaml:"resetTokenTTL" env:"AUTH_RESET_TOKEN_TTL"`
+}
+
+type FeaturesConfig struct {
+	// Idempotency enables the Idempotency-Key middleware.
+	Idempotency bool `yaml:"idempotency" env:"FEATURE_IDEMPOTENCY"`
+	// MigrateOnStart applies pending migrations at startup instead of
+	// refusing to start.
+	MigrateOnStart bool `yaml:"migrateOnStart" env:"FEATURE_MIGRATE_ON_START" flag:"migrate-on-start"`
+}
+
+// Default returns the configuration used when nothing overrides it.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			PublicHost:        "http://localhost",
+			Port:              8080,
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+			ShutdownDelay:     5 * time.Second,
+		},
+		DB: DBConfig{
+			Host:               "localhost",
+			Port:               5432,
+			User:               "postgres",
+			Name:               "postgres",
+			SSLMode:            "disable",
+			TimeZone:           "America/Sao_Paulo",
+			MaxOpenConns:       25,
+			MaxIdleConns:       10,
+			ConnMaxLifetime:    30 * time.Minute,
+			ConnMaxIdleTime:    5 * time.Minute,
+			SlowQueryThreshold: 200 * time.Millisecond,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL: 24 * time.Hour,
+		},
+		Features: FeaturesConfig{
+			Idempotency: true,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "accounts",
+			SampleRatio: 1,
+		},
+		Auth: AuthConfig{
+			Algorithm:  "HS256",
+			Issuer:     "accounts",
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 30 * 24 * time.Hour,
+
+			Argon2Memory:       64 * 1024,
+			Argon2Iterations:   3,
+			Argon2Parallelism:  2,
+			PasswordMinLength:  12,
+			PasswordMinClasses: 3,
+			MaxFailedLogins:    5,
+			LockoutDuration:    15 * time.Minute,
+			ResetTokenTTL:      30 * time.Minute,
+		},
+	}
+}
+
+// Load resolves the configuration from the command line arguments (without
+// the program name), the environment and the optional files.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("accounts", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML configuration file (default $CONFIG_FILE)")
+	envFile := flags.String("env-file", ".env", "file with KEY=VALUE lines to read into the environment")
+	overrides := registerFlags(flags, &cfg)
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	env, err := readEnvFile(*envFile)
+	if err != nil {
+		return cfg, err
+	}
+	lookup := func(key string) (string, bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			return value, true
+		}
+		value, ok := env[key]
+		return value, ok
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookup("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		content, err := os.ReadFile(*configFile)
+		if err != nil {
+			return cfg, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+
+	if err := applyEnv(&cfg, lookup); err != nil {
+		return cfg, err
+	}
+	if err := overrides(); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port < 65536, "PORT must be between 1 and 65535, got %d", c.Server.Port)
+	if c.Server.PublicHost != "" {
+		u, err := url.Parse(c.Server.PublicHost)
+		check(err == nil && u.Scheme != "" && u.Host != "", "PUBLIC_HOST must be an absolute URL, got %q", c.Server.PublicHost)
+	}
+	check(c.Server.ReadTimeout >= 0, "SERVER_READ_TIMEOUT must not be negative")
+	check(c.Server.ReadHeaderTimeout >= 0, "SERVER_READ_HEADER_TIMEOUT must not be negative")
+	check(c.Server.WriteTimeout >= 0, "SERVER_WRITE_TIMEOUT must not be negative")
+	check(c.Server.IdleTimeout >= 0, "SERVER_IDLE_TIMEOUT must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "SERVER_SHUTDOWN_TIMEOUT must be positive")
+	check(c.Server.ShutdownDelay >= 0, "SERVER_SHUTDOWN_DELAY must not be negative")
+
+	check(c.DB.Host != "", "DB_HOST is required")
+	check(c.DB.Port > 0 && c.DB.Port < 65536, "DB_PORT must be between 1 and 65535, got %d", c.DB.Port)
+	check(c.DB.User != "", "DB_USER is required")
+	check(c.DB.Name != "", "DB_NAME is required")
+	switch c.DB.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		check(false, "DB_SSLMODE %q is not a valid sslmode", c.DB.SSLMode)
+	}
+	if c.DB.TimeZone != "" {
+		_, err := time.LoadLocation(c.DB.TimeZone)
+		check(err == nil, "DB_TIMEZONE %q is not a known time zone", c.DB.TimeZone)
+	}
+	check(c.DB.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", c.DB.MaxIdleConns, c.DB.MaxOpenConns)
+	check(c.DB.ConnMaxLifetime >= 0, "DB_CONN_MAX_LIFETIME must not be negative")
+	check(c.DB.ConnMaxIdleTime >= 0, "DB_CONN_MAX_IDLE_TIME must not be negative")
+
+	check(c.DB.SlowQueryThreshold >= 0, "DB_SLOW_QUERY_THRESHOLD must not be negative")
+
+	check(c.Idempotency.TTL > 0, "IDEMPOTENCY_TTL must be positive")
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "LOG_LEVEL %q must be debug, info, warn or error", c.Log.Level)
+	}
+	check(c.Log.Format == "json" || c.Log.Format == "text", "LOG_FORMAT %q must be json or text", c.Log.Format)
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		check(false, "TRACING_EXPORTER %q must be none, stdout or otlp", c.Tracing.Exporter)
+	}
+	check(c.Tracing.ServiceName != "", "OTEL_SERVICE_NAME is required")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+
+	check(c.Auth.Algorithm == "HS256" || c.Auth.Algorithm == "RS256", "AUTH_ALGORITHM %q must be HS256 or RS256", c.Auth.Algorithm)
+	check(c.Auth.Issuer != "", "AUTH_ISSUER is required")
+	check(c.Auth.AccessTTL > 0, "AUTH_ACCESS_TTL must be positive")
+	check(c.Auth.RefreshTTL > c.Auth.AccessTTL, "AUTH_REFRESH_TTL must be longer than AUTH_ACCESS_TTL")
+	check(c.Auth.Argon2Parallelism > 0 && c.Auth.Argon2Parallelism < 256, "AUTH_ARGON2_PARALLELISM must be between 1 and 255")
+	check(c.Auth.Argon2Memory >= 8*c.Auth.Argon2Parallelism, "AUTH_ARGON2_MEMORY must be at least 8 KiB per unit of parallelism")
+	check(c.Auth.Argon2Iterations > 0, "AUTH_ARGON2_ITERATIONS must be positive")
+	check(c.Auth.PasswordMinLength >= 8, "AUTH_PASSWORD_MIN_LENGTH must be at least 8")
+	check(c.Auth.PasswordMinClasses >= 1 && c.Auth.PasswordMinClasses <= 4, "AUTH_PASSWORD_MIN_CLASSES must be between 1 and 4")
+	check(c.Auth.MaxFailedLogins > 0, "AUTH_MAX_FAILED_LOGINS must be positive")
+	check(c.Auth.LockoutDuration > 0, "AUTH_LOCKOUT_DURATION must be positive")
+	check(c.Auth.ResetTokenTTL > 0, "AUTH_RESET_TOKEN_TTL must be positive")
+
+	return errors.Join(errs...)
+}
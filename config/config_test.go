@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	noEnvFile := []string{"-env-file", filepath.Join(dir, "missing.env")}
+
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := Load(noEnvFile)
+		require.NoError(t, err)
+		assert.Equal(t, Default(), cfg)
+		assert.Equal(t, ":8080", cfg.Server.Addr())
+	})
+
+	t.Run("precedence of file, env file, environment and flags", func(t *testing.T) {
+		yamlFile := write("config.yaml", "server:\n  port: 7000\n  writeTimeout: 1m\ndb:\n  host: yaml-host\n  name: yaml-db\n  user: yaml-user\n")
+		envFile := write("test.env", "# comment\nexport DB_HOST=dotenv-host\nDB_NAME=\"dotenv-db\"\n")
+		t.Setenv("CONFIG_FILE", yamlFile)
+		t.Setenv("DB_NAME", "env-db")
+
+		cfg, err := Load([]string{"-env-file", envFile, "-port", "9000"})
+		require.NoError(t, err)
+		assert.Equal(t, 9000, cfg.Server.Port)
+		assert.Equal(t, time.Minute, cfg.Server.WriteTimeout)
+		assert.Equal(t, "yaml-user", cfg.DB.User)
+		assert.Equal(t, "dotenv-host", cfg.DB.Host)
+		assert.Equal(t, "env-db", cfg.DB.Name)
+	})
+
+	t.Run("secrets from files", func(t *testing.T) {
+		t.Setenv("DB_PASSWORD", "ignored")
+		t.Setenv("DB_PASSWORD_FILE", write("password", " s3cr3t' \n"))
+
+		cfg, err := Load(noEnvFile)
+		require.NoError(t, err)
+		assert.Equal(t, " s3cr3t' ", cfg.DB.Password)
+		assert.Contains(t, cfg.DB.DSN(), `password=' s3cr3t\' '`)
+	})
+
+	t.Run("unknown yaml keys are rejected", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", write("typo.yaml", "db:\n  hots: x\n"))
+		_, err := Load(noEnvFile)
+		assert.ErrorContains(t, err, "hots")
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		t.Setenv("DB_PORT", "postgres")
+		_, err := Load(noEnvFile)
+		assert.ErrorContains(t, err, `DB_PORT: invalid integer "postgres"`)
+	})
+
+	t.Run("validation reports every problem", func(t *testing.T) {
+		t.Setenv("PORT", "0")
+		t.Setenv("DB_SSLMODE", "sometimes")
+		t.Setenv("DB_MAX_OPEN_CONNS", "2")
+		t.Setenv("AUTH_LOCKOUT_DURATION", "0s")
+		_, err := Load(noEnvFile)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "PORT must be between 1 and 65535")
+		assert.ErrorContains(t, err, `DB_SSLMODE "sometimes"`)
+		assert.ErrorContains(t, err, "DB_MAX_IDLE_CONNS (10) must not exceed DB_MAX_OPEN_CONNS (2)")
+		assert.ErrorContains(t, err, "AUTH_LOCKOUT_DURATION must be positive")
+	})
+}
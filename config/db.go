@@ -1,32 +1,41 @@
 package config
 
 import (
-	"github.com/jamadeu/accounts/schemas"
+	"log/slog"
+
+	"github.com/jamadeu/accounts/logging"
+	"github.com/jamadeu/accounts/metrics"
+	"github.com/jamadeu/accounts/tracing"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-func ConnectDb() (*gorm.DB, error) {
-	// logger := GetLogger("InitializeDb")
-	// Connect DB
-	dsn := "host=localhost user=postgres password=1234 dbname=postgres port=5432 sslmode=disable TimeZone=America/Sao_Paulo"
-	// fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=America/Sao_Paulo",
-	// os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"), os.Getenv("DB_PORT"))
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// ConnectDb opens the database described by cfg, logging queries through
+// the default slog logger, installs the query metrics and tracing and sizes
+// its connection pool. The schema is managed by the migrations package; see the
+// migrate subcommand.
+func ConnectDb(cfg DBConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{
+		Logger: logging.NewGormLogger(slog.Default(), cfg.SlowQueryThreshold),
+	})
 	if err != nil {
-		// fmt.Errorf("Error to connect database: %v", err)
 		return nil, err
 	}
 
-	// Migrate the schema
-	if err = db.AutoMigrate(&schemas.User{}, &schemas.Transaction{}); err != nil {
-		// fmt.Errorf("Automigratoin error: %v", err)
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		return nil, err
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
 		return nil, err
 	}
-	if err = db.AutoMigrate(&schemas.Account{}); err != nil {
-		// fmt.Errorf("Automigratoin error: %v", err)
+
+	sqlDB, err := db.DB()
+	if err != nil {
 		return nil, err
 	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 	return db, nil
 }
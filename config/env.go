@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// readEnvFile parses KEY=VALUE lines from path. A missing file is not an
+// error. Blank lines, comments and an optional "export " prefix are ignored,
+// and values may be wrapped in single or double quotes.
+func readEnvFile(path string) (map[string]string, error) {
+	env := map[string]string{}
+	if path == "" {
+		return env, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return env, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[strings.TrimSpace(key)] = value
+	}
+	return env, scanner.Err()
+}
+
+// field is a configuration value tagged with its environment variable and,
+// optionally, its flag.
+type field struct {
+	env   string
+	flag  string
+	value reflect.Value
+}
+
+func fields(cfg *Config) []field {
+	var out []field
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			sf := v.Type().Field(i)
+			if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+				walk(v.Field(i))
+				continue
+			}
+			if env := sf.Tag.Get("env"); env != "" {
+				out = append(out, field{env: env, flag: sf.Tag.Get("flag"), value: v.Field(i)})
+			}
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+	return out
+}
+
+// applyEnv sets every field whose variable, or NAME_FILE variable, is
+// defined.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	errs := []error{}
+	for _, f := range fields(cfg) {
+		raw, ok := lookup(f.env)
+		if path, fromFile := lookup(f.env + "_FILE"); fromFile && path != "" {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s_FILE: %w", f.env, err))
+				continue
+			}
+			raw, ok = strings.TrimRight(string(content), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+		if err := set(f.value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// registerFlags defines a flag for every tagged field. The returned function
+// applies the flags given on the command line, so they take precedence over
+// values loaded after parsing.
+func registerFlags(flags *flag.FlagSet, cfg *Config) func() error {
+	given := map[string]string{}
+	targets := map[string]field{}
+	for _, f := range fields(cfg) {
+		if f.flag == "" {
+			continue
+		}
+		name := f.flag
+		targets[name] = f
+		flags.Func(name, "overrides $"+f.env, func(value string) error {
+			given[name] = value
+			return nil
+		})
+	}
+	return func() error {
+		errs := []error{}
+		for name, value := range given {
+			if err := set(targets[name].value, value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", name, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// set parses raw into v as is. Values read from env files are trimmed when
+// read; secrets from NAME_FILE only lose their trailing line break.
+func set(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
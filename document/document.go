@@ -0,0 +1,155 @@
+// Package document validates, formats and normalizes Brazilian taxpayer
+// documents: CPF for individuals and CNPJ for companies, including the
+// alphanumeric CNPJ issued from July 2026.
+package document
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Type string
+
+const (
+	CPF  Type = "CPF"
+	CNPJ Type = "CNPJ"
+
+	cpfLength  = 11
+	cnpjLength = 14
+)
+
+var (
+	ErrEmpty              = errors.New("document is empty")
+	ErrInvalidLength      = errors.New("invalid document length")
+	ErrInvalidCharacter   = errors.New("invalid document character")
+	ErrRepeatedDigits     = errors.New("document cannot be a single repeated digit")
+	ErrInvalidCheckDigits = errors.New("check digits do not match")
+)
+
+// Normalize strips the usual punctuation (dots, dashes, slashes and spaces)
+// and upper-cases letters, which is the form documents are stored in.
+func Normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '-', '/', ' ':
+			return -1
+		}
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}, s)
+}
+
+// Detect tells CPF and CNPJ apart by the length of the normalized document.
+// It does not check the digits.
+func Detect(s string) (Type, error) {
+	n := Normalize(s)
+	switch len(n) {
+	case 0:
+		return "", ErrEmpty
+	case cpfLength:
+		return CPF, nil
+	case cnpjLength:
+		return CNPJ, nil
+	}
+	return "", fmt.Errorf("%w: expected %d characters for CPF or %d for CNPJ, got %d",
+		ErrInvalidLength, cpfLength, cnpjLength, len(n))
+}
+
+// Validate detects the document type and verifies its characters and check
+// digits.
+func Validate(s string) (Type, error) {
+	t, err := Detect(s)
+	if err != nil {
+		return "", err
+	}
+	n := Normalize(s)
+	if t == CPF {
+		return t, validateCPF(n)
+	}
+	return t, validateCNPJ(n)
+}
+
+// Format returns the document with its conventional punctuation, e.g.
+// 123.456.789-09 or 12.ABC.345/01DE-35.
+func Format(s string) (string, error) {
+	t, err := Validate(s)
+	if err != nil {
+		return "", err
+	}
+	n := Normalize(s)
+	if t == CPF {
+		return n[0:3] + "." + n[3:6] + "." + n[6:9] + "-" + n[9:11], nil
+	}
+	return n[0:2] + "." + n[2:5] + "." + n[5:8] + "/" + n[8:12] + "-" + n[12:14], nil
+}
+
+func validateCPF(n string) error {
+	for i, r := range n {
+		if r < '0' || r > '9' {
+			return fmt.Errorf("%w: CPF only accepts digits, got %q at position %d", ErrInvalidCharacter, r, i+1)
+		}
+	}
+	if strings.Count(n, n[:1]) == len(n) {
+		return fmt.Errorf("%w: CPF %s", ErrRepeatedDigits, n)
+	}
+	if checkDigit(n[:9], 10) != n[9] || checkDigit(n[:10], 11) != n[10] {
+		return fmt.Errorf("CPF %w", ErrInvalidCheckDigits)
+	}
+	return nil
+}
+
+func validateCNPJ(n string) error {
+	for i, r := range n {
+		alnum := (r >= '0' && r <= '9') || (r >= 'A' && r <= 'Z')
+		if i >= 12 && (r < '0' || r > '9') {
+			return fmt.Errorf("%w: CNPJ check digits must be numeric, got %q at position %d", ErrInvalidCharacter, r, i+1)
+		}
+		if !alnum {
+			return fmt.Errorf("%w: CNPJ only accepts digits and letters, got %q at position %d", ErrInvalidCharacter, r, i+1)
+		}
+	}
+	if strings.Count(n, n[:1]) == len(n) {
+		return fmt.Errorf("%w: CNPJ %s", ErrRepeatedDigits, n)
+	}
+	if cnpjCheckDigit(n[:12]) != n[12] || cnpjCheckDigit(n[:13]) != n[13] {
+		return fmt.Errorf("CNPJ %w", ErrInvalidCheckDigits)
+	}
+	return nil
+}
+
+// checkDigit computes a CPF check digit with weights starting at weight and
+// decreasing down to 2.
+func checkDigit(digits string, weight int) byte {
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		sum += int(digits[i]-'0') * (weight - i)
+	}
+	return mod11(sum)
+}
+
+// cnpjCheckDigit computes a CNPJ check digit. Weights cycle from 9 down to 2
+// starting from the rightmost character, and every character is worth its
+// ASCII code minus 48, so letters are accepted as in the alphanumeric CNPJ.
+func cnpjCheckDigit(chars string) byte {
+	sum := 0
+	weight := 2
+	for i := len(chars) - 1; i >= 0; i-- {
+		sum += int(chars[i]-'0') * weight
+		weight++
+		if weight > 9 {
+			weight = 2
+		}
+	}
+	return mod11(sum)
+}
+
+func mod11(sum int) byte {
+	rest := sum % 11
+	if rest < 2 {
+		return '0'
+	}
+	return byte('0' + 11 - rest)
+}
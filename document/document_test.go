@@ -0,0 +1,70 @@
+package document
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	valid := map[string]Type{
+		"529.982.247-25":     CPF,
+		"52998224725":        CPF,
+		"11.222.333/0001-81": CNPJ,
+		"11222333000181":     CNPJ,
+		"12.ABC.345/01DE-35": CNPJ,
+		"12abc34501de35":     CNPJ,
+	}
+	for doc, expected := range valid {
+		typ, err := Validate(doc)
+		assert.NoError(t, err, doc)
+		assert.Equal(t, expected, typ, doc)
+	}
+
+	invalid := map[string]error{
+		"":                   ErrEmpty,
+		"1234":               ErrInvalidLength,
+		"529.982.247-26":     ErrInvalidCheckDigits,
+		"111.111.111-11":     ErrRepeatedDigits,
+		"5299822472A":        ErrInvalidCharacter,
+		"11.222.333/0001-82": ErrInvalidCheckDigits,
+		"00000000000000":     ErrRepeatedDigits,
+		"12.ABC.345/01DE-3A": ErrInvalidCharacter,
+		"12.ABC.345/01D*-35": ErrInvalidCharacter,
+	}
+	for doc, expected := range invalid {
+		_, err := Validate(doc)
+		assert.ErrorIs(t, err, expected, doc)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "52998224725", Normalize("529.982.247-25"))
+	assert.Equal(t, "12ABC34501DE35", Normalize("12.abc.345/01de-35"))
+}
+
+func TestFormat(t *testing.T) {
+	formatted, err := Format("52998224725")
+	assert.NoError(t, err)
+	assert.Equal(t, "529.982.247-25", formatted)
+
+	formatted, err = Format("12abc34501de35")
+	assert.NoError(t, err)
+	assert.Equal(t, "12.ABC.345/01DE-35", formatted)
+
+	_, err = Format("52998224726")
+	assert.ErrorIs(t, err, ErrInvalidCheckDigits)
+}
+
+func TestDetect(t *testing.T) {
+	typ, err := Detect("123.456.789-09")
+	assert.NoError(t, err)
+	assert.Equal(t, CPF, typ)
+
+	typ, err = Detect("11.222.333/0001-81")
+	assert.NoError(t, err)
+	assert.Equal(t, CNPJ, typ)
+
+	_, err = Detect("123")
+	assert.EqualError(t, err, "invalid document length: expected 11 characters for CPF or 14 for CNPJ, got 3")
+}
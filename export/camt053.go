@@ -0,0 +1,164 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
+)
+
+const camt053Namespace = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.08"
+
+type camtDocument struct {
+	XMLName   xml.Name      `xml:"Document"`
+	Namespace string        `xml:"xmlns,attr"`
+	Statement camtBkToCstmr `xml:"BkToCstmrStmt"`
+}
+
+type camtBkToCstmr struct {
+	GroupHeader struct {
+		MessageID string `xml:"MsgId"`
+		CreatedAt string `xml:"CreDtTm"`
+	} `xml:"GrpHdr"`
+	Statement camtStatement `xml:"Stmt"`
+}
+
+type camtStatement struct {
+	ID        string `xml:"Id"`
+	CreatedAt string `xml:"CreDtTm"`
+	Period    struct {
+		From string `xml:"FrDtTm"`
+		To   string `xml:"ToDtTm"`
+	} `xml:"FrToDt"`
+	Account struct {
+		ID struct {
+			Other struct {
+				ID string `xml:"Id"`
+			} `xml:"Othr"`
+		} `xml:"Id"`
+		Currency string `xml:"Ccy"`
+	} `xml:"Acct"`
+	Balances []camtBalance `xml:"Bal"`
+	Entries  []camtEntry   `xml:"Ntry"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtDateTime struct {
+	DateTime string `xml:"DtTm"`
+}
+
+type camtBalance struct {
+	Type struct {
+		CodeOrProprietary struct {
+			Code string `xml:"Cd"`
+		} `xml:"CdOrPrtry"`
+	} `xml:"Tp"`
+	Amount               camtAmount   `xml:"Amt"`
+	CreditDebitIndicator string       `xml:"CdtDbtInd"`
+	Date                 camtDateTime `xml:"Dt"`
+}
+
+type camtEntry struct {
+	Reference            string     `xml:"NtryRef"`
+	Amount               camtAmount `xml:"Amt"`
+	CreditDebitIndicator string     `xml:"CdtDbtInd"`
+	Status               struct {
+		Code string `xml:"Cd"`
+	} `xml:"Sts"`
+	BookingDate         camtDateTime `xml:"BookgDt"`
+	ValueDate           camtDateTime `xml:"ValDt"`
+	BankTransactionCode struct {
+		Proprietary struct {
+			Code string `xml:"Cd"`
+		} `xml:"Prtry"`
+	} `xml:"BkTxCd"`
+	Details struct {
+		Transaction struct {
+			References struct {
+				EndToEndID string `xml:"EndToEndId"`
+			} `xml:"Refs"`
+		} `xml:"TxDtls"`
+	} `xml:"NtryDtls"`
+}
+
+func camtTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// camtIndicator splits a signed amount into the unsigned value and credit or
+// debit indicator used throughout camt messages.
+func camtIndicator(m money.Money) (string, string) {
+	if m.IsNegative() {
+		return m.Neg().String(), "DBIT"
+	}
+	return m.String(), "CRDT"
+}
+
+func camtBalanceOf(code string, m money.Money, currency string, at time.Time) camtBalance {
+	b := camtBalance{}
+	b.Type.CodeOrProprietary.Code = code
+	value, indicator := camtIndicator(m)
+	b.Amount = camtAmount{Currency: currency, Value: value}
+	b.CreditDebitIndicator = indicator
+	b.Date.DateTime = camtTime(at)
+	return b
+}
+
+// Camt053 writes the statement as an ISO 20022 BankToCustomerStatement
+// (camt.053.001.08) with opening and closing booked balances and one booked
+// entry per line.
+func Camt053(w io.Writer, s *schemas.Statement, generatedAt time.Time) error {
+	accountID := strconv.FormatUint(uint64(s.AccountID), 10)
+	id := fmt.Sprintf("STMT-%s-%s", accountID, s.To.UTC().Format("20060102150405"))
+
+	doc := camtDocument{Namespace: camt053Namespace}
+	doc.Statement.GroupHeader.MessageID = id
+	doc.Statement.GroupHeader.CreatedAt = camtTime(generatedAt)
+
+	st := &doc.Statement.Statement
+	st.ID = id
+	st.CreatedAt = camtTime(generatedAt)
+	st.Period.From = camtTime(s.From)
+	st.Period.To = camtTime(s.To)
+	st.Account.ID.Other.ID = accountID
+	st.Account.Currency = s.Currency
+	st.Balances = []camtBalance{
+		camtBalanceOf("OPBD", s.OpeningBalance, s.Currency, s.From),
+		camtBalanceOf("CLBD", s.ClosingBalance, s.Currency, s.To),
+	}
+
+	for _, line := range s.Lines {
+		e := camtEntry{Reference: strconv.FormatUint(uint64(line.TransactionID), 10)}
+		value, indicator := camtIndicator(signedAmount(line))
+		e.Amount = camtAmount{Currency: s.Currency, Value: value}
+		e.CreditDebitIndicator = indicator
+		e.Status.Code = "BOOK"
+		e.BookingDate.DateTime = camtTime(line.CreatedAt)
+		e.ValueDate.DateTime = camtTime(line.CreatedAt)
+		e.BankTransactionCode.Proprietary.Code = line.Type
+		e.Details.Transaction.References.EndToEndID = "NOTPROVIDED"
+		if line.TransferID != "" {
+			e.Details.Transaction.References.EndToEndID = line.TransferID
+		}
+		st.Entries = append(st.Entries, e)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
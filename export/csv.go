@@ -0,0 +1,38 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jamadeu/accounts/schemas"
+)
+
+var csvHeader = []string{"date", "transaction_id", "type", "direction", "amount", "running_balance", "currency", "transfer_id"}
+
+// CSV writes one row per statement line. Amounts are signed from the
+// account holder's point of view.
+func CSV(w io.Writer, s *schemas.Statement) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, line := range s.Lines {
+		err := cw.Write([]string{
+			line.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(line.TransactionID), 10),
+			line.Type,
+			line.Direction,
+			signedAmount(line).String(),
+			line.RunningBalance.String(),
+			s.Currency,
+			line.TransferID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
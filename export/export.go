@@ -0,0 +1,47 @@
+// Package export renders account statements in formats understood by
+// accounting tools: CSV, OFX 2.2 and ISO 20022 camt.053.
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
+)
+
+// Format describes one statement export format.
+type Format struct {
+	Extension   string
+	ContentType string
+	Write       func(w io.Writer, s *schemas.Statement, generatedAt time.Time) error
+}
+
+var (
+	FormatCSV = Format{
+		Extension:   "csv",
+		ContentType: "text/csv; charset=utf-8",
+		Write: func(w io.Writer, s *schemas.Statement, _ time.Time) error {
+			return CSV(w, s)
+		},
+	}
+	FormatOFX = Format{
+		Extension:   "ofx",
+		ContentType: "application/x-ofx",
+		Write:       OFX,
+	}
+	FormatCamt053 = Format{
+		Extension:   "xml",
+		ContentType: "application/xml",
+		Write:       Camt053,
+	}
+)
+
+// signedAmount returns the line amount as seen from the account holder:
+// credits are positive and debits negative.
+func signedAmount(line schemas.StatementLine) money.Money {
+	if line.Direction == schemas.DirectionDebit {
+		return line.Amount.Neg()
+	}
+	return line.Amount
+}
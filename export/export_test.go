@@ -0,0 +1,69 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+var generatedAt = time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC)
+
+var statementTest = &schemas.Statement{
+	AccountID:      42,
+	Currency:       "BRL",
+	From:           time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	To:             time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+	OpeningBalance: money.MustParse("100.00", "BRL"),
+	ClosingBalance: money.MustParse("129.90", "BRL"),
+	Lines: []schemas.StatementLine{
+		{
+			TransactionID:  7,
+			CreatedAt:      time.Date(2024, 1, 5, 14, 0, 0, 0, time.UTC),
+			Type:           schemas.TransactionTypeDeposit,
+			Direction:      schemas.DirectionCredit,
+			Amount:         money.MustParse("50.00", "BRL"),
+			RunningBalance: money.MustParse("150.00", "BRL"),
+		},
+		{
+			TransactionID:  9,
+			CreatedAt:      time.Date(2024, 1, 20, 8, 15, 30, 0, time.UTC),
+			Type:           schemas.TransactionTypeTransfer,
+			Direction:      schemas.DirectionDebit,
+			Amount:         money.MustParse("20.10", "BRL"),
+			RunningBalance: money.MustParse("129.90", "BRL"),
+			TransferID:     "5f1e0c52-7a43-4c1e-9d0b-2f64b1a5e0aa",
+		},
+	},
+}
+
+func TestFormats(t *testing.T) {
+	for _, format := range []Format{FormatCSV, FormatOFX, FormatCamt053} {
+		t.Run(format.Extension, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := format.Write(&buf, statementTest, generatedAt); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "statement."+format.Extension)
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(expected), buf.String())
+		})
+	}
+}
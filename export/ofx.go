@@ -0,0 +1,119 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jamadeu/accounts/schemas"
+)
+
+const (
+	ofxHeader   = `<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>` + "\n"
+	ofxTime     = "20060102150405.000[0:GMT]"
+	ofxBankID   = "0001"
+	ofxLanguage = "POR"
+)
+
+type ofxDocument struct {
+	XMLName xml.Name `xml:"OFX"`
+	SignOn  struct {
+		Response struct {
+			Status   ofxStatus `xml:"STATUS"`
+			Server   string    `xml:"DTSERVER"`
+			Language string    `xml:"LANGUAGE"`
+		} `xml:"SONRS"`
+	} `xml:"SIGNONMSGSRSV1"`
+	Bank struct {
+		Transaction struct {
+			UID       string       `xml:"TRNUID"`
+			Status    ofxStatus    `xml:"STATUS"`
+			Statement ofxStatement `xml:"STMTRS"`
+		} `xml:"STMTTRNRS"`
+	} `xml:"BANKMSGSRSV1"`
+}
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxStatement struct {
+	Currency string `xml:"CURDEF"`
+	Account  struct {
+		BankID string `xml:"BANKID"`
+		ID     string `xml:"ACCTID"`
+		Type   string `xml:"ACCTTYPE"`
+	} `xml:"BANKACCTFROM"`
+	Transactions struct {
+		Start string           `xml:"DTSTART"`
+		End   string           `xml:"DTEND"`
+		Lines []ofxTransaction `xml:"STMTTRN"`
+	} `xml:"BANKTRANLIST"`
+	LedgerBalance struct {
+		Amount string `xml:"BALAMT"`
+		AsOf   string `xml:"DTASOF"`
+	} `xml:"LEDGERBAL"`
+}
+
+type ofxTransaction struct {
+	Type   string `xml:"TRNTYPE"`
+	Posted string `xml:"DTPOSTED"`
+	Amount string `xml:"TRNAMT"`
+	FITID  string `xml:"FITID"`
+	Memo   string `xml:"MEMO"`
+}
+
+func ofxTransactionType(line schemas.StatementLine) string {
+	switch {
+	case line.Type == schemas.TransactionTypeTransfer:
+		return "XFER"
+	case line.Direction == schemas.DirectionDebit:
+		return "DEBIT"
+	default:
+		return "CREDIT"
+	}
+}
+
+// OFX writes the statement as an OFX 2.2 bank statement response.
+func OFX(w io.Writer, s *schemas.Statement, generatedAt time.Time) error {
+	doc := ofxDocument{}
+	doc.SignOn.Response.Status = ofxStatus{Code: 0, Severity: "INFO"}
+	doc.SignOn.Response.Server = generatedAt.UTC().Format(ofxTime)
+	doc.SignOn.Response.Language = ofxLanguage
+
+	tr := &doc.Bank.Transaction
+	tr.UID = strconv.FormatUint(uint64(s.AccountID), 10)
+	tr.Status = ofxStatus{Code: 0, Severity: "INFO"}
+
+	st := &tr.Statement
+	st.Currency = s.Currency
+	st.Account.BankID = ofxBankID
+	st.Account.ID = strconv.FormatUint(uint64(s.AccountID), 10)
+	st.Account.Type = "CHECKING"
+	st.Transactions.Start = s.From.UTC().Format(ofxTime)
+	st.Transactions.End = s.To.UTC().Format(ofxTime)
+	for _, line := range s.Lines {
+		st.Transactions.Lines = append(st.Transactions.Lines, ofxTransaction{
+			Type:   ofxTransactionType(line),
+			Posted: line.CreatedAt.UTC().Format(ofxTime),
+			Amount: signedAmount(line).String(),
+			FITID:  strconv.FormatUint(uint64(line.TransactionID), 10),
+			Memo:   line.Type,
+		})
+	}
+	st.LedgerBalance.Amount = s.ClosingBalance.String()
+	st.LedgerBalance.AsOf = s.To.UTC().Format(ofxTime)
+
+	if _, err := io.WriteString(w, xml.Header+ofxHeader); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
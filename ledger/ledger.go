@@ -0,0 +1,280 @@
+// Package ledger implements a double-entry ledger underneath customer
+// accounts. Every movement is a journal entry with balanced debit and credit
+// postings, and Account.Balance is kept as a cached projection of the
+// postings on that account.
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
+	"gorm.io/gorm"
+)
+
+// CashAccount is the system asset account that funds deposits and receives
+// withdrawals.
+const CashAccount = "asset:cash"
+
+var (
+	ErrEmptyEntry         = errors.New("ledger: journal entry has fewer than two postings")
+	ErrUnbalanced         = errors.New("ledger: debits and credits do not balance")
+	ErrInvalidPosting     = errors.New("ledger: invalid posting")
+	ErrInvariantViolation = errors.New("ledger: invariant violated")
+)
+
+// CustomerAccount returns the ledger account code of a customer account.
+func CustomerAccount(id uint) string {
+	return fmt.Sprintf("customer:%d", id)
+}
+
+// Debit returns a posting debiting a customer account.
+func Debit(accountID uint, amount money.Money) schemas.Posting {
+	return customerPosting(accountID, schemas.DirectionDebit, amount)
+}
+
+// Credit returns a posting crediting a customer account.
+func Credit(accountID uint, amount money.Money) schemas.Posting {
+	return customerPosting(accountID, schemas.DirectionCredit, amount)
+}
+
+func customerPosting(accountID uint, direction string, amount money.Money) schemas.Posting {
+	id := accountID
+	return schemas.Posting{
+		LedgerAccount: CustomerAccount(accountID),
+		AccountID:     &id,
+		Direction:     direction,
+		Amount:        amount,
+	}
+}
+
+// System returns a posting on a system account such as CashAccount.
+func System(code, direction string, amount money.Money) schemas.Posting {
+	return schemas.Posting{
+		LedgerAccount: code,
+		Direction:     direction,
+		Amount:        amount,
+	}
+}
+
+// Deposit builds the entry that moves cash into a customer account.
+func Deposit(accountID uint, amount money.Money, description string) *schemas.JournalEntry {
+	return &schemas.JournalEntry{
+		Description: description,
+		Postings: []schemas.Posting{
+			System(CashAccount, schemas.DirectionDebit, amount),
+			Credit(accountID, amount),
+		},
+	}
+}
+
+// Withdrawal builds the entry that moves money out of a customer account.
+func Withdrawal(accountID uint, amount money.Money, description string) *schemas.JournalEntry {
+	return &schemas.JournalEntry{
+		Description: description,
+		Postings: []schemas.Posting{
+			Debit(accountID, amount),
+			System(CashAccount, schemas.DirectionCredit, amount),
+		},
+	}
+}
+
+// Transfer builds the entry that moves money between two customer accounts.
+func Transfer(fromID, toID uint, amount money.Money, reference string) *schemas.JournalEntry {
+	return &schemas.JournalEntry{
+		Description: "transfer",
+		Reference:   reference,
+		Postings: []schemas.Posting{
+			Debit(fromID, amount),
+			Credit(toID, amount),
+		},
+	}
+}
+
+type Ledger struct {
+	db *gorm.DB
+}
+
+func New(db *gorm.DB) *Ledger {
+	return &Ledger{db: db}
+}
+
+// Validate checks that an entry has at least two positive postings and that
+// its debits equal its credits in every currency.
+func Validate(entry *schemas.JournalEntry) error {
+	if len(entry.Postings) < 2 {
+		return ErrEmptyEntry
+	}
+	totals := map[string]int64{}
+	for _, p := range entry.Postings {
+		if p.LedgerAccount == "" || !p.Amount.IsPositive() {
+			return fmt.Errorf("%w: %s %s on %q", ErrInvalidPosting, p.Direction, p.Amount, p.LedgerAccount)
+		}
+		currency := p.Amount.CurrencyCode()
+		switch p.Direction {
+		case schemas.DirectionDebit:
+			totals[currency] += p.Amount.Amount
+		case schemas.DirectionCredit:
+			totals[currency] -= p.Amount.Amount
+		default:
+			return fmt.Errorf("%w: unknown direction %q", ErrInvalidPosting, p.Direction)
+		}
+	}
+	for currency, total := range totals {
+		if total != 0 {
+			return fmt.Errorf("%w: %s is off by %s", ErrUnbalanced, currency, money.New(total, currency))
+		}
+	}
+	return nil
+}
+
+// Post validates and stores a journal entry inside tx, then updates the
+// cached balance of every customer account it touches. Callers are expected
+// to hold a row lock on those accounts.
+func (l *Ledger) Post(tx *gorm.DB, entry *schemas.JournalEntry) error {
+	if err := Validate(entry); err != nil {
+		return err
+	}
+	if entry.EffectiveAt.IsZero() {
+		entry.EffectiveAt = time.Now()
+	}
+	for i := range entry.Postings {
+		entry.Postings[i].EffectiveAt = entry.EffectiveAt
+		entry.Postings[i].Currency = entry.Postings[i].Amount.CurrencyCode()
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return err
+	}
+
+	for _, p := range entry.Postings {
+		if p.AccountID == nil {
+			continue
+		}
+		delta := p.Amount
+		if p.Direction == schemas.DirectionDebit {
+			delta = delta.Neg()
+		}
+		err := tx.Model(&schemas.Account{}).
+			Where("id = ?", *p.AccountID).
+			Update("balance", gorm.Expr("balance + ?", delta)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BalanceAt computes the balance of a customer account from its postings
+// effective up to and including at.
+func (l *Ledger) BalanceAt(ctx context.Context, accountID uint, at time.Time) (money.Money, error) {
+	return l.balance(ctx, accountID, "effective_at <= ?", at)
+}
+
+// BalanceBefore computes the balance of a customer account from its
+// postings effective strictly before at, which is the opening balance of a
+// period starting at at.
+func (l *Ledger) BalanceBefore(ctx context.Context, accountID uint, at time.Time) (money.Money, error) {
+	return l.balance(ctx, accountID, "effective_at < ?", at)
+}
+
+func (l *Ledger) balance(ctx context.Context, accountID uint, until string, at time.Time) (money.Money, error) {
+	db := l.db.WithContext(ctx)
+	account := schemas.Account{}
+	if err := db.Select("id", "currency").First(&account, accountID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return money.Money{}, schemas.ErrAccountNotFound
+		}
+		return money.Money{}, err
+	}
+
+	balance := money.Zero(account.Currency)
+	err := db.Model(&schemas.Posting{}).
+		Select(signedSum("credit")).
+		Where("account_id = ?", accountID).
+		Where(until, at).
+		Row().Scan(&balance)
+	if err != nil {
+		return money.Money{}, err
+	}
+	balance.Currency = account.Currency
+	return balance, nil
+}
+
+// signedSum returns a SUM expression over postings that counts the given
+// direction as positive and the opposite one as negative.
+func signedSum(positive string) string {
+	return fmt.Sprintf("COALESCE(SUM(CASE WHEN direction = '%s' THEN amount ELSE -amount END), 0)", positive)
+}
+
+// Report lists every invariant violation found by Check.
+type Report struct {
+	// UnbalancedCurrencies maps currencies whose postings do not sum to zero
+	// to the net debit amount.
+	UnbalancedCurrencies map[string]money.Money `json:"unbalancedCurrencies"`
+	// UnbalancedEntries lists journal entries whose own postings do not
+	// balance.
+	UnbalancedEntries []uint `json:"unbalancedEntries"`
+	// StaleBalances lists accounts whose cached balance differs from the
+	// balance derived from their postings.
+	StaleBalances []uint `json:"staleBalances"`
+}
+
+func (r *Report) OK() bool {
+	return len(r.UnbalancedCurrencies) == 0 && len(r.UnbalancedEntries) == 0 && len(r.StaleBalances) == 0
+}
+
+// Check verifies that the ledger sums to zero, that every journal entry is
+// balanced and that cached account balances match their postings. It
+// returns ErrInvariantViolation together with the report when any check
+// fails.
+func (l *Ledger) Check(ctx context.Context) (*Report, error) {
+	db := l.db.WithContext(ctx)
+	report := &Report{UnbalancedCurrencies: map[string]money.Money{}}
+
+	totals := []struct {
+		Currency string
+		Total    money.Money
+	}{}
+	err := db.Model(&schemas.Posting{}).
+		Select("currency, " + signedSum("debit") + " AS total").
+		Group("currency").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range totals {
+		if !t.Total.IsZero() {
+			report.UnbalancedCurrencies[t.Currency] = money.New(t.Total.Amount, t.Currency)
+		}
+	}
+
+	err = db.Model(&schemas.Posting{}).
+		Select("journal_entry_id").
+		Group("journal_entry_id").
+		Having(signedSum("debit")+" <> 0").
+		Pluck("journal_entry_id", &report.UnbalancedEntries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Raw(`SELECT a.id FROM accounts a
+		LEFT JOIN (
+			SELECT account_id, ` + signedSum("credit") + ` AS balance
+			FROM postings
+			WHERE account_id IS NOT NULL AND deleted_at IS NULL
+			GROUP BY account_id
+		) p ON p.account_id = a.id
+		WHERE a.deleted_at IS NULL AND a.balance <> COALESCE(p.balance, 0)
+		ORDER BY a.id`).Scan(&report.StaleBalances).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if !report.OK() {
+		return report, ErrInvariantViolation
+	}
+	return report, nil
+}
@@ -0,0 +1,301 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestEntriesAreBalanced(t *testing.T) {
+	amount := money.MustParse("25.00", "BRL")
+	for _, entry := range []*schemas.JournalEntry{
+		Deposit(1, amount, "deposit"),
+		Withdrawal(1, amount, "withdrawal"),
+		Transfer(1, 2, amount, "ref"),
+	} {
+		assert.NoError(t, Validate(entry), entry.Description)
+	}
+}
+
+func TestTransferPostsOnBothCustomerAccounts(t *testing.T) {
+	entry := Transfer(1, 2, money.MustParse("10", "BRL"), "ref")
+
+	assert.Equal(t, "ref", entry.Reference)
+	assert.Equal(t, CustomerAccount(1), entry.Postings[0].LedgerAccount)
+	assert.Equal(t, schemas.DirectionDebit, entry.Postings[0].Direction)
+	assert.Equal(t, uint(1), *entry.Postings[0].AccountID)
+	assert.Equal(t, CustomerAccount(2), entry.Postings[1].LedgerAccount)
+	assert.Equal(t, schemas.DirectionCredit, entry.Postings[1].Direction)
+	assert.Equal(t, uint(2), *entry.Postings[1].AccountID)
+}
+
+func TestValidateRejectsInvalidEntries(t *testing.T) {
+	ten := money.MustParse("10", "BRL")
+
+	err := Validate(&schemas.JournalEntry{Postings: []schemas.Posting{Debit(1, ten)}})
+	assert.ErrorIs(t, err, ErrEmptyEntry)
+
+	err = Validate(&schemas.JournalEntry{Postings: []schemas.Posting{
+		Debit(1, ten),
+		Credit(2, money.MustParse("9.99", "BRL")),
+	}})
+	assert.ErrorIs(t, err, ErrUnbalanced)
+
+	err = Validate(&schemas.JournalEntry{Postings: []schemas.Posting{
+		Debit(1, ten),
+		Credit(2, money.MustParse("10", "USD")),
+	}})
+	assert.ErrorIs(t, err, ErrUnbalanced)
+
+	err = Validate(&schemas.JournalEntry{Postings: []schemas.Posting{
+		Debit(1, ten.Neg()),
+		Credit(2, ten.Neg()),
+	}})
+	assert.ErrorIs(t, err, ErrInvalidPosting)
+
+	err = Validate(&schemas.JournalEntry{Postings: []schemas.Posting{
+		System(CashAccount, "sideways", ten),
+		Credit(2, ten),
+	}})
+	assert.ErrorIs(t, err, ErrInvalidPosting)
+}
+
+func TestReportOK(t *testing.T) {
+	assert.True(t, (&Report{}).OK())
+	assert.False(t, (&Report{StaleBalances: []uint{3}}).OK())
+}
+
+func TestPost(t *testing.T) {
+	t.Run("should store the entry and move the cached balance of customer accounts", func(t *testing.T) {
+		db, conn := newScriptedDB(t,
+			scriptedResult{match: `INSERT INTO "journal_entries"`, columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}}},
+			scriptedResult{match: `INSERT INTO "postings"`, columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}}},
+		)
+		entry := Transfer(1, 2, money.MustParse("10", "BRL"), "ref")
+
+		err := New(db).Post(db, entry)
+
+		assert.NoError(t, err)
+		assert.False(t, entry.EffectiveAt.IsZero())
+		for _, p := range entry.Postings {
+			assert.Equal(t, entry.EffectiveAt, p.EffectiveAt)
+			assert.Equal(t, "BRL", p.Currency)
+		}
+		assert.Equal(t, []balanceUpdate{{"-10.00", 1}, {"10.00", 2}}, conn.balanceUpdates())
+	})
+
+	t.Run("should leave system accounts out of cached balances", func(t *testing.T) {
+		db, conn := newScriptedDB(t)
+
+		err := New(db).Post(db, Deposit(1, money.MustParse("25.50", "BRL"), "deposit"))
+
+		assert.NoError(t, err)
+		assert.Equal(t, []balanceUpdate{{"25.50", 1}}, conn.balanceUpdates())
+	})
+
+	t.Run("should reject unbalanced entries without writing anything", func(t *testing.T) {
+		db, conn := newScriptedDB(t)
+
+		err := New(db).Post(db, &schemas.JournalEntry{Postings: []schemas.Posting{
+			Debit(1, money.MustParse("10", "BRL")),
+			Credit(2, money.MustParse("9.99", "BRL")),
+		}})
+
+		assert.ErrorIs(t, err, ErrUnbalanced)
+		assert.Empty(t, conn.statements())
+	})
+}
+
+func TestBalanceAt(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("should sum the postings up to at in the account currency", func(t *testing.T) {
+		db, conn := newScriptedDB(t,
+			scriptedResult{match: `FROM "accounts"`, columns: []string{"id", "currency"}, rows: [][]driver.Value{{int64(1), "USD"}}},
+			scriptedResult{match: `FROM "postings"`, columns: []string{"balance"}, rows: [][]driver.Value{{"12.34"}}},
+		)
+
+		balance, err := New(db).BalanceAt(context.Background(), 1, at)
+
+		assert.NoError(t, err)
+		assert.Equal(t, money.MustParse("12.34", "USD"), balance)
+		sum := conn.statements()[1]
+		assert.Contains(t, sum.query, "effective_at <= $2")
+		assert.Equal(t, []driver.Value{int64(1), at}, sum.args)
+	})
+
+	t.Run("should leave postings effective at at out of the balance before it", func(t *testing.T) {
+		db, conn := newScriptedDB(t,
+			scriptedResult{match: `FROM "accounts"`, columns: []string{"id", "currency"}, rows: [][]driver.Value{{int64(1), "BRL"}}},
+			scriptedResult{match: `FROM "postings"`, columns: []string{"balance"}, rows: [][]driver.Value{{"100.00"}}},
+		)
+
+		balance, err := New(db).BalanceBefore(context.Background(), 1, at)
+
+		assert.NoError(t, err)
+		assert.Equal(t, money.MustParse("100", "BRL"), balance)
+		sum := conn.statements()[1]
+		assert.Contains(t, sum.query, "effective_at < $2")
+		assert.Equal(t, []driver.Value{int64(1), at}, sum.args)
+	})
+
+	t.Run("should return ErrAccountNotFound for unknown accounts", func(t *testing.T) {
+		db, _ := newScriptedDB(t)
+
+		_, err := New(db).BalanceAt(context.Background(), 1, at)
+
+		assert.ErrorIs(t, err, schemas.ErrAccountNotFound)
+	})
+}
+
+func TestCheck(t *testing.T) {
+	t.Run("should pass a consistent ledger", func(t *testing.T) {
+		db, _ := newScriptedDB(t,
+			scriptedResult{match: `GROUP BY "currency"`, columns: []string{"currency", "total"}, rows: [][]driver.Value{{"BRL", "0.00"}}},
+		)
+
+		report, err := New(db).Check(context.Background())
+
+		assert.NoError(t, err)
+		assert.True(t, report.OK())
+	})
+
+	t.Run("should report unbalanced entries and stale cached balances", func(t *testing.T) {
+		db, _ := newScriptedDB(t,
+			scriptedResult{match: `GROUP BY "currency"`, columns: []string{"currency", "total"}, rows: [][]driver.Value{{"BRL", "0.01"}, {"USD", "0.00"}}},
+			scriptedResult{match: `GROUP BY "journal_entry_id"`, columns: []string{"journal_entry_id"}, rows: [][]driver.Value{{int64(7)}}},
+			scriptedResult{match: `FROM accounts a`, columns: []string{"id"}, rows: [][]driver.Value{{int64(3)}}},
+		)
+
+		report, err := New(db).Check(context.Background())
+
+		assert.ErrorIs(t, err, ErrInvariantViolation)
+		assert.Equal(t, map[string]money.Money{"BRL": money.MustParse("0.01", "BRL")}, report.UnbalancedCurrencies)
+		assert.Equal(t, []uint{7}, report.UnbalancedEntries)
+		assert.Equal(t, []uint{3}, report.StaleBalances)
+	})
+}
+
+// newScriptedDB opens a gorm connection on a scriptedConn answering queries
+// with results.
+func newScriptedDB(t *testing.T, results ...scriptedResult) (*gorm.DB, *scriptedConn) {
+	conn := &scriptedConn{results: results}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(conn)}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	require.NoError(t, err)
+	return db, conn
+}
+
+// scriptedResult answers the queries whose SQL contains match.
+type scriptedResult struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+type statement struct {
+	query string
+	args  []driver.Value
+}
+
+type balanceUpdate struct {
+	delta     string
+	accountID int64
+}
+
+// scriptedConn is a database/sql connection, and its own connector, that
+// records every statement and answers queries from canned results. Queries
+// without a result return no rows.
+type scriptedConn struct {
+	mu      sync.Mutex
+	results []scriptedResult
+	log     []statement
+}
+
+func (c *scriptedConn) record(query string, args []driver.NamedValue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make([]driver.Value, 0, len(args))
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	c.log = append(c.log, statement{query, values})
+}
+
+func (c *scriptedConn) statements() []statement {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]statement(nil), c.log...)
+}
+
+// balanceUpdates returns the delta and account of every cached balance
+// update, in order.
+func (c *scriptedConn) balanceUpdates() []balanceUpdate {
+	updates := []balanceUpdate{}
+	for _, s := range c.statements() {
+		if strings.HasPrefix(s.query, `UPDATE "accounts" SET "balance"`) {
+			updates = append(updates, balanceUpdate{s.args[0].(string), s.args[2].(int64)})
+		}
+	}
+	return updates
+}
+
+func (c *scriptedConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *scriptedConn) Driver() driver.Driver                        { return nil }
+
+func (c *scriptedConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("scriptedConn does not prepare statements")
+}
+
+func (c *scriptedConn) Close() error              { return nil }
+func (c *scriptedConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *scriptedConn) Commit() error             { return nil }
+func (c *scriptedConn) Rollback() error           { return nil }
+
+func (c *scriptedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.record(query, args)
+	for _, r := range c.results {
+		if strings.Contains(query, r.match) {
+			return &scriptedRows{columns: r.columns, rows: r.rows}, nil
+		}
+	}
+	return &scriptedRows{columns: []string{"id"}}, nil
+}
+
+func (c *scriptedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+type scriptedRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptedRows) Columns() []string { return r.columns }
+func (r *scriptedRows) Close() error      { return nil }
+
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
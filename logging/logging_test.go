@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func decode(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	records := []map[string]any{}
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		record := map[string]any{}
+		require.NoError(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := New(buf, "json", "info")
+	require.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	logger.InfoContext(ctx, "with id")
+	logger.With("component", "test").InfoContext(ctx, "derived logger")
+	logger.Info("without id")
+	logger.DebugContext(ctx, "filtered")
+
+	records := decode(t, buf)
+	require.Len(t, records, 3)
+	assert.Equal(t, "req-1", records[0]["request_id"])
+	assert.Equal(t, "req-1", records[1]["request_id"])
+	assert.Equal(t, "test", records[1]["component"])
+	assert.NotContains(t, records[2], "request_id")
+
+	_, err = New(buf, "xml", "info")
+	assert.Error(t, err)
+	_, err = New(buf, "json", "loud")
+	assert.Error(t, err)
+}
+
+func TestGormLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := New(buf, "json", "debug")
+	require.NoError(t, err)
+	gormLogger := NewGormLogger(logger, 100*time.Millisecond)
+	ctx := WithRequestID(context.Background(), "req-2")
+	query := func() (string, int64) { return "SELECT 1", 1 }
+
+	gormLogger.Trace(ctx, time.Now(), query, errors.New("boom"))
+	gormLogger.Trace(ctx, time.Now().Add(-time.Second), query, nil)
+	gormLogger.Trace(ctx, time.Now(), query, gorm.ErrRecordNotFound)
+	gormLogger.LogMode(1).Trace(ctx, time.Now(), query, errors.New("silenced"))
+
+	records := decode(t, buf)
+	require.Len(t, records, 3)
+	assert.Equal(t, "query failed", records[0]["msg"])
+	assert.Equal(t, "ERROR", records[0]["level"])
+	assert.Equal(t, "boom", records[0]["error"])
+	assert.Equal(t, "slow query", records[1]["msg"])
+	assert.Equal(t, "query", records[2]["msg"])
+	for _, record := range records {
+		assert.Equal(t, "req-2", record["request_id"])
+		assert.Equal(t, "SELECT 1", record["sql"])
+	}
+}
+
+func TestGormLoggerOmitsBoundValues(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := New(buf, "json", "debug")
+	require.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		Logger:               NewGormLogger(logger, 0),
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	require.NoError(t, err)
+
+	var rows []struct{ Email string }
+	db.Table("users").Where("email = ? AND token = ?", "someone@test.com", "s3cret").Find(&rows)
+
+	records := decode(t, buf)
+	require.Len(t, records, 1)
+	assert.Contains(t, records[0]["sql"], "WHERE email = $1")
+	assert.NotContains(t, records[0]["sql"], "someone@test.com")
+	assert.NotContains(t, records[0]["sql"], "s3cret")
+}
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unmatchedRoute labels requests that matched no route, so arbitrary paths
+// cannot blow up the label cardinality.
+const unmatchedRoute = "unmatched"
+
+// Middleware records the count and latency of every request, labelled by the
+// route template (e.g. /api/v1/account/:id/deposit) rather than the raw path.
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := ctx.Request.Method
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(ctx.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,88 @@
+// Package metrics defines the Prometheus collectors of the service: HTTP
+// traffic, database queries and pool usage, and business events.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "accounts"
+
+// Registry holds every collector of the service. A dedicated registry keeps
+// the exposition free of collectors registered by dependencies.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of GORM operations by operation, table and outcome.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table", "status"})
+
+	accountsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accounts_created_total",
+		Help:      "Accounts opened by account type.",
+	}, []string{"type"})
+
+	transactionsPosted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_posted_total",
+		Help:      "Committed deposits, withdrawals and transfers by type.",
+	}, []string{"type"})
+
+	transactionVolume = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transaction_volume_total",
+		Help:      "Sum of committed transaction amounts in major units, by type and currency.",
+	}, []string{"type", "currency"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		dbQueryDuration,
+		accountsCreated,
+		transactionsPosted,
+		transactionVolume,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// AccountCreated counts an account opened with the given type.
+func AccountCreated(accountType string) {
+	accountsCreated.WithLabelValues(accountType).Inc()
+}
+
+// TransactionPosted counts a committed transaction of the given type and
+// adds its amount to the volume. A transfer counts once, not once per leg.
+func TransactionPosted(typ string, amount money.Money) {
+	transactionsPosted.WithLabelValues(typ).Inc()
+	transactionVolume.WithLabelValues(typ, amount.CurrencyCode()).Add(amount.Float64())
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/money"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/api/v1/account/:id/balance", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	router.GET("/metrics", gin.WrapH(Handler()))
+
+	for _, path := range []string{"/api/v1/account/1/balance", "/api/v1/account/2/balance", "/nowhere"} {
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/api/v1/account/:id/balance", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", unmatchedRoute, "404")))
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `accounts_http_request_duration_seconds_count{method="GET",route="/api/v1/account/:id/balance"} 2`)
+}
+
+func TestBusinessCounters(t *testing.T) {
+	AccountCreated("savings")
+	TransactionPosted("deposit", money.MustParse("10.50", "BRL"))
+	TransactionPosted("deposit", money.MustParse("0.25", "BRL"))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(accountsCreated.WithLabelValues("savings")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(transactionsPosted.WithLabelValues("deposit")))
+	assert.Equal(t, 10.75, testutil.ToFloat64(transactionVolume.WithLabelValues("deposit", "BRL")))
+}
+
+func TestGormPlugin(t *testing.T) {
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(GormPlugin{}))
+
+	type widget struct {
+		ID   uint
+		Name string
+	}
+	db.Find(&[]widget{})
+	db.Create(&widget{Name: "a"})
+
+	assert.Equal(t, 2, testutil.CollectAndCount(dbQueryDuration))
+
+	families, err := Registry.Gather()
+	require.NoError(t, err)
+	names := []string{}
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	assert.Contains(t, names, "go_sql_max_open_connections")
+}
@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("orders migrations by version", func(t *testing.T) {
+		migrations, err := Load(fstest.MapFS{
+			"0002_second.up.sql":   {Data: []byte("SELECT 2;")},
+			"0002_second.down.sql": {Data: []byte("SELECT -2;")},
+			"0001_first.up.sql":    {Data: []byte("SELECT 1;")},
+			"0001_first.down.sql":  {Data: []byte("SELECT -1;")},
+		})
+		require.NoError(t, err)
+		require.Len(t, migrations, 2)
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "first", migrations[0].Name)
+		assert.Equal(t, "SELECT 1;", migrations[0].Up)
+		assert.Equal(t, "SELECT -1;", migrations[0].Down)
+		assert.Equal(t, int64(2), migrations[1].Version)
+		assert.NotEqual(t, migrations[0].Checksum, migrations[1].Checksum)
+	})
+
+	t.Run("rejects a missing down script", func(t *testing.T) {
+		_, err := Load(fstest.MapFS{"0001_first.up.sql": {Data: []byte("SELECT 1;")}})
+		assert.ErrorContains(t, err, "both up and down scripts are required")
+	})
+
+	t.Run("rejects badly named files", func(t *testing.T) {
+		_, err := Load(fstest.MapFS{"first.sql": {Data: []byte("SELECT 1;")}})
+		assert.ErrorContains(t, err, "name must look like")
+	})
+
+	t.Run("rejects conflicting names for a version", func(t *testing.T) {
+		_, err := Load(fstest.MapFS{
+			"0001_first.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_other.down.sql": {Data: []byte("SELECT -1;")},
+		})
+		assert.ErrorContains(t, err, "conflicting names")
+	})
+}
+
+func TestEmbedded(t *testing.T) {
+	migrations, err := Embedded()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.Version, "versions must be contiguous")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "first", Checksum: "a"},
+		{Version: 2, Name: "second", Checksum: "b"},
+	}
+
+	pending, err := diff(migrations, map[int64]appliedRecord{1: {checksum: "a"}})
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, int64(2), pending[0].Version)
+
+	_, err = diff(migrations, map[int64]appliedRecord{1: {checksum: "changed"}})
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	_, err = diff(migrations, map[int64]appliedRecord{3: {checksum: "c"}})
+	assert.True(t, errors.Is(err, ErrUnknownVersion))
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0001_first.up.sql"), []byte("SELECT 1;"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0001_first.down.sql"), []byte("SELECT -1;"), 0o644))
+
+	paths, err := Create(dir, "Add Cards")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "0002_add_cards.up.sql"),
+		filepath.Join(dir, "0002_add_cards.down.sql"),
+	}, paths)
+
+	migrations, err := Load(os.DirFS(dir))
+	require.NoError(t, err)
+	assert.Len(t, migrations, 2)
+
+	_, err = Create(dir, "drop;table")
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+-- Tables as created by the first release. IF NOT EXISTS lets databases
+-- previously managed by AutoMigrate adopt the versioned history.
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    name text NOT NULL,
+    document text NOT NULL,
+    email text,
+    account_id bigint
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    balance double precision NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_accounts_deleted_at ON accounts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    type text NOT NULL,
+    account_id bigint NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_transactions_deleted_at ON transactions (deleted_at);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_accounts_transactions') THEN
+        ALTER TABLE transactions ADD CONSTRAINT fk_accounts_transactions
+            FOREIGN KEY (account_id) REFERENCES accounts (id);
+    END IF;
+END $$;
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_transactions_statement;
+DROP INDEX IF EXISTS idx_transactions_transfer_id;
+DROP INDEX IF EXISTS idx_transactions_account_id;
+
+ALTER TABLE accounts ALTER COLUMN balance TYPE double precision;
+ALTER TABLE accounts DROP COLUMN IF EXISTS currency;
+ALTER TABLE transactions DROP COLUMN IF EXISTS currency;
+ALTER TABLE transactions DROP COLUMN IF EXISTS transfer_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS balance_after;
+ALTER TABLE transactions DROP COLUMN IF EXISTS amount;
+ALTER TABLE transactions DROP COLUMN IF EXISTS direction;
@@ -0,0 +1,34 @@
+-- Deposits, withdrawals and transfers record direction, amount and the
+-- resulting balance of every transaction.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS direction text NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS amount numeric(20,2) NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS balance_after numeric(20,2) NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS transfer_id text;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT 'BRL';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT 'BRL';
+
+-- Amounts are exact numeric values. Float columns are rounded to cents half
+-- to even, matching money.Parse.
+DO $$
+DECLARE
+    c record;
+BEGIN
+    FOR c IN
+        SELECT table_name, column_name
+        FROM information_schema.columns
+        WHERE table_schema = current_schema()
+          AND data_type = 'double precision'
+          AND (table_name, column_name) IN (('accounts', 'balance'), ('transactions', 'amount'), ('transactions', 'balance_after'))
+    LOOP
+        EXECUTE format(
+            'ALTER TABLE %1$I ALTER COLUMN %2$I TYPE numeric(20,2) USING '
+            'CASE WHEN abs(%2$I::numeric * 100 - trunc(%2$I::numeric * 100)) = 0.5 '
+            'AND mod(trunc(%2$I::numeric * 100), 2) = 0 '
+            'THEN trunc(%2$I::numeric * 100) / 100 ELSE round(%2$I::numeric, 2) END',
+            c.table_name, c.column_name);
+    END LOOP;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_account_id ON transactions (account_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_transfer_id ON transactions (transfer_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_statement ON transactions (account_id, created_at, id);
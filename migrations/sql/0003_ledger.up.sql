@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    description text NOT NULL,
+    reference text,
+    effective_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_deleted_at ON journal_entries (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_reference ON journal_entries (reference);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_effective_at ON journal_entries (effective_at);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    journal_entry_id bigint NOT NULL REFERENCES journal_entries (id),
+    ledger_account text NOT NULL,
+    account_id bigint,
+    direction text NOT NULL,
+    amount numeric(20,2) NOT NULL,
+    currency text NOT NULL DEFAULT 'BRL',
+    effective_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_postings_deleted_at ON postings (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_postings_journal_entry_id ON postings (journal_entry_id);
+CREATE INDEX IF NOT EXISTS idx_postings_ledger_account ON postings (ledger_account);
+CREATE INDEX IF NOT EXISTS idx_postings_account_id ON postings (account_id);
+CREATE INDEX IF NOT EXISTS idx_postings_effective_at ON postings (effective_at);
+
+-- Back balances of accounts created before the ledger existed with an
+-- opening entry against cash. Cached balances are left untouched.
+WITH legacy AS (
+    SELECT a.id, a.balance, a.currency, coalesce(a.created_at, now()) AS created_at
+    FROM accounts a
+    WHERE a.deleted_at IS NULL
+      AND a.balance <> 0
+      AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.account_id = a.id)
+), entries AS (
+    INSERT INTO journal_entries (created_at, updated_at, description, reference, effective_at)
+    SELECT now(), now(), 'opening balance', 'account:' || id, created_at
+    FROM legacy
+    RETURNING id, reference
+)
+INSERT INTO postings (created_at, updated_at, journal_entry_id, ledger_account, account_id, direction, amount, currency, effective_at)
+SELECT now(), now(), e.id, p.ledger_account, p.account_id, p.direction, abs(l.balance), l.currency, l.created_at
+FROM entries e
+JOIN legacy l ON e.reference = 'account:' || l.id
+CROSS JOIN LATERAL (VALUES
+    ('asset:cash', NULL::bigint, CASE WHEN l.balance > 0 THEN 'debit' ELSE 'credit' END),
+    ('customer:' || l.id, l.id, CASE WHEN l.balance > 0 THEN 'credit' ELSE 'debit' END)
+) AS p (ledger_account, account_id, direction);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key text PRIMARY KEY,
+    fingerprint text NOT NULL,
+    completed boolean NOT NULL DEFAULT false,
+    status_code bigint,
+    body bytea,
+    created_at timestamptz,
+    expires_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,8 @@
+ALTER TABLE accounts DROP COLUMN IF EXISTS withdrawal_limit;
+ALTER TABLE accounts DROP COLUMN IF EXISTS type;
+
+ALTER TABLE users DROP COLUMN IF EXISTS trade_name;
+ALTER TABLE users DROP COLUMN IF EXISTS legal_name;
+ALTER TABLE users DROP COLUMN IF EXISTS birth_date;
+ALTER TABLE users DROP COLUMN IF EXISTS kind;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS uni_users_document;
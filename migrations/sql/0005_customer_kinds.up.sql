@@ -0,0 +1,19 @@
+-- Documents are stored normalized to digits and upper-case letters, and are
+-- unique.
+UPDATE users SET document = upper(regexp_replace(document, '[.\-/ ]', '', 'g'))
+WHERE document ~ '[.\-/ a-z]';
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'uni_users_document') THEN
+        ALTER TABLE users ADD CONSTRAINT uni_users_document UNIQUE (document);
+    END IF;
+END $$;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS kind text NOT NULL DEFAULT 'individual';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS birth_date timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS legal_name text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS trade_name text;
+UPDATE users SET kind = 'company' WHERE length(document) = 14;
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS type text NOT NULL DEFAULT 'checking';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS withdrawal_limit numeric(20,2) NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+-- Each user points back at the oldest account they hold.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS account_id bigint;
+UPDATE users u SET account_id = a.account_id
+FROM (
+    SELECT holder_id, min(id) AS account_id
+    FROM accounts
+    GROUP BY holder_id
+) a
+WHERE a.holder_id = u.id;
+
+ALTER TABLE accounts DROP CONSTRAINT IF EXISTS fk_users_accounts;
+DROP INDEX IF EXISTS idx_accounts_holder_id;
+ALTER TABLE accounts DROP COLUMN IF EXISTS holder_id;
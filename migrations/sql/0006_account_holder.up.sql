@@ -0,0 +1,34 @@
+-- Accounts reference their primary holder instead of users pointing at a
+-- single account, so a user can hold several accounts. When several users
+-- point at the same account the oldest becomes its holder.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS holder_id bigint;
+UPDATE accounts a SET holder_id = h.user_id
+FROM (
+    SELECT account_id, min(id) AS user_id
+    FROM users
+    WHERE account_id IS NOT NULL AND account_id <> 0
+    GROUP BY account_id
+) h
+WHERE h.account_id = a.id AND a.holder_id IS NULL;
+
+DO $$
+DECLARE
+    orphans bigint;
+BEGIN
+    SELECT count(*) INTO orphans FROM accounts WHERE holder_id IS NULL;
+    IF orphans > 0 THEN
+        RAISE EXCEPTION '% accounts have no holder; set accounts.holder_id before migrating', orphans;
+    END IF;
+END $$;
+
+ALTER TABLE accounts ALTER COLUMN holder_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_accounts_holder_id ON accounts (holder_id);
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_users_accounts') THEN
+        ALTER TABLE accounts ADD CONSTRAINT fk_users_accounts
+            FOREIGN KEY (holder_id) REFERENCES users (id) ON DELETE RESTRICT;
+    END IF;
+END $$;
+
+ALTER TABLE users DROP COLUMN IF EXISTS account_id;
@@ -0,0 +1,20 @@
+-- Accounts may be shared by several users, each with a role. The current
+-- holder of every account becomes its primary holder.
+CREATE TABLE IF NOT EXISTS account_holders (
+    account_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    role text NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (account_id, user_id),
+    CONSTRAINT chk_account_holders_role
+        CHECK (role IN ('primary', 'joint', 'authorized_signer', 'viewer')),
+    CONSTRAINT fk_account_holders_account
+        FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE,
+    CONSTRAINT fk_account_holders_user
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT
+);
+CREATE INDEX IF NOT EXISTS idx_account_holders_user_id ON account_holders (user_id);
+
+INSERT INTO account_holders (account_id, user_id, role, created_at)
+SELECT id, holder_id, 'primary', created_at FROM accounts
+ON CONFLICT DO NOTHING;
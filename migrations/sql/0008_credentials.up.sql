@@ -0,0 +1,28 @@
+-- Passwords and reset tokens. Logins are by email, which must therefore
+-- identify a single live user; the index creation fails on duplicates,
+-- which must be merged by hand first.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (lower(email)) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS credentials (
+    user_id bigint PRIMARY KEY,
+    password_hash text NOT NULL,
+    failed_logins bigint NOT NULL DEFAULT 0,
+    locked_until timestamptz,
+    password_changed_at timestamptz NOT NULL,
+    last_login_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz,
+    CONSTRAINT fk_credentials_user
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash text PRIMARY KEY,
+    user_id bigint NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz,
+    CONSTRAINT fk_password_resets_user
+        FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets (user_id);
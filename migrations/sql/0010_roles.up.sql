@@ -0,0 +1,11 @@
+-- Back-office roles. Everyone signing up is a customer and staff are
+-- promoted by an admin, so the first admin has to be promoted here:
+--   UPDATE users SET role = 'admin' WHERE id = ...;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'customer';
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'chk_users_role') THEN
+        ALTER TABLE users ADD CONSTRAINT chk_users_role
+            CHECK (role IN ('customer', 'support_agent', 'compliance_officer', 'admin'));
+    END IF;
+END $$;
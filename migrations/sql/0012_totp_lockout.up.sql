@@ -0,0 +1,4 @@
+-- Wrong second factor codes lock verification until totp_locked_until,
+-- whether or not the user has a password to lock out.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_failed_attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_locked_until timestamptz;
@@ -0,0 +1,279 @@
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultCurrency is assumed for amounts that do not carry a currency code.
+	DefaultCurrency = "BRL"
+
+	// minorUnits is the number of minor units in one major unit. Every
+	// supported currency uses two decimal places.
+	minorUnits = 100
+)
+
+// Currencies are the ISO 4217 codes Money supports. All of them use two
+// decimal places, as minorUnits assumes, so currencies with another exponent
+// such as JPY or KWD cannot be added to the list as is.
+var Currencies = []string{"BRL", "EUR", "GBP", "USD"}
+
+// decimalPattern is the only form of amount Parse accepts. big.Rat would
+// also take exponents such as "1e9999999", which are slow to expand.
+var decimalPattern = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+var (
+	ErrCurrencyMismatch = errors.New("money: currency mismatch")
+	ErrInvalidAmount    = errors.New("money: invalid amount")
+	ErrOverflow         = errors.New("money: amount out of range")
+)
+
+// Money is an exact monetary amount stored as an integer number of minor
+// units (cents) plus an ISO 4217 currency code.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns an amount of minor units in the given currency.
+func New(minor int64, currency string) Money {
+	return Money{Amount: minor, Currency: normalizeCurrency(currency)}
+}
+
+// Zero returns a zero amount in the given currency.
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// Parse reads a plain decimal string such as "1234.56" or "-0.5". Amounts
+// with more than two decimal places are rounded half to even. Exponents,
+// fractions and explicit plus signs are rejected.
+func Parse(s, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if !decimalPattern.MatchString(s) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	minor, err := roundHalfEven(r.Mul(r, big.NewRat(minorUnits, 1)))
+	if err != nil {
+		return Money{}, err
+	}
+	return New(minor, currency), nil
+}
+
+// MustParse is like Parse but panics on error. It is intended for constants
+// and tests.
+func MustParse(s, currency string) Money {
+	m, err := Parse(s, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// FromFloat converts a legacy floating point amount using the shortest
+// decimal representation of f, rounded half to even.
+func FromFloat(f float64, currency string) (Money, error) {
+	return Parse(strconv.FormatFloat(f, 'f', -1, 64), currency)
+}
+
+// Supported reports whether currency is one of Currencies.
+func Supported(currency string) bool {
+	return slices.Contains(Currencies, currency)
+}
+
+func normalizeCurrency(currency string) string {
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
+// CurrencyCode returns the currency of m, or DefaultCurrency when unset.
+func (m Money) CurrencyCode() string {
+	return normalizeCurrency(m.Currency)
+}
+
+// String formats the amount as a plain decimal with two places, without the
+// currency code.
+func (m Money) String() string {
+	sign := ""
+	amount := new(big.Int).SetInt64(m.Amount)
+	if amount.Sign() < 0 {
+		sign = "-"
+		amount.Neg(amount)
+	}
+	major, minor := new(big.Int).QuoRem(amount, big.NewInt(minorUnits), new(big.Int))
+	return fmt.Sprintf("%s%s.%02d", sign, major.String(), minor.Int64())
+}
+
+// Float64 returns the amount in major units. It is inexact and meant for
+// reporting, such as metrics, never for arithmetic.
+func (m Money) Float64() float64 {
+	return float64(m.Amount) / minorUnits
+}
+
+func (m Money) sameCurrency(o Money) error {
+	if m.CurrencyCode() != o.CurrencyCode() {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.CurrencyCode(), o.CurrencyCode())
+	}
+	return nil
+}
+
+// Add returns m + o. Both amounts must share the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrOverflow
+	}
+	return New(sum, m.Currency), nil
+}
+
+// Sub returns m - o. Both amounts must share the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Neg returns -m.
+func (m Money) Neg() Money {
+	return New(-m.Amount, m.Currency)
+}
+
+// Mul multiplies m by an arbitrary rational factor, rounding the result half
+// to even. It is used for rates and percentages.
+func (m Money) Mul(factor *big.Rat) (Money, error) {
+	r := new(big.Rat).SetInt64(m.Amount)
+	minor, err := roundHalfEven(r.Mul(r, factor))
+	if err != nil {
+		return Money{}, err
+	}
+	return New(minor, m.Currency), nil
+}
+
+// Cmp compares m and o and returns -1, 0 or +1. Both amounts must share the
+// same currency; Cmp panics with ErrCurrencyMismatch otherwise, since there
+// is no answer to return.
+func (m Money) Cmp(o Money) int {
+	if err := m.sameCurrency(o); err != nil {
+		panic(err)
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// roundHalfEven rounds r to the nearest integer, resolving ties towards the
+// even neighbour (banker's rounding).
+func roundHalfEven(r *big.Rat) (int64, error) {
+	q, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	twiceRem := new(big.Int).Abs(rem)
+	twiceRem.Lsh(twiceRem, 1)
+	switch twiceRem.Cmp(r.Denom()) {
+	case 1:
+		q.Add(q, big.NewInt(int64(r.Sign())))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(int64(r.Sign())))
+		}
+	}
+	if !q.IsInt64() {
+		return 0, ErrOverflow
+	}
+	return q.Int64(), nil
+}
+
+// MarshalJSON encodes the amount as a decimal string, e.g. "10.50", so that
+// clients never see a binary floating point value.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts either a decimal string or a JSON number, in the
+// plain form Parse takes. The currency is left untouched, or set to
+// DefaultCurrency when empty.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	parsed, err := Parse(s, m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// GormDataType stores amounts as exact numeric values.
+func (Money) GormDataType() string {
+	return "numeric(20,2)"
+}
+
+// Value implements driver.Valuer.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan implements sql.Scanner. The currency is not stored in the column and
+// is expected to be restored by the owning model.
+func (m *Money) Scan(src interface{}) error {
+	var (
+		parsed Money
+		err    error
+	)
+	switch v := src.(type) {
+	case nil:
+		parsed = Zero(m.Currency)
+	case []byte:
+		parsed, err = Parse(string(v), m.Currency)
+	case string:
+		parsed, err = Parse(v, m.Currency)
+	case int64:
+		parsed, err = Parse(strconv.FormatInt(v, 10), m.Currency)
+	case float64:
+		parsed, err = FromFloat(v, m.Currency)
+	default:
+		return fmt.Errorf("money: cannot scan %T", src)
+	}
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
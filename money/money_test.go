@@ -0,0 +1,107 @@
+package money
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in    string
+		minor int64
+	}{
+		{"10", 1000},
+		{"10.5", 1050},
+		{"-0.01", -1},
+		{"0.125", 12},
+		{"0.135", 14},
+		{"-0.125", -12},
+		{"2.675", 268},
+		{" 7.00 ", 700},
+	}
+	for _, c := range cases {
+		m, err := Parse(c.in, "")
+		assert.NoError(t, err, c.in)
+		assert.Equal(t, c.minor, m.Amount, c.in)
+		assert.Equal(t, DefaultCurrency, m.Currency, c.in)
+	}
+
+	for _, in := range []string{"", "abc", "1/3", "1.2.3", "1e2", "1e9999999", "+5", ".5", "5.", "0x10", "1_000"} {
+		_, err := Parse(in, "")
+		assert.ErrorIs(t, err, ErrInvalidAmount, in)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for _, currency := range []string{"BRL", "USD"} {
+		assert.True(t, Supported(currency), currency)
+	}
+	for _, currency := range []string{"", "brl", "JPY", "KWD", "XYZ"} {
+		assert.False(t, Supported(currency), currency)
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	m, err := FromFloat(0.1+0.2, "BRL")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), m.Amount)
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "0.00", New(0, "").String())
+	assert.Equal(t, "12.05", New(1205, "").String())
+	assert.Equal(t, "-0.07", New(-7, "").String())
+}
+
+func TestArithmetic(t *testing.T) {
+	a := MustParse("10.10", "BRL")
+	b := MustParse("0.20", "BRL")
+
+	sum, err := a.Add(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.30", sum.String())
+
+	diff, err := b.Sub(a)
+	assert.NoError(t, err)
+	assert.True(t, diff.IsNegative())
+	assert.Equal(t, "-9.90", diff.String())
+
+	_, err = a.Add(MustParse("1", "USD"))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+
+	half, err := MustParse("0.05", "BRL").Mul(big.NewRat(1, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.02", half.String())
+
+	assert.Equal(t, 1, a.Cmp(b))
+	assert.Equal(t, 0, MustParse("10.10", "").Cmp(a), "unset currencies default to BRL")
+	assert.PanicsWithError(t, "money: currency mismatch: BRL and USD", func() { a.Cmp(MustParse("1", "USD")) })
+	assert.True(t, Zero("").IsZero())
+}
+
+func TestJSON(t *testing.T) {
+	b, err := json.Marshal(MustParse("1234.5", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, `"1234.50"`, string(b))
+
+	var fromString, fromNumber Money
+	assert.NoError(t, json.Unmarshal([]byte(`"19.99"`), &fromString))
+	assert.NoError(t, json.Unmarshal([]byte(`19.99`), &fromNumber))
+	assert.Equal(t, fromString, fromNumber)
+	assert.Equal(t, int64(1999), fromString.Amount)
+}
+
+func TestScanValue(t *testing.T) {
+	m := Money{}
+	assert.NoError(t, m.Scan([]byte("42.10")))
+	assert.Equal(t, int64(4210), m.Amount)
+
+	v, err := m.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "42.10", v)
+
+	assert.Error(t, m.Scan(true))
+}
@@ -0,0 +1,74 @@
+// Package notify delivers messages to users out of band, such as password
+// reset links.
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Templates of the messages sent by the service.
+const (
+	TemplatePasswordReset   = "password-reset"
+	TemplatePasswordChanged = "password-changed"
+)
+
+// Message is addressed to an email and rendered by the notifier from its
+// template and params.
+type Message struct {
+	To       string
+	Template string
+	Params   map[string]string
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Log writes messages to the logger instead of delivering them. Params may
+// carry secrets such as reset tokens, so they are only logged at debug
+// level; it is meant for development.
+type Log struct {
+	logger *slog.Logger
+}
+
+func NewLog(logger *slog.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+func (n *Log) Notify(ctx context.Context, msg Message) error {
+	n.logger.InfoContext(ctx, "notification", "to", msg.To, "template", msg.Template)
+	n.logger.DebugContext(ctx, "notification params", "to", msg.To, "template", msg.Template, "params", msg.Params)
+	return nil
+}
+
+// Memory keeps the messages it is given, for tests.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (n *Memory) Notify(ctx context.Context, msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// Sent returns the messages sent to the address, oldest first.
+func (n *Memory) Sent(to string) []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sent := []Message{}
+	for _, msg := range n.messages {
+		if msg.To == to {
+			sent = append(sent, msg)
+		}
+	}
+	return sent
+}
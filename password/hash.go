@@ -0,0 +1,125 @@
+// Package password hashes user passwords with argon2id and checks them
+// against the password policy.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// Params tune the cost of argon2id. Memory is in KiB.
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the OWASP recommendation for argon2id.
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (p Params) Validate() error {
+	switch {
+	case p.Memory < 8*uint32(p.Parallelism) || p.Memory == 0:
+		return fmt.Errorf("argon2 memory must be at least 8 KiB per lane")
+	case p.Iterations == 0:
+		return fmt.Errorf("argon2 iterations must be positive")
+	case p.Parallelism == 0:
+		return fmt.Errorf("argon2 parallelism must be positive")
+	case p.SaltLength < 16:
+		return fmt.Errorf("argon2 salts must be at least 16 bytes")
+	case p.KeyLength < 16:
+		return fmt.Errorf("argon2 keys must be at least 16 bytes")
+	}
+	return nil
+}
+
+// Hasher hashes new passwords with its parameters and verifies hashes made
+// with any parameters, which are read back from the encoded hash.
+type Hasher struct {
+	params Params
+}
+
+func NewHasher(params Params) (*Hasher, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return &Hasher{params: params}, nil
+}
+
+// Hash returns the password encoded in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return encode(p, salt, key), nil
+}
+
+// Verify reports whether password matches the encoded hash, and whether the
+// hash was made with other parameters and should be replaced by a new one
+// once the password is known to be right.
+func (h *Hasher) Verify(password, encoded string) (match, rehash bool, err error) {
+	p, salt, key, err := decode(encoded)
+	if err != nil {
+		return false, false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+	return true, p != h.params, nil
+}
+
+func encode(p Params, salt, key []byte) string {
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key))
+}
+
+func decode(encoded string) (Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Params{}, nil, nil, ErrMalformedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, fmt.Errorf("%w: unsupported version", ErrMalformedHash)
+	}
+	p := Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Params{}, nil, nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+	b64 := base64.RawStdEncoding
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return Params{}, nil, nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	if err := p.Validate(); err != nil {
+		return Params{}, nil, nil, fmt.Errorf("%w: %w", ErrMalformedHash, err)
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,72 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cheap keeps the tests fast; production parameters come from the config.
+var cheap = Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHasher(t *testing.T) {
+	hasher, err := NewHasher(cheap)
+	require.NoError(t, err)
+
+	encoded, err := hasher.Hash("Correct-Horse-9")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$"))
+	other, _ := hasher.Hash("Correct-Horse-9")
+	assert.NotEqual(t, encoded, other, "salts must differ")
+
+	match, rehash, err := hasher.Verify("Correct-Horse-9", encoded)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.False(t, rehash)
+
+	match, _, err = hasher.Verify("correct-horse-9", encoded)
+	require.NoError(t, err)
+	assert.False(t, match)
+
+	stronger, err := NewHasher(Params{Memory: 128, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	require.NoError(t, err)
+	match, rehash, err = stronger.Verify("Correct-Horse-9", encoded)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.True(t, rehash)
+
+	for _, malformed := range []string{"", "plain", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		_, _, err = hasher.Verify("x", malformed)
+		assert.ErrorIs(t, err, ErrMalformedHash, malformed)
+	}
+
+	_, err = NewHasher(Params{Memory: 64, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	assert.Error(t, err)
+}
+
+func TestPolicy(t *testing.T) {
+	cases := []struct {
+		password string
+		personal []string
+		err      string
+	}{
+		{password: "Correct-Horse-9"},
+		{password: "Short-1", err: "at least 12 characters"},
+		{password: strings.Repeat("Aa1-", 33), err: "at most 128 characters"},
+		{password: "onlylowercaseletters", err: "mix at least 3"},
+		{password: "Password1234", err: "too common"},
+		{password: "Maria.Silva-2024", personal: []string{"maria.silva@example.com"}, err: "personal data"},
+		{password: "Pass-52998224725", personal: []string{"52998224725"}, err: "personal data"},
+	}
+	for _, c := range cases {
+		err := DefaultPolicy.Check(c.password, c.personal...)
+		if c.err == "" {
+			assert.NoError(t, err, c.password)
+			continue
+		}
+		assert.ErrorIs(t, err, ErrPolicy, c.password)
+		assert.ErrorContains(t, err, c.err, c.password)
+	}
+}
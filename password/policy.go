@@ -0,0 +1,72 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxLength bounds the input hashed on every login attempt.
+const maxLength = 128
+
+var ErrPolicy = errors.New("password does not meet the policy")
+
+// Policy is the set of rules new passwords must follow. Existing hashes are
+// never re-checked, so tightening it only affects later changes.
+type Policy struct {
+	MinLength int
+	// MinClasses is how many of lower case letters, upper case letters,
+	// digits and symbols the password must mix.
+	MinClasses int
+}
+
+var DefaultPolicy = Policy{MinLength: 12, MinClasses: 3}
+
+// common lists passwords refused whatever their length, compared without
+// case.
+var common = map[string]bool{
+	"password1234": true, "password123!": true, "qwertyuiop12": true, "123456789abc": true,
+	"administrator": true, "letmein12345": true, "welcome12345": true, "iloveyou1234": true,
+}
+
+// Check returns an error wrapping ErrPolicy describing the first broken
+// rule. personal holds values tied to the user, such as their email and
+// document, which the password must not contain.
+func (p Policy) Check(password string, personal ...string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w: it must have at least %d characters", ErrPolicy, p.MinLength)
+	}
+	if length > maxLength {
+		return fmt.Errorf("%w: it must have at most %d characters", ErrPolicy, maxLength)
+	}
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	if lower+upper+digit+symbol < p.MinClasses {
+		return fmt.Errorf("%w: it must mix at least %d of lower case, upper case, digits and symbols", ErrPolicy, p.MinClasses)
+	}
+	folded := strings.ToLower(password)
+	if common[folded] {
+		return fmt.Errorf("%w: it is too common", ErrPolicy)
+	}
+	for _, value := range personal {
+		value, _, _ = strings.Cut(strings.ToLower(value), "@")
+		if len(value) >= 4 && strings.Contains(folded, value) {
+			return fmt.Errorf("%w: it must not contain personal data", ErrPolicy)
+		}
+	}
+	return nil
+}
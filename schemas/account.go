@@ -1,28 +1,125 @@
 package schemas
 
 import (
+	"context"
+	"errors"
 	"time"
 
+	"github.com/jamadeu/accounts/money"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrAccountNotFound   = errors.New("account not found")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrSameAccount       = errors.New("source and destination accounts must be different")
+	ErrWithdrawalLimit   = errors.New("amount exceeds the account withdrawal limit")
+)
+
+const (
+	AccountTypeChecking = "checking"
+	AccountTypeSavings  = "savings"
+	AccountTypeBusiness = "business"
+	AccountTypePayment  = "payment"
+)
+
+// Account holds a cached Balance derived from the ledger. WithdrawalLimit
+// caps every single debit on the account; a zero limit means no cap. Every
+// account belongs to its primary holder, HolderID, and may be shared with
+// other users through AccountHolder.
 type Account struct {
 	gorm.Model
-	Balance      float64       `gorm:"not null"`
-	User         User          `gorm:"not null"`
-	Transactions []Transaction `gorm:"not null"`
+	Type            string        `gorm:"not null;default:checking"`
+	Balance         money.Money   `gorm:"not null"`
+	WithdrawalLimit money.Money   `gorm:"not null;default:0"`
+	Currency        string        `gorm:"not null;default:BRL"`
+	HolderID        uint          `gorm:"not null;index"`
+	Holder          User          `gorm:"foreignKey:HolderID;constraint:OnDelete:RESTRICT"`
+	Transactions    []Transaction `gorm:"not null"`
+}
+
+// AfterFind restores the currency of the balance, which is not stored in the
+// numeric column itself.
+func (a *Account) AfterFind(tx *gorm.DB) error {
+	a.Balance.Currency = a.Currency
+	a.WithdrawalLimit.Currency = a.Currency
+	return nil
 }
 
 type AccountRepository interface {
-	CreateAccount(account Account) error
+	CreateAccount(ctx context.Context, account *Account) error
+	// FindById returns the account with its Holder loaded.
+	FindById(ctx context.Context, id uint) (*Account, error)
+	Deposit(ctx context.Context, id uint, amount money.Money) (*Transaction, error)
+	Withdraw(ctx context.Context, id uint, amount money.Money) (*Transaction, error)
+	Transfer(ctx context.Context, fromId, toId uint, amount money.Money) (*Transfer, error)
+	BalanceAt(ctx context.Context, id uint, at time.Time) (money.Money, error)
+	// ListTransactions returns up to limit transactions created in [from, to),
+	// ordered by (CreatedAt, ID) and starting after the cursor when given.
+	ListTransactions(ctx context.Context, id uint, from, to time.Time, after *StatementCursor, limit int) ([]Transaction, error)
+	// BalanceBefore returns the balance derived from the ledger postings
+	// effective before at.
+	BalanceBefore(ctx context.Context, id uint, at time.Time) (money.Money, error)
+	Holders(ctx context.Context, id uint) ([]AccountHolder, error)
+	// HolderRole returns the role of the user on the account, or
+	// ErrHolderNotFound.
+	HolderRole(ctx context.Context, id, userID uint) (string, error)
+	AddHolder(ctx context.Context, holder *AccountHolder) error
+	// RemoveHolder refuses to remove the last primary holder. When the
+	// removed user is the account HolderID another primary holder takes its
+	// place.
+	RemoveHolder(ctx context.Context, id, userID uint) error
 }
 
+// AccountResponse does not embed transactions; they are served page by page
+// through the account statement. Holder is only set when the account is
+// served on its own rather than listed under its holder.
 type AccountResponse struct {
-	ID           uint          `json:"id"`
-	CreatedAt    time.Time     `json:"createdAt"`
-	UpdatedAt    time.Time     `json:"updatedAt"`
-	DeletedAt    time.Time     `json:"deletedAt,omitempty"`
-	Balance      float64       `json:"balance"`
-	User         UserResponse  `json:"user"`
-	Transactions []Transaction `json:"transactions"`
+	ID              uint          `json:"id"`
+	CreatedAt       time.Time     `json:"createdAt"`
+	UpdatedAt       time.Time     `json:"updatedAt"`
+	DeletedAt       *time.Time    `json:"deletedAt,omitempty"`
+	Type            string        `json:"type"`
+	Balance         money.Money   `json:"balance"`
+	WithdrawalLimit money.Money   `json:"withdrawalLimit"`
+	Currency        string        `json:"currency"`
+	HolderID        uint          `json:"holderId"`
+	Holder          *UserResponse `json:"holder,omitempty"`
+}
+
+func NewAccountResponse(a Account) AccountResponse {
+	return AccountResponse{
+		ID:              a.ID,
+		CreatedAt:       a.CreatedAt,
+		UpdatedAt:       a.UpdatedAt,
+		DeletedAt:       deletedAt(a.DeletedAt),
+		Type:            a.Type,
+		Balance:         a.Balance,
+		WithdrawalLimit: a.WithdrawalLimit,
+		Currency:        a.Currency,
+		HolderID:        a.HolderID,
+	}
+}
+
+// NewAccountResponseWithHolder is NewAccountResponse including the loaded
+// Holder.
+func NewAccountResponseWithHolder(a Account) AccountResponse {
+	response := NewAccountResponse(a)
+	holder := NewUserResponse(a.Holder)
+	response.Holder = &holder
+	return response
+}
+
+func deletedAt(d gorm.DeletedAt) *time.Time {
+	if !d.Valid {
+		return nil
+	}
+	return &d.Time
+}
+
+type BalanceResponse struct {
+	AccountID uint        `json:"accountId"`
+	Balance   money.Money `json:"balance"`
+	Currency  string      `json:"currency"`
+	At        time.Time   `json:"at"`
 }
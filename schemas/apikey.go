@@ -0,0 +1,85 @@
+package schemas
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyInvalid  = errors.New("api key is invalid, expired or revoked")
+)
+
+// APIKey lets a partner system call the API as its user. The key is shown
+// once, when it is created or rotated: Prefix is the part kept in the clear
+// to tell keys apart, and only the SHA-256 of the rest is stored. Scopes
+// narrow what the user's role allows.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	User       User   `json:"-"`
+	Name       string `gorm:"not null"`
+	Prefix     string `gorm:"not null;uniqueIndex"`
+	SecretHash string `gorm:"not null" json:"-"`
+	// Scopes is the space separated list of permissions, as in OAuth.
+	Scopes     string `gorm:"not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ScopeList returns the scopes of the key.
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// Active reports whether the key may be used at now.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *APIKey) error
+	// ListByUser returns the keys of the user, revoked ones included,
+	// oldest first.
+	ListByUser(ctx context.Context, userID uint) ([]APIKey, error)
+	// FindByPrefix returns the key with prefix and its User, or
+	// ErrAPIKeyNotFound.
+	FindByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	// Rotate replaces the active key id of userID with replacement, which
+	// takes its name, scopes and expiry. The old key keeps working for
+	// overlap so clients can switch over. It returns ErrAPIKeyNotFound when
+	// the user has no such active key.
+	Rotate(ctx context.Context, id, userID uint, replacement *APIKey, overlap time.Duration) error
+	// Revoke revokes the key id of userID, or returns ErrAPIKeyNotFound.
+	Revoke(ctx context.Context, id, userID uint) error
+	RecordUse(ctx context.Context, id uint, at time.Time) error
+}
+
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func NewAPIKeyResponse(k APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.ScopeList(),
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
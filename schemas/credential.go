@@ -0,0 +1,62 @@
+package schemas
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrCredentialNotFound = errors.New("user has no password")
+	ErrResetTokenInvalid  = errors.New("reset token is invalid, expired or already used")
+)
+
+// Credential is the password of a user, kept apart from User so the hash is
+// never loaded or serialized with the profile. Consecutive failed logins
+// lock it until LockedUntil.
+type Credential struct {
+	UserID            uint   `gorm:"primaryKey;autoIncrement:false"`
+	PasswordHash      string `gorm:"not null"`
+	FailedLogins      int    `gorm:"not null;default:0"`
+	LockedUntil       *time.Time
+	PasswordChangedAt time.Time `gorm:"not null"`
+	LastLoginAt       *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// Locked reports whether logins are refused at now.
+func (c *Credential) Locked(now time.Time) bool {
+	return c.LockedUntil != nil && now.Before(*c.LockedUntil)
+}
+
+// PasswordReset is a single use token letting a user set a new password
+// without the current one. Only the SHA-256 of the token is stored.
+type PasswordReset struct {
+	TokenHash string    `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type CredentialRepository interface {
+	FindByUser(ctx context.Context, userID uint) (*Credential, error)
+	// SetPassword stores hash as the user's password, creating the
+	// credential when missing, and clears failed logins and any lockout.
+	SetPassword(ctx context.Context, userID uint, hash string) error
+	// RecordFailedLogin counts a failed login and, on reaching maxFailures,
+	// locks the credential for lockFor and starts counting again.
+	RecordFailedLogin(ctx context.Context, userID uint, maxFailures int, lockFor time.Duration) (*Credential, error)
+	// RecordLogin clears failed logins. A non-empty rehash replaces the
+	// stored hash without changing when the password was last changed.
+	RecordLogin(ctx context.Context, userID uint, rehash string) error
+	CreateReset(ctx context.Context, reset *PasswordReset) error
+	// FindReset returns the reset token with tokenHash while it can still
+	// be used, and ErrResetTokenInvalid otherwise.
+	FindReset(ctx context.Context, tokenHash string) (*PasswordReset, error)
+	// ResetPassword consumes the unexpired reset token with tokenHash and
+	// sets hash as the password of its user, invalidating the user's other
+	// reset tokens. It returns the user.
+	ResetPassword(ctx context.Context, tokenHash, hash string) (uint, error)
+}
@@ -0,0 +1,41 @@
+package schemas
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrHolderNotFound    = errors.New("user is not a holder of the account")
+	ErrHolderExists      = errors.New("user is already a holder of the account")
+	ErrLastPrimaryHolder = errors.New("the last primary holder of an account cannot be removed")
+)
+
+const (
+	HolderRolePrimary          = "primary"
+	HolderRoleJoint            = "joint"
+	HolderRoleAuthorizedSigner = "authorized_signer"
+	HolderRoleViewer           = "viewer"
+)
+
+var HolderRoles = []string{HolderRolePrimary, HolderRoleJoint, HolderRoleAuthorizedSigner, HolderRoleViewer}
+
+// AccountHolder gives a user a role on an account. Every account keeps at
+// least one primary holder, and Account.HolderID always names one of them.
+type AccountHolder struct {
+	AccountID uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"primaryKey;index"`
+	Role      string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+type AccountHolderResponse struct {
+	AccountID uint      `json:"accountId"`
+	UserID    uint      `json:"userId"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func NewAccountHolderResponse(h AccountHolder) AccountHolderResponse {
+	return AccountHolderResponse{AccountID: h.AccountID, UserID: h.UserID, Role: h.Role, CreatedAt: h.CreatedAt}
+}
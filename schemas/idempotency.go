@@ -0,0 +1,28 @@
+package schemas
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyKey stores the outcome of a mutating request so that a client
+// retrying with the same Idempotency-Key header gets the original response
+// instead of repeating the operation.
+type IdempotencyKey struct {
+	Key         string `gorm:"primaryKey"`
+	Fingerprint string `gorm:"not null"`
+	Completed   bool   `gorm:"not null;default:false"`
+	StatusCode  int
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+type IdempotencyRepository interface {
+	// Reserve stores key as in progress. When an unexpired record with the
+	// same key already exists it is returned instead and nothing is stored.
+	Reserve(ctx context.Context, key *IdempotencyKey) (*IdempotencyKey, error)
+	Complete(ctx context.Context, key string, statusCode int, body []byte) error
+	Release(ctx context.Context, key string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
@@ -0,0 +1,39 @@
+package schemas
+
+import (
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+	"gorm.io/gorm"
+)
+
+// JournalEntry is a single balanced movement in the ledger. The sum of the
+// debit postings of an entry always equals the sum of its credit postings.
+type JournalEntry struct {
+	gorm.Model
+	Description string    `gorm:"not null"`
+	Reference   string    `gorm:"index"`
+	EffectiveAt time.Time `gorm:"not null;index"`
+	Postings    []Posting `gorm:"not null"`
+}
+
+// Posting is one debit or credit line of a journal entry. AccountID is set
+// when the line moves money on a customer account; system accounts such as
+// cash are identified by LedgerAccount only.
+type Posting struct {
+	gorm.Model
+	JournalEntryID uint        `gorm:"not null;index"`
+	LedgerAccount  string      `gorm:"not null;index"`
+	AccountID      *uint       `gorm:"index"`
+	Direction      string      `gorm:"not null"`
+	Amount         money.Money `gorm:"not null"`
+	Currency       string      `gorm:"not null;default:BRL"`
+	EffectiveAt    time.Time   `gorm:"not null;index"`
+}
+
+// AfterFind restores the currency of the amount, which is not stored in the
+// numeric column itself.
+func (p *Posting) AfterFind(tx *gorm.DB) error {
+	p.Amount.Currency = p.Currency
+	return nil
+}
@@ -0,0 +1,35 @@
+package schemas
+
+import (
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+)
+
+// StatementCursor is the position of the last line returned by a statement
+// page. Lines are ordered by (CreatedAt, ID).
+type StatementCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+type StatementLine struct {
+	TransactionID  uint        `json:"transactionId"`
+	CreatedAt      time.Time   `json:"createdAt"`
+	Type           string      `json:"type"`
+	Direction      string      `json:"direction"`
+	Amount         money.Money `json:"amount"`
+	RunningBalance money.Money `json:"runningBalance"`
+	TransferID     string      `json:"transferId,omitempty"`
+}
+
+type Statement struct {
+	AccountID      uint            `json:"accountId"`
+	Currency       string          `json:"currency"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance money.Money     `json:"openingBalance"`
+	ClosingBalance money.Money     `json:"closingBalance"`
+	Lines          []StatementLine `json:"lines"`
+	NextCursor     string          `json:"nextCursor,omitempty"`
+}
@@ -3,20 +3,55 @@ package schemas
 import (
 	"time"
 
+	"github.com/jamadeu/accounts/money"
 	"gorm.io/gorm"
 )
 
+const (
+	TransactionTypeDeposit    = "deposit"
+	TransactionTypeWithdrawal = "withdrawal"
+	TransactionTypeTransfer   = "transfer"
+
+	DirectionCredit = "credit"
+	DirectionDebit  = "debit"
+)
+
 type Transaction struct {
 	gorm.Model
-	Type      string `gorm:"not null"`
-	AccountID uint   `gorm:"not null"`
+	Type         string      `gorm:"not null"`
+	Direction    string      `gorm:"not null"`
+	Amount       money.Money `gorm:"not null"`
+	BalanceAfter money.Money `gorm:"not null"`
+	Currency     string      `gorm:"not null;default:BRL"`
+	AccountID    uint        `gorm:"not null;index"`
+	TransferID   string      `gorm:"index"`
+}
+
+// AfterFind restores the currency of the amounts, which is not stored in the
+// numeric columns themselves.
+func (t *Transaction) AfterFind(tx *gorm.DB) error {
+	t.Amount.Currency = t.Currency
+	t.BalanceAfter.Currency = t.Currency
+	return nil
+}
+
+// Transfer groups the two legs posted by an account-to-account transfer.
+type Transfer struct {
+	ID     string      `json:"id"`
+	Debit  Transaction `json:"debit"`
+	Credit Transaction `json:"credit"`
 }
 
 type TransactionResponse struct {
-	ID        uint      `json:"id"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
-	DeletedAt time.Time `json:"deletedAt,omitempty"`
-	Type      string    `json:"type"`
-	AccountID uint      `json:"accountId"`
+	ID           uint        `json:"id"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	UpdatedAt    time.Time   `json:"updatedAt"`
+	DeletedAt    time.Time   `json:"deletedAt,omitempty"`
+	Type         string      `json:"type"`
+	Direction    string      `json:"direction"`
+	Amount       money.Money `json:"amount"`
+	BalanceAfter money.Money `json:"balanceAfter"`
+	Currency     string      `json:"currency"`
+	AccountID    uint        `json:"accountId"`
+	TransferID   string      `json:"transferId,omitempty"`
 }
@@ -0,0 +1,48 @@
+package schemas
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotPending = errors.New("no two-factor enrollment is in progress")
+	ErrCodeReused          = errors.New("code was already used")
+	ErrRecoveryCodeInvalid = errors.New("recovery code is invalid or already used")
+)
+
+// RecoveryCode lets a user who lost their authenticator verify a second
+// factor once. Only the SHA-256 of the code is stored.
+type RecoveryCode struct {
+	CodeHash  string `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type TwoFactorRepository interface {
+	// BeginEnrollment stores secret as the user's pending TOTP secret,
+	// replacing any earlier pending one, or fails with ErrTwoFactorEnabled.
+	BeginEnrollment(ctx context.Context, userID uint, secret string) error
+	// CompleteEnrollment enables the pending secret, records step as used,
+	// restarts the count of wrong codes and stores the recovery codes, or
+	// fails with ErrTwoFactorNotPending.
+	CompleteEnrollment(ctx context.Context, userID uint, step int64, codeHashes []string) error
+	// UseStep records a verified time step, failing with ErrCodeReused
+	// unless it is later than the last one used.
+	UseStep(ctx context.Context, userID uint, step int64) error
+	// UseRecoveryCode marks the user's unused code with codeHash as used, or
+	// fails with ErrRecoveryCodeInvalid.
+	UseRecoveryCode(ctx context.Context, userID uint, codeHash string) error
+	// ReplaceRecoveryCodes discards the user's recovery codes, used or not,
+	// and stores new ones.
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error
+	// RecordFailedCode counts a wrong code, locking verification for lockFor
+	// and restarting the count once maxFailures is reached, and returns the
+	// updated user.
+	RecordFailedCode(ctx context.Context, userID uint, maxFailures int, lockFor time.Duration) (*User, error)
+	// RecordVerifiedCode restarts the count of wrong codes.
+	RecordVerifiedCode(ctx context.Context, userID uint) error
+}
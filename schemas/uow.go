@@ -0,0 +1,17 @@
+package schemas
+
+import "context"
+
+// Repositories are the repositories of a unit of work, all bound to the same
+// database transaction.
+type Repositories struct {
+	Users    UserRepository
+	Accounts AccountRepository
+}
+
+type TransactionManager interface {
+	// WithinTransaction runs fn in a new database transaction. The
+	// transaction is committed when fn returns nil and rolled back when it
+	// returns an error or panics.
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context, repos Repositories) error) error
+}
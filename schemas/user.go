@@ -1,34 +1,120 @@
 package schemas
 
 import (
+	"context"
+	"errors"
 	"time"
 
+	"github.com/jamadeu/accounts/document"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrDocumentTaken = errors.New("document is already registered to another user")
+	ErrEmailTaken    = errors.New("email is already registered to another user")
+)
+
+const (
+	UserKindIndividual = "individual"
+	UserKindCompany    = "company"
+)
+
+// Roles of the people using the API. Customers hold accounts; the others
+// are back-office staff.
+const (
+	RoleCustomer   = "customer"
+	RoleSupport    = "support_agent"
+	RoleCompliance = "compliance_officer"
+	RoleAdmin      = "admin"
+)
+
+var Roles = []string{RoleCustomer, RoleSupport, RoleCompliance, RoleAdmin}
+
+// UserKindFor returns the customer kind implied by a document type: CPF
+// holders are individuals and CNPJ holders are companies.
+func UserKindFor(t document.Type) string {
+	if t == document.CNPJ {
+		return UserKindCompany
+	}
+	return UserKindIndividual
+}
+
 type User struct {
 	gorm.Model
 	Name      string `gorm:"not null"`
-	Document  string `gorm:"not null,unique"`
-	Email     string `gorm:"not null,unique"`
-	AccountID uint
+	Document  string `gorm:"not null;unique"`
+	Email     string `gorm:"not null;unique"`
+	Kind      string `gorm:"not null;default:individual"`
+	Role      string `gorm:"not null;default:customer"`
+	BirthDate *time.Time
+	LegalName string
+	TradeName string
+	Accounts  []Account `gorm:"foreignKey:HolderID" json:",omitempty"`
+	// TOTPSecret is the RFC 6238 secret of the user's authenticator. It is
+	// pending until TOTPEnabledAt is set by a first valid code.
+	TOTPSecret    string     `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabledAt *time.Time `gorm:"column:totp_enabled_at" json:"-"`
+	// TOTPLastStep is the last time step a code was accepted for, so each
+	// code is only accepted once.
+	TOTPLastStep int64 `gorm:"column:totp_last_step;not null;default:0" json:"-"`
+	// Consecutive wrong codes lock verification until TOTPLockedUntil.
+	TOTPFailedAttempts int        `gorm:"column:totp_failed_attempts;not null;default:0" json:"-"`
+	TOTPLockedUntil    *time.Time `gorm:"column:totp_locked_until" json:"-"`
+}
+
+// TwoFactorEnabled reports whether the user completed TOTP enrollment.
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// TwoFactorLocked reports whether second factor codes are refused at now.
+func (u *User) TwoFactorLocked(now time.Time) bool {
+	return u.TOTPLockedUntil != nil && now.Before(*u.TOTPLockedUntil)
 }
 
 type UserRepository interface {
-	FindById(id string) (*User, error)
-	ListUsers() (*[]User, error)
-	Create(user *User) (User, error)
-	Update(user *User) error
-	Delete(user *User) error
+	FindById(ctx context.Context, id string) (*User, error)
+	// FindByEmail matches the email without regard to case.
+	FindByEmail(ctx context.Context, email string) (*User, error)
+	ListUsers(ctx context.Context) (*[]User, error)
+	// Create and Update return ErrDocumentTaken or ErrEmailTaken when
+	// another user has the same document or email.
+	Create(ctx context.Context, user *User) (User, error)
+	// FindWithAccounts returns the user with the Accounts they hold, in any
+	// role.
+	FindWithAccounts(ctx context.Context, id string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	Delete(ctx context.Context, user *User) error
 }
 
 type UserResponse struct {
-	ID        uint      `json:"id"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
-	DeletedAt time.Time `json:"deletedAt,omitempty"`
-	Name      string    `json:"name"`
-	Document  string    `json:"document"`
-	Email     string    `json:"email"`
-	AccountID uint      `json:"accountId"`
+	ID        uint       `json:"id"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	Name      string     `json:"name"`
+	Document  string     `json:"document"`
+	Email     string     `json:"email"`
+	Kind      string     `json:"kind"`
+	Role      string     `json:"role"`
+	BirthDate *time.Time `json:"birthDate,omitempty"`
+	LegalName string     `json:"legalName,omitempty"`
+	TradeName string     `json:"tradeName,omitempty"`
+}
+
+func NewUserResponse(u User) UserResponse {
+	return UserResponse{
+		ID:        u.ID,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		DeletedAt: deletedAt(u.DeletedAt),
+		Name:      u.Name,
+		Document:  u.Document,
+		Email:     u.Email,
+		Kind:      u.Kind,
+		Role:      u.Role,
+		BirthDate: u.BirthDate,
+		LegalName: u.LegalName,
+		TradeName: u.TradeName,
+	}
 }
@@ -0,0 +1,1194 @@
+package account
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/services/rbactest"
+	"github.com/stretchr/testify/assert"
+)
+
+var today = time.Now()
+var accountTest = schemas.Account{
+	Model: gorm.Model{
+		ID:        1,
+		CreatedAt: today,
+		UpdatedAt: today,
+	},
+	Type:            schemas.AccountTypeChecking,
+	Balance:         money.MustParse("100", "BRL"),
+	WithdrawalLimit: money.MustParse("500", "BRL"),
+	Currency:        "BRL",
+	HolderID:        1,
+	Holder:          schemas.User{Model: gorm.Model{ID: 1}, Name: "Test", Kind: schemas.UserKindIndividual},
+}
+var otherAccountTest = schemas.Account{
+	Model: gorm.Model{
+		ID:        3,
+		CreatedAt: today,
+		UpdatedAt: today,
+	},
+	Balance:  money.Zero("BRL"),
+	Currency: "BRL",
+	HolderID: 1,
+}
+
+var transactionsTest = []schemas.Transaction{
+	transactionTest(11, -3*time.Hour, schemas.TransactionTypeDeposit, schemas.DirectionCredit, "50", "50"),
+	transactionTest(12, -2*time.Hour, schemas.TransactionTypeDeposit, schemas.DirectionCredit, "70", "120"),
+	transactionTest(13, -1*time.Hour, schemas.TransactionTypeWithdrawal, schemas.DirectionDebit, "20", "100"),
+}
+
+func transactionTest(id uint, offset time.Duration, typ, direction, amount, balance string) schemas.Transaction {
+	return schemas.Transaction{
+		Model:        gorm.Model{ID: id, CreatedAt: today.Add(offset), UpdatedAt: today.Add(offset)},
+		Type:         typ,
+		Direction:    direction,
+		Amount:       money.MustParse(amount, "BRL"),
+		BalanceAfter: money.MustParse(balance, "BRL"),
+		Currency:     "BRL",
+		AccountID:    1,
+	}
+}
+
+var holdersTest = map[uint][]schemas.AccountHolder{
+	1: {
+		{AccountID: 1, UserID: 1, Role: schemas.HolderRolePrimary, CreatedAt: today},
+		{AccountID: 1, UserID: 2, Role: schemas.HolderRoleViewer, CreatedAt: today},
+		{AccountID: 1, UserID: 3, Role: schemas.HolderRoleAuthorizedSigner, CreatedAt: today},
+	},
+	3: {
+		{AccountID: 3, UserID: 1, Role: schemas.HolderRolePrimary, CreatedAt: today},
+		{AccountID: 3, UserID: 2, Role: schemas.HolderRolePrimary, CreatedAt: today},
+	},
+}
+
+func statementLineTest(t schemas.Transaction) schemas.StatementLine {
+	return schemas.StatementLine{
+		TransactionID:  t.ID,
+		CreatedAt:      t.CreatedAt,
+		Type:           t.Type,
+		Direction:      t.Direction,
+		Amount:         t.Amount,
+		RunningBalance: t.BalanceAfter,
+	}
+}
+
+func jsonToString(s interface{}) string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func TestAccountHandlers(t *testing.T) {
+	accountRepo := &mockAccountRepository{}
+	transactions := &mockTransactionManager{}
+	handler := NewAccountHandler(accountRepo, transactions)
+	router := gin.Default()
+	router.Use(withCaller(1))
+	handler.RegisterRoutes(router, "/api")
+
+	t.Run("handle deposit should return the credit transaction", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(TransactionRequest{Amount: money.MustParse("50", "")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/1/deposit", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleAdmin))
+
+		expected := schemas.Transaction{
+			Type:         schemas.TransactionTypeDeposit,
+			Direction:    schemas.DirectionCredit,
+			Amount:       money.MustParse("50", "BRL"),
+			BalanceAfter: money.MustParse("150", "BRL"),
+			Currency:     "BRL",
+			AccountID:    1,
+		}
+		expectedResponseBody := "{\"data\":" + jsonToString(expected) + "," +
+			"\"message\":\"operation from handler: deposit successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle deposit should keep decimal amounts exact", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/1/deposit", bytes.NewBufferString(`{"amount":"0.10"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleAdmin))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "\"Amount\":\"0.10\",\"BalanceAfter\":\"100.10\"")
+	})
+
+	t.Run("handle withdraw should return the debit transaction", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(TransactionRequest{Amount: money.MustParse("30", "")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/1/withdraw", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expected := schemas.Transaction{
+			Type:         schemas.TransactionTypeWithdrawal,
+			Direction:    schemas.DirectionDebit,
+			Amount:       money.MustParse("30", "BRL"),
+			BalanceAfter: money.MustParse("70", "BRL"),
+			Currency:     "BRL",
+			AccountID:    1,
+		}
+		expectedResponseBody := "{\"data\":" + jsonToString(expected) + "," +
+			"\"message\":\"operation from handler: withdraw successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle withdraw should return 422 when funds are insufficient", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(TransactionRequest{Amount: money.MustParse("101", "")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/1/withdraw", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"insufficient funds\"}"
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle deposit should return 400 when amount is not positive", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(TransactionRequest{Amount: money.MustParse("-10", "")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/1/deposit", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleAdmin))
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: amount (type: decimal) must be greater than zero\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle deposit should return 422 when the currency differs from the account's", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/1/deposit", bytes.NewBufferString(`{"amount":"10","currency":"USD"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleAdmin))
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"money: currency mismatch: account 1 holds BRL, not USD\"}"
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle deposit should return 400 when account id is invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(TransactionRequest{Amount: money.MustParse("10", "")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/abc/deposit", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleAdmin))
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: id (type: pathParameter) must be a positive integer\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle deposit should return 404 when account is not found", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(TransactionRequest{Amount: money.MustParse("10", "")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/2/deposit", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleAdmin))
+
+		expectedResponseBody := "{\"errorCode\":404,\"message\":\"account with id: 2 not found\"}"
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle transfer should return both linked legs", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := TransferRequest{FromAccountId: 1, ToAccountId: 3, Amount: money.MustParse("40", "")}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/transfer", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expected := schemas.Transfer{
+			ID: "transfer-1",
+			Debit: schemas.Transaction{
+				Type:         schemas.TransactionTypeTransfer,
+				Direction:    schemas.DirectionDebit,
+				Amount:       money.MustParse("40", "BRL"),
+				BalanceAfter: money.MustParse("60", "BRL"),
+				Currency:     "BRL",
+				AccountID:    1,
+				TransferID:   "transfer-1",
+			},
+			Credit: schemas.Transaction{
+				Type:         schemas.TransactionTypeTransfer,
+				Direction:    schemas.DirectionCredit,
+				Amount:       money.MustParse("40", "BRL"),
+				BalanceAfter: money.MustParse("40", "BRL"),
+				Currency:     "BRL",
+				AccountID:    3,
+				TransferID:   "transfer-1",
+			},
+		}
+		expectedResponseBody := "{\"data\":" + jsonToString(expected) + "," +
+			"\"message\":\"operation from handler: transfer successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle transfer should return 400 when accounts are the same", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := TransferRequest{FromAccountId: 1, ToAccountId: 1, Amount: money.MustParse("40", "")}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/transfer", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: toAccountId must differ from fromAccountId\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle transfer should return 422 when funds are insufficient", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := TransferRequest{FromAccountId: 3, ToAccountId: 1, Amount: money.MustParse("1", "")}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/transfer", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"insufficient funds\"}"
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle transfer should return 422 when the currency differs from the accounts'", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/transfer", bytes.NewBufferString(`{"fromAccountId":1,"toAccountId":3,"amount":"1","currency":"EUR"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"money: currency mismatch: account 1 holds BRL, not EUR\"}"
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle transfer should require a second factor above the threshold", func(t *testing.T) {
+		payload := TransferRequest{FromAccountId: 1, ToAccountId: 3, Amount: money.MustParse("1000.01", "")}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/transfer", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "insufficient_user_authentication")
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/api/v1/account/transfer", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req.WithContext(services.WithSecondFactor(req.Context(), time.Now())))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "past the step-up the transfer is attempted")
+	})
+
+	t.Run("handle balance should return the ledger balance at the given time", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		at := today.Add(-time.Hour).UTC().Truncate(time.Second)
+		req, err := http.NewRequest("GET", "/api/v1/account/1/balance?at="+at.Format(time.RFC3339), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expected := schemas.BalanceResponse{
+			AccountID: 1,
+			Balance:   money.Zero("BRL"),
+			Currency:  "BRL",
+			At:        at,
+		}
+		expectedResponseBody := "{\"data\":" + jsonToString(expected) + "," +
+			"\"message\":\"operation from handler: balance successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle balance should return 400 when at is invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/1/balance?at=yesterday", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: at (type: RFC3339 timestamp) is invalid\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+	t.Run("handle statement should return a page with opening and closing balances", func(t *testing.T) {
+		from := today.Add(-150 * time.Minute).UTC()
+		to := today.UTC()
+		query := "?limit=1&from=" + from.Format(time.RFC3339Nano) + "&to=" + to.Format(time.RFC3339Nano)
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/1/statement"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		page := schemas.Statement{
+			AccountID:      1,
+			Currency:       "BRL",
+			From:           from,
+			To:             to,
+			OpeningBalance: money.MustParse("50", "BRL"),
+			ClosingBalance: money.MustParse("100", "BRL"),
+			Lines:          []schemas.StatementLine{statementLineTest(transactionsTest[1])},
+			NextCursor: encodeCursor(schemas.StatementCursor{
+				CreatedAt: transactionsTest[1].CreatedAt,
+				ID:        transactionsTest[1].ID,
+			}),
+		}
+		expectedResponseBody := "{\"data\":" + jsonToString(page) + "," +
+			"\"message\":\"operation from handler: statement successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/api/v1/account/1/statement"+query+"&cursor="+page.NextCursor, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		page.Lines = []schemas.StatementLine{statementLineTest(transactionsTest[2])}
+		page.NextCursor = ""
+		expectedResponseBody = "{\"data\":" + jsonToString(page) + "," +
+			"\"message\":\"operation from handler: statement successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle statement should return 400 when cursor is invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/1/statement?cursor=not-a-cursor", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: cursor is invalid\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle statement should return 400 when range is inverted", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/1/statement?from=2024-02-01&to=2024-01-01", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: from must be before to\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+	t.Run("handle statement export should return every line as CSV", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/1/statement.csv", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "attachment; filename=\"statement-1.csv\"", w.Header().Get("Content-Disposition"))
+		assert.Equal(t, len(transactionsTest)+1, strings.Count(w.Body.String(), "\n"))
+	})
+
+	t.Run("handle statement export should return 403 when the caller does not hold the account", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/2/statement.xml", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"user 1 is not a holder of account 2\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+	t.Run("handle create should open the default account type for the customer kind", func(t *testing.T) {
+		for userId, accountType := range map[uint]string{1: schemas.AccountTypeChecking, 2: schemas.AccountTypeBusiness} {
+			w := httptest.NewRecorder()
+			b, err := json.Marshal(CreateAccountRequest{UserId: strconv.FormatUint(uint64(userId), 10)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBuffer(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, asCaller(req, userId))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), "\"type\":\""+accountType+"\"")
+		}
+	})
+
+	t.Run("handle create should make the user the holder of the new account", func(t *testing.T) {
+		transactions.accounts = nil
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBufferString(`{"userId":"1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, transactions.accounts, 1)
+		assert.Equal(t, uint(1), transactions.accounts[0].HolderID)
+		assert.Contains(t, w.Body.String(), "\"id\":10,")
+		assert.Contains(t, w.Body.String(), "\"holderId\":1,\"holder\":{\"id\":1,")
+	})
+
+	t.Run("handle find should return the account with its holder", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"data\":" + jsonToString(schemas.NewAccountResponseWithHolder(accountTest)) + "," +
+			"\"message\":\"operation from handler: find-account successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle find should return 403 when the caller does not hold the account", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/2", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"user 1 is not a holder of account 2\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 400 when the balance is negative", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBufferString(`{"userId":"1","accountBalance":"-1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: accountBalance (type: decimal) must not be negative\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 400 when the currency is not supported", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBufferString(`{"userId":"1","currency":"JPY"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: currency must be one of BRL, EUR, GBP, USD\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 400 when request body is empty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBufferString(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"reqest body is empty or malformed\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 403 when a customer sets an opening balance", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBufferString(`{"userId":"1","accountBalance":"100"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"opening balances are cash deposits, which only staff may post\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 403 when opening an account for another user", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBufferString(`{"userId":"2"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"accounts may only be opened for the caller\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 422 when account type is not allowed for the kind", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(CreateAccountRequest{UserId: "2", Type: schemas.AccountTypeSavings})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 2))
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"account type savings is not available for company customers\"}"
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 422 when individual is under age", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(CreateAccountRequest{UserId: "3"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 3))
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"account holder must be at least 18 years old\"}"
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle withdraw should return 422 when amount exceeds the withdrawal limit", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(TransactionRequest{Amount: money.MustParse("600", "")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/account/1/withdraw", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"amount exceeds the account withdrawal limit\"}"
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+}
+
+// slowAccountId identifies an account whose queries never finish on their
+// own, so they only return once the request context is done.
+const slowAccountId = 99
+
+// query stands in for a database round trip. Like the real repository it
+// requires a bounded context and gives up with the context error once the
+// context is done.
+func query(ctx context.Context, slow bool) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("query issued without a deadline")
+	}
+	if slow {
+		<-ctx.Done()
+	}
+	return ctx.Err()
+}
+
+func TestAccountHandlersContext(t *testing.T) {
+	handler := NewAccountHandler(&mockAccountRepository{}, &mockTransactionManager{})
+	handler.deadlines = services.Deadlines{Read: 20 * time.Millisecond, Write: 20 * time.Millisecond, Export: 20 * time.Millisecond}
+	router := gin.Default()
+	router.Use(withCaller(1))
+	handler.RegisterRoutes(router, "/api")
+
+	t.Run("handle deposit should return 504 when the write deadline expires", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/99/deposit", bytes.NewBufferString(`{"amount":"10"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleAdmin))
+
+		expectedResponseBody := "{\"errorCode\":504,\"message\":\"request timed out\"}"
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle statement export should return 504 when the export deadline expires", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/99/statement.csv", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("handle balance should return 499 when the client cancelled the request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, err := http.NewRequestWithContext(cancelled, "GET", "/api/v1/account/1/balance", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":499,\"message\":\"request cancelled\"}"
+		assert.Equal(t, services.StatusClientClosedRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 499 when the client cancelled the request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, err := http.NewRequestWithContext(cancelled, "POST", "/api/v1/account", bytes.NewBufferString(`{"userId":"1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, services.StatusClientClosedRequest, w.Code)
+	})
+}
+
+// createdAccountId is the ID the mock assigns to every account it creates.
+const createdAccountId = 10
+
+// withCaller identifies userID on every request that does not name its own
+// caller, standing in for the token middleware.
+func withCaller(userID uint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := services.Caller(ctx.Request.Context()); !ok {
+			ctx.Request = asCaller(ctx.Request, userID)
+		}
+		ctx.Next()
+	}
+}
+
+// asCaller identifies userID as the user making req.
+func asCaller(req *http.Request, userID uint) *http.Request {
+	return req.WithContext(services.WithCaller(req.Context(), userID))
+}
+
+// asRole gives the caller of req role.
+func asRole(req *http.Request, role string) *http.Request {
+	return req.WithContext(services.WithRole(req.Context(), role))
+}
+
+func TestAccountHolders(t *testing.T) {
+	handler := NewAccountHandler(&mockAccountRepository{}, &mockTransactionManager{})
+	router := gin.Default()
+	router.Use(withCaller(1))
+	handler.RegisterRoutes(router, "/api")
+
+	t.Run("handle list holders should return every holder with their role", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/account/1/holders", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expected := []schemas.AccountHolderResponse{}
+		for _, h := range holdersTest[1] {
+			expected = append(expected, schemas.NewAccountHolderResponse(h))
+		}
+		expectedResponseBody := "{\"data\":" + jsonToString(expected) + "," +
+			"\"message\":\"operation from handler: list-holders successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle add holder should add the user with the given role", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/3/holders", bytes.NewBufferString(`{"userId":"3","role":"joint"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expected := schemas.AccountHolderResponse{AccountID: 3, UserID: 3, Role: schemas.HolderRoleJoint, CreatedAt: today}
+		expectedResponseBody := "{\"data\":" + jsonToString(expected) + "," +
+			"\"message\":\"operation from handler: add-holder successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle add holder should return 409 when the user already holds the account", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/1/holders", bytes.NewBufferString(`{"userId":"2","role":"joint"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":409,\"message\":\"user is already a holder of the account\"}"
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle add holder should return 400 when role is unknown", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/1/holders", bytes.NewBufferString(`{"userId":"2","role":"owner"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: role must be one of primary, joint, authorized_signer, viewer\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle add holder should return 400 when user id is not numeric", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/account/1/holders", bytes.NewBufferString(`{"userId":"2 OR 1=1","role":"joint"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: userId (type: uint) must be a positive integer\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle remove holder should remove a primary holder when another remains", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/account/3/holders/2", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("handle remove holder should return 409 for the last primary holder", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/account/1/holders/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"errorCode\":409,\"message\":\"the last primary holder of an account cannot be removed\"}"
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle remove holder should return 404 when the user is not a holder", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/account/1/holders/4", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	for _, tc := range []struct {
+		name   string
+		caller uint
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"viewer may read the balance", 2, "GET", "/api/v1/account/1/balance", "", http.StatusOK},
+		{"viewer may not withdraw", 2, "POST", "/api/v1/account/1/withdraw", `{"amount":"10"}`, http.StatusForbidden},
+		{"viewer may not transfer", 2, "POST", "/api/v1/account/transfer", `{"fromAccountId":1,"toAccountId":3,"amount":"10"}`, http.StatusForbidden},
+		{"authorized signer may withdraw", 3, "POST", "/api/v1/account/1/withdraw", `{"amount":"10"}`, http.StatusOK},
+		{"authorized signer may not manage holders", 3, "DELETE", "/api/v1/account/1/holders/2", "", http.StatusForbidden},
+		{"primary holder may transfer", 1, "POST", "/api/v1/account/transfer", `{"fromAccountId":1,"toAccountId":3,"amount":"10"}`, http.StatusOK},
+		{"non holder may not read the statement", 4, "GET", "/api/v1/account/1/statement", "", http.StatusForbidden},
+		{"customer may not deposit cash", 1, "POST", "/api/v1/account/1/deposit", `{"amount":"10"}`, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, asCaller(req, tc.caller))
+
+			assert.Equal(t, tc.code, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestAccountPermissions(t *testing.T) {
+	handler := NewAccountHandler(&mockAccountRepository{}, &mockTransactionManager{})
+	router := gin.Default()
+	handler.RegisterRoutes(router, "/api")
+
+	customers := []string{schemas.RoleCustomer, schemas.RoleAdmin}
+	exporters := []string{schemas.RoleCustomer, schemas.RoleCompliance, schemas.RoleAdmin}
+	rbactest.AssertMatrix(t, router, 4,
+		rbactest.Rule{Method: "POST", Path: "/api/v1/account", Roles: customers},
+		rbactest.Rule{Method: "POST", Path: "/api/v1/account/transfer", Roles: customers},
+		rbactest.Rule{Method: "POST", Path: "/api/v1/account/x/deposit", Roles: []string{schemas.RoleAdmin}},
+		rbactest.Rule{Method: "POST", Path: "/api/v1/account/x/withdraw", Roles: customers},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/account/x", Roles: schemas.Roles},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/account/x/holders", Roles: schemas.Roles},
+		rbactest.Rule{Method: "POST", Path: "/api/v1/account/x/holders", Roles: customers},
+		rbactest.Rule{Method: "DELETE", Path: "/api/v1/account/x/holders/2", Roles: customers},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/account/x/balance", Roles: schemas.Roles},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/account/x/statement", Roles: schemas.Roles},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/account/x/statement.csv", Roles: exporters},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/account/x/statement.ofx", Roles: exporters},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/account/x/statement.xml", Roles: exporters},
+	)
+
+	for _, tc := range []struct {
+		name string
+		role string
+		path string
+		code int
+	}{
+		{"support agent may read the statement of any account", schemas.RoleSupport, "/api/v1/account/1/statement", http.StatusOK},
+		{"support agent may read the holders of any account", schemas.RoleSupport, "/api/v1/account/1/holders", http.StatusOK},
+		{"compliance officer may export the statement of any account", schemas.RoleCompliance, "/api/v1/account/1/statement.csv", http.StatusOK},
+		{"customer may not read the statement of an account they do not hold", schemas.RoleCustomer, "/api/v1/account/1/statement", http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, req.WithContext(services.WithRole(services.WithCaller(req.Context(), 4), tc.role)))
+
+			assert.Equal(t, tc.code, w.Code, w.Body.String())
+		})
+	}
+}
+
+type mockAccountRepository struct {
+	created []schemas.Account
+}
+
+func (m *mockAccountRepository) CreateAccount(ctx context.Context, account *schemas.Account) error {
+	if err := query(ctx, false); err != nil {
+		return err
+	}
+	account.ID = createdAccountId
+	m.created = append(m.created, *account)
+	return nil
+}
+
+func (m *mockAccountRepository) FindById(ctx context.Context, id uint) (*schemas.Account, error) {
+	if err := query(ctx, id == slowAccountId); err != nil {
+		return nil, err
+	}
+	for _, account := range []schemas.Account{accountTest, otherAccountTest} {
+		if account.ID == id {
+			return &account, nil
+		}
+	}
+	return nil, schemas.ErrAccountNotFound
+}
+
+func (m *mockAccountRepository) Deposit(ctx context.Context, id uint, amount money.Money) (*schemas.Transaction, error) {
+	return m.post(ctx, id, amount, schemas.TransactionTypeDeposit, schemas.DirectionCredit)
+}
+
+func (m *mockAccountRepository) Withdraw(ctx context.Context, id uint, amount money.Money) (*schemas.Transaction, error) {
+	return m.post(ctx, id, amount, schemas.TransactionTypeWithdrawal, schemas.DirectionDebit)
+}
+
+func (m *mockAccountRepository) Transfer(ctx context.Context, fromId, toId uint, amount money.Money) (*schemas.Transfer, error) {
+	if _, err := m.FindById(ctx, toId); err != nil {
+		return nil, err
+	}
+	debit, err := m.post(ctx, fromId, amount, schemas.TransactionTypeTransfer, schemas.DirectionDebit)
+	if err != nil {
+		return nil, err
+	}
+	credit, err := m.post(ctx, toId, amount, schemas.TransactionTypeTransfer, schemas.DirectionCredit)
+	if err != nil {
+		return nil, err
+	}
+	debit.TransferID = "transfer-1"
+	credit.TransferID = "transfer-1"
+	return &schemas.Transfer{ID: "transfer-1", Debit: *debit, Credit: *credit}, nil
+}
+
+func (m *mockAccountRepository) BalanceAt(ctx context.Context, id uint, at time.Time) (money.Money, error) {
+	account, err := m.FindById(ctx, id)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if at.Before(account.CreatedAt) {
+		return money.Zero(account.Currency), nil
+	}
+	return account.Balance, nil
+}
+
+func (m *mockAccountRepository) ListTransactions(ctx context.Context, id uint, from, to time.Time, after *schemas.StatementCursor, limit int) ([]schemas.Transaction, error) {
+	if err := query(ctx, id == slowAccountId); err != nil {
+		return nil, err
+	}
+	transactions := []schemas.Transaction{}
+	for _, t := range transactionsTest {
+		if t.AccountID != id || t.CreatedAt.Before(from) || !t.CreatedAt.Before(to) {
+			continue
+		}
+		if after != nil && !t.CreatedAt.After(after.CreatedAt) {
+			continue
+		}
+		if limit >= 0 && len(transactions) == limit {
+			break
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, nil
+}
+
+func (m *mockAccountRepository) BalanceBefore(ctx context.Context, id uint, at time.Time) (money.Money, error) {
+	account, err := m.FindById(ctx, id)
+	if err != nil {
+		return money.Money{}, err
+	}
+	balance := money.Zero(account.Currency)
+	for _, t := range transactionsTest {
+		if t.AccountID == id && t.CreatedAt.Before(at) {
+			balance = t.BalanceAfter
+		}
+	}
+	return balance, nil
+}
+
+func (m *mockAccountRepository) post(ctx context.Context, id uint, amount money.Money, typ, direction string) (*schemas.Transaction, error) {
+	account, err := m.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := sameCurrency(account, amount); err != nil {
+		return nil, err
+	}
+	balance, _ := account.Balance.Add(amount)
+	if direction == schemas.DirectionDebit {
+		if account.WithdrawalLimit.IsPositive() && amount.Cmp(account.WithdrawalLimit) > 0 {
+			return nil, schemas.ErrWithdrawalLimit
+		}
+		balance, _ = account.Balance.Sub(amount)
+	}
+	if balance.IsNegative() {
+		return nil, schemas.ErrInsufficientFunds
+	}
+	return &schemas.Transaction{
+		Type:         typ,
+		Direction:    direction,
+		Amount:       amount,
+		BalanceAfter: balance,
+		Currency:     account.Currency,
+		AccountID:    account.ID,
+	}, nil
+}
+
+func (m *mockAccountRepository) Holders(ctx context.Context, id uint) ([]schemas.AccountHolder, error) {
+	if _, err := m.FindById(ctx, id); err != nil {
+		return nil, err
+	}
+	return holdersTest[id], nil
+}
+
+func (m *mockAccountRepository) HolderRole(ctx context.Context, id, userID uint) (string, error) {
+	if err := query(ctx, id == slowAccountId); err != nil {
+		return "", err
+	}
+	for _, h := range holdersTest[id] {
+		if h.UserID == userID {
+			return h.Role, nil
+		}
+	}
+	return "", schemas.ErrHolderNotFound
+}
+
+func (m *mockAccountRepository) AddHolder(ctx context.Context, holder *schemas.AccountHolder) error {
+	if _, err := m.FindById(ctx, holder.AccountID); err != nil {
+		return err
+	}
+	if _, err := m.HolderRole(ctx, holder.AccountID, holder.UserID); err == nil {
+		return schemas.ErrHolderExists
+	}
+	holder.CreatedAt = today
+	return nil
+}
+
+func (m *mockAccountRepository) RemoveHolder(ctx context.Context, id, userID uint) error {
+	if _, err := m.FindById(ctx, id); err != nil {
+		return err
+	}
+	role, err := m.HolderRole(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	for _, h := range holdersTest[id] {
+		if h.UserID != userID && h.Role == schemas.HolderRolePrimary {
+			return nil
+		}
+	}
+	if role == schemas.HolderRolePrimary {
+		return schemas.ErrLastPrimaryHolder
+	}
+	return nil
+}
+
+// mockTransactionManager runs the callback against fresh mock repositories
+// and keeps what it wrote only when the callback succeeds, as a committed
+// transaction would.
+type mockTransactionManager struct {
+	accounts []schemas.Account
+}
+
+func (m *mockTransactionManager) WithinTransaction(ctx context.Context, fn func(ctx context.Context, repos schemas.Repositories) error) error {
+	accounts := &mockAccountRepository{}
+	if err := fn(ctx, schemas.Repositories{Users: &mockUserRepository{}, Accounts: accounts}); err != nil {
+		return err
+	}
+	m.accounts = append(m.accounts, accounts.created...)
+	return nil
+}
+
+type mockUserRepository struct{}
+
+func (m *mockUserRepository) FindById(ctx context.Context, id string) (*schemas.User, error) {
+	if err := query(ctx, false); err != nil {
+		return nil, err
+	}
+	adult := today.AddDate(-30, 0, 0)
+	minor := today.AddDate(-16, 0, 0)
+	switch id {
+	case "1":
+		return &schemas.User{Model: gorm.Model{ID: 1}, Kind: schemas.UserKindIndividual, BirthDate: &adult}, nil
+	case "2":
+		return &schemas.User{Model: gorm.Model{ID: 2}, Kind: schemas.UserKindCompany, LegalName: "Test Ltda", TradeName: "Test"}, nil
+	case "3":
+		return &schemas.User{Model: gorm.Model{ID: 3}, Kind: schemas.UserKindIndividual, BirthDate: &minor}, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func (m *mockUserRepository) ListUsers(ctx context.Context) (*[]schemas.User, error) {
+	return &[]schemas.User{}, nil
+}
+
+func (m *mockUserRepository) Create(ctx context.Context, user *schemas.User) (schemas.User, error) {
+	return *user, nil
+}
+
+func (m *mockUserRepository) FindByEmail(ctx context.Context, email string) (*schemas.User, error) {
+	return nil, errors.New("user not found")
+}
+
+func (m *mockUserRepository) FindWithAccounts(ctx context.Context, id string) (*schemas.User, error) {
+	return m.FindById(ctx, id)
+}
+
+func (m *mockUserRepository) Update(ctx context.Context, user *schemas.User) error {
+	return nil
+}
+
+func (m *mockUserRepository) Delete(ctx context.Context, user *schemas.User) error {
+	return nil
+}
@@ -1,26 +1,61 @@
 package account
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/export"
+	"github.com/jamadeu/accounts/money"
 	"github.com/jamadeu/accounts/schemas"
 	"github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/tracing"
 )
 
+var errUserNotFound = errors.New("user not found")
+
+// rejection is an account opening refused by the account policy.
+type rejection struct {
+	error
+}
+
 type AccountHandler struct {
-	accountRepo    schemas.AccountRepository
-	userRepository schemas.UserRepository
+	accountRepo  schemas.AccountRepository
+	transactions schemas.TransactionManager
+	deadlines    services.Deadlines
 }
 
-func NewAccountHandler(ar schemas.AccountRepository, ur schemas.UserRepository) *AccountHandler {
-	return &AccountHandler{accountRepo: ar, userRepository: ur}
+func NewAccountHandler(ar schemas.AccountRepository, tm schemas.TransactionManager) *AccountHandler {
+	return &AccountHandler{accountRepo: ar, transactions: tm, deadlines: services.DefaultDeadlines}
 }
 
 func (ah *AccountHandler) RegisterRoutes(router *gin.Engine, basePath string) {
+	read := services.Deadline(ah.deadlines.Read)
+	write := services.Deadline(ah.deadlines.Write)
+	download := services.Deadline(ah.deadlines.Export)
+	view := services.Require(services.PermAccountsRead)
+	operate := services.Require(services.PermAccountsWrite)
+	exports := services.Require(services.PermStatementsExport)
 	v1 := router.Group(basePath)
 	{
-		v1.POST("/v1/account", ah.handleCreateAccount)
+		v1.POST("/v1/account", operate, write, ah.handleCreateAccount)
+		v1.POST("/v1/account/transfer", services.Require(services.PermTransfersWrite), write, ah.handleTransfer)
+		v1.POST("/v1/account/:id/deposit", services.Require(services.PermDepositsWrite), write, ah.handleDeposit)
+		v1.POST("/v1/account/:id/withdraw", operate, write, ah.handleWithdraw)
+		v1.GET("/v1/account/:id", view, read, ah.handleFindAccount)
+		v1.GET("/v1/account/:id/holders", view, read, ah.handleListHolders)
+		v1.POST("/v1/account/:id/holders", operate, write, ah.handleAddHolder)
+		v1.DELETE("/v1/account/:id/holders/:userId", operate, write, ah.handleRemoveHolder)
+		v1.GET("/v1/account/:id/balance", view, read, ah.handleBalance)
+		v1.GET("/v1/account/:id/statement", view, read, ah.handleStatement)
+		v1.GET("/v1/account/:id/statement.csv", exports, download, ah.handleStatementExport(export.FormatCSV))
+		v1.GET("/v1/account/:id/statement.ofx", exports, download, ah.handleStatementExport(export.FormatOFX))
+		v1.GET("/v1/account/:id/statement.xml", exports, download, ah.handleStatementExport(export.FormatCamt053))
 	}
 }
 
@@ -31,19 +66,208 @@ func (ah *AccountHandler) handleCreateAccount(ctx *gin.Context) {
 		services.SendError(ctx, http.StatusBadRequest, err.Error())
 		return
 	}
-	user, err := ah.userRepository.FindById(request.UserId)
+	tracing.SetAttributes(ctx.Request.Context(), tracing.UserIDString(request.UserId))
+	if caller, _ := services.Caller(ctx.Request.Context()); request.UserId != strconv.FormatUint(uint64(caller), 10) {
+		services.SendError(ctx, http.StatusForbidden, "accounts may only be opened for the caller")
+		return
+	}
+	if !request.Balance.IsZero() && !services.Can(ctx.Request.Context(), services.PermDepositsWrite) {
+		services.SendError(ctx, http.StatusForbidden, "opening balances are cash deposits, which only staff may post")
+		return
+	}
+	account := schemas.Account{}
+	err := ah.transactions.WithinTransaction(ctx.Request.Context(), func(c context.Context, repos schemas.Repositories) error {
+		user, err := repos.Users.FindById(c, request.UserId)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errUserNotFound, err)
+		}
+		policy := policyFor(user.Kind)
+		accountType, err := policy.accountType(user.Kind, request.Type)
+		if err != nil {
+			return rejection{err}
+		}
+		if err := policy.checkHolder(user, time.Now()); err != nil {
+			return rejection{err}
+		}
+		currency := request.Currency
+		if currency == "" {
+			currency = money.DefaultCurrency
+		}
+		account = schemas.Account{
+			Type:            accountType,
+			Balance:         money.New(request.Balance.Amount, currency),
+			WithdrawalLimit: policy.limit(currency),
+			Currency:        currency,
+			HolderID:        user.ID,
+			Holder:          *user,
+		}
+		return repos.Accounts.CreateAccount(c, &account)
+	})
+	if err != nil {
+		var rejected rejection
+		switch {
+		case services.SendContextError(ctx, err):
+		case errors.Is(err, errUserNotFound):
+			services.SendError(ctx, http.StatusBadRequest, "user not found")
+		case errors.As(err, &rejected):
+			services.SendError(ctx, http.StatusUnprocessableEntity, rejected.Error())
+		default:
+			slog.ErrorContext(ctx.Request.Context(), "creating account on database", "error", err)
+			services.SendError(ctx, http.StatusInternalServerError, "creating account on database")
+		}
+		return
+	}
+	services.SendSuccess(ctx, "create-account", schemas.NewAccountResponseWithHolder(account))
+}
+
+func (ah *AccountHandler) handleFindAccount(ctx *gin.Context) {
+	id, err := accountIdParam(ctx)
+	if err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ah.authorize(ctx, id, permView) {
+		return
+	}
+	account, err := ah.accountRepo.FindById(ctx.Request.Context(), id)
+	if err != nil {
+		if services.SendContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, schemas.ErrAccountNotFound) {
+			services.SendError(ctx, http.StatusNotFound, fmt.Sprintf("account with id: %d not found", id))
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "error finding account", "error", err)
+		services.SendError(ctx, http.StatusInternalServerError, "error finding account")
+		return
+	}
+	services.SendSuccess(ctx, "find-account", schemas.NewAccountResponseWithHolder(*account))
+}
+
+// handleDeposit posts cash into any account. The route is restricted to
+// staff, who need not hold the account.
+func (ah *AccountHandler) handleDeposit(ctx *gin.Context) {
+	ah.handleTransaction(ctx, "deposit", "", ah.accountRepo.Deposit)
+}
+
+func (ah *AccountHandler) handleWithdraw(ctx *gin.Context) {
+	ah.handleTransaction(ctx, "withdraw", permTransact, ah.accountRepo.Withdraw)
+}
+
+// handleTransaction posts a movement on the account. Callers must hold a
+// role granting required, unless it is empty.
+func (ah *AccountHandler) handleTransaction(ctx *gin.Context, op string, required permission, post func(context.Context, uint, money.Money) (*schemas.Transaction, error)) {
+	id, err := accountIdParam(ctx)
+	if err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	request := TransactionRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if required != "" && !ah.authorize(ctx, id, required) {
+		return
+	}
+	transaction, err := post(ctx.Request.Context(), id, request.amount())
+	if err != nil {
+		switch {
+		case services.SendContextError(ctx, err):
+		case errors.Is(err, schemas.ErrAccountNotFound):
+			services.SendError(ctx, http.StatusNotFound, fmt.Sprintf("account with id: %d not found", id))
+		case errors.Is(err, schemas.ErrInsufficientFunds), errors.Is(err, schemas.ErrWithdrawalLimit),
+			errors.Is(err, money.ErrCurrencyMismatch):
+			services.SendError(ctx, http.StatusUnprocessableEntity, err.Error())
+		default:
+			slog.ErrorContext(ctx.Request.Context(), "error processing transaction", "op", op, "error", err)
+			services.SendError(ctx, http.StatusInternalServerError, fmt.Sprintf("error processing %s", op))
+		}
+		return
+	}
+	services.SendSuccess(ctx, op, transaction)
+}
+
+func (ah *AccountHandler) handleTransfer(ctx *gin.Context) {
+	request := TransferRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	tracing.SetAttributes(ctx.Request.Context(),
+		tracing.FromAccountIDKey.Int64(int64(request.FromAccountId)),
+		tracing.ToAccountIDKey.Int64(int64(request.ToAccountId)))
+	if !ah.authorize(ctx, request.FromAccountId, permTransact) {
+		return
+	}
+	amount := request.amount()
+	threshold := money.MustParse(stepUpTransferThreshold, amount.CurrencyCode())
+	if amount.Cmp(threshold) > 0 && !services.FreshSecondFactor(ctx) {
+		return
+	}
+	transfer, err := ah.accountRepo.Transfer(ctx.Request.Context(), request.FromAccountId, request.ToAccountId, amount)
+	if err != nil {
+		switch {
+		case services.SendContextError(ctx, err):
+		case errors.Is(err, schemas.ErrAccountNotFound):
+			services.SendError(ctx, http.StatusNotFound, err.Error())
+		case errors.Is(err, schemas.ErrInsufficientFunds), errors.Is(err, schemas.ErrSameAccount),
+			errors.Is(err, schemas.ErrWithdrawalLimit), errors.Is(err, money.ErrCurrencyMismatch):
+			services.SendError(ctx, http.StatusUnprocessableEntity, err.Error())
+		default:
+			slog.ErrorContext(ctx.Request.Context(), "error processing transfer", "error", err)
+			services.SendError(ctx, http.StatusInternalServerError, "error processing transfer")
+		}
+		return
+	}
+	services.SendSuccess(ctx, "transfer", transfer)
+}
+
+func (ah *AccountHandler) handleBalance(ctx *gin.Context) {
+	id, err := accountIdParam(ctx)
 	if err != nil {
-		services.SendError(ctx, http.StatusBadRequest, "user not found")
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
 		return
 	}
-	account := schemas.Account{
-		Balance:      request.Balance,
-		User:         *user,
-		Transactions: []schemas.Transaction{},
+	at := time.Now()
+	if param := ctx.Query("at"); param != "" {
+		if at, err = time.Parse(time.RFC3339, param); err != nil {
+			services.SendError(ctx, http.StatusBadRequest, "param: at (type: RFC3339 timestamp) is invalid")
+			return
+		}
+	}
+	if !ah.authorize(ctx, id, permView) {
+		return
 	}
-	if err := ah.accountRepo.CreateAccount(account); err != nil {
-		services.SendError(ctx, http.StatusInternalServerError, "creating account on database")
+	balance, err := ah.accountRepo.BalanceAt(ctx.Request.Context(), id, at)
+	if err != nil {
+		if services.SendContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, schemas.ErrAccountNotFound) {
+			services.SendError(ctx, http.StatusNotFound, fmt.Sprintf("account with id: %d not found", id))
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "error computing balance", "error", err)
+		services.SendError(ctx, http.StatusInternalServerError, "error computing balance")
 		return
 	}
-	services.SendSuccess(ctx, "create-account", account)
+	services.SendSuccess(ctx, "balance", schemas.BalanceResponse{
+		AccountID: id,
+		Balance:   balance,
+		Currency:  balance.CurrencyCode(),
+		At:        at,
+	})
+}
+
+func accountIdParam(ctx *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("param: id (type: pathParameter) must be a positive integer")
+	}
+	tracing.SetAttributes(ctx.Request.Context(), tracing.AccountID(uint(id)))
+	return uint(id), nil
 }
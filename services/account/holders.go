@@ -0,0 +1,172 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+)
+
+// permission is something a holder may do on an account.
+type permission string
+
+const (
+	permView     permission = "view"
+	permTransact permission = "transact"
+	permManage   permission = "manage holders of"
+)
+
+var rolePermissions = map[string][]permission{
+	schemas.HolderRolePrimary:          {permView, permTransact, permManage},
+	schemas.HolderRoleJoint:            {permView, permTransact},
+	schemas.HolderRoleAuthorizedSigner: {permView, permTransact},
+	schemas.HolderRoleViewer:           {permView},
+}
+
+func allowed(role string, p permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks that the caller holds a role on the account granting p.
+// Staff whose role may read any account are allowed to view it without
+// holding it. Otherwise it writes the error response and returns false.
+func (ah *AccountHandler) authorize(ctx *gin.Context, id uint, p permission) bool {
+	caller, ok := services.Caller(ctx.Request.Context())
+	if !ok {
+		services.SendError(ctx, http.StatusUnauthorized, "authentication required")
+		return false
+	}
+	if p == permView && services.Can(ctx.Request.Context(), services.PermAccountsReadAny) {
+		return true
+	}
+	role, err := ah.accountRepo.HolderRole(ctx.Request.Context(), id, caller)
+	switch {
+	case err == nil:
+	case services.SendContextError(ctx, err):
+		return false
+	case errors.Is(err, schemas.ErrHolderNotFound):
+		services.SendError(ctx, http.StatusForbidden, fmt.Sprintf("user %d is not a holder of account %d", caller, id))
+		return false
+	default:
+		slog.ErrorContext(ctx.Request.Context(), "error checking account holder", "error", err)
+		services.SendError(ctx, http.StatusInternalServerError, "error checking account holder")
+		return false
+	}
+	if !allowed(role, p) {
+		services.SendError(ctx, http.StatusForbidden, fmt.Sprintf("%s holders may not %s account %d", role, p, id))
+		return false
+	}
+	return true
+}
+
+func (ah *AccountHandler) handleListHolders(ctx *gin.Context) {
+	id, err := accountIdParam(ctx)
+	if err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ah.authorize(ctx, id, permView) {
+		return
+	}
+	holders, err := ah.accountRepo.Holders(ctx.Request.Context(), id)
+	if err != nil {
+		if services.SendContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, schemas.ErrAccountNotFound) {
+			services.SendError(ctx, http.StatusNotFound, fmt.Sprintf("account with id: %d not found", id))
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "error listing account holders", "error", err)
+		services.SendError(ctx, http.StatusInternalServerError, "error listing account holders")
+		return
+	}
+	response := make([]schemas.AccountHolderResponse, 0, len(holders))
+	for _, h := range holders {
+		response = append(response, schemas.NewAccountHolderResponse(h))
+	}
+	services.SendSuccess(ctx, "list-holders", response)
+}
+
+func (ah *AccountHandler) handleAddHolder(ctx *gin.Context) {
+	id, err := accountIdParam(ctx)
+	if err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	request := AddHolderRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ah.authorize(ctx, id, permManage) {
+		return
+	}
+	holder := schemas.AccountHolder{AccountID: id, Role: request.Role}
+	err = ah.transactions.WithinTransaction(ctx.Request.Context(), func(c context.Context, repos schemas.Repositories) error {
+		user, err := repos.Users.FindById(c, request.UserId)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errUserNotFound, err)
+		}
+		holder.UserID = user.ID
+		return repos.Accounts.AddHolder(c, &holder)
+	})
+	if err != nil {
+		switch {
+		case services.SendContextError(ctx, err):
+		case errors.Is(err, errUserNotFound):
+			services.SendError(ctx, http.StatusBadRequest, "user not found")
+		case errors.Is(err, schemas.ErrAccountNotFound):
+			services.SendError(ctx, http.StatusNotFound, fmt.Sprintf("account with id: %d not found", id))
+		case errors.Is(err, schemas.ErrHolderExists):
+			services.SendError(ctx, http.StatusConflict, err.Error())
+		default:
+			slog.ErrorContext(ctx.Request.Context(), "error adding account holder", "error", err)
+			services.SendError(ctx, http.StatusInternalServerError, "error adding account holder")
+		}
+		return
+	}
+	services.SendSuccess(ctx, "add-holder", schemas.NewAccountHolderResponse(holder))
+}
+
+func (ah *AccountHandler) handleRemoveHolder(ctx *gin.Context) {
+	id, err := accountIdParam(ctx)
+	if err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	userId, err := strconv.ParseUint(ctx.Param("userId"), 10, 64)
+	if err != nil || userId == 0 {
+		services.SendError(ctx, http.StatusBadRequest, "param: userId (type: pathParameter) must be a positive integer")
+		return
+	}
+	if !ah.authorize(ctx, id, permManage) {
+		return
+	}
+	if err := ah.accountRepo.RemoveHolder(ctx.Request.Context(), id, uint(userId)); err != nil {
+		switch {
+		case services.SendContextError(ctx, err):
+		case errors.Is(err, schemas.ErrAccountNotFound), errors.Is(err, schemas.ErrHolderNotFound):
+			services.SendError(ctx, http.StatusNotFound, err.Error())
+		case errors.Is(err, schemas.ErrLastPrimaryHolder):
+			services.SendError(ctx, http.StatusConflict, err.Error())
+		default:
+			slog.ErrorContext(ctx.Request.Context(), "error removing account holder", "error", err)
+			services.SendError(ctx, http.StatusInternalServerError, "error removing account holder")
+		}
+		return
+	}
+	services.SendSuccess(ctx, "remove-holder", fmt.Sprintf("user: %d removed from account: %d", userId, id))
+}
@@ -0,0 +1,68 @@
+package account
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
+)
+
+// accountPolicy holds the account opening rules for one customer kind. The
+// first allowed type is used when the request does not name one.
+type accountPolicy struct {
+	types           []string
+	withdrawalLimit string
+	minimumAge      int
+}
+
+var policies = map[string]accountPolicy{
+	schemas.UserKindIndividual: {
+		types:           []string{schemas.AccountTypeChecking, schemas.AccountTypeSavings},
+		withdrawalLimit: "5000.00",
+		minimumAge:      18,
+	},
+	schemas.UserKindCompany: {
+		types:           []string{schemas.AccountTypeBusiness, schemas.AccountTypePayment},
+		withdrawalLimit: "50000.00",
+	},
+}
+
+// stepUpTransferThreshold is the largest transfer, in the currency of the
+// transfer, allowed without a fresh second factor.
+const stepUpTransferThreshold = "1000.00"
+
+func policyFor(kind string) accountPolicy {
+	if p, ok := policies[kind]; ok {
+		return p
+	}
+	return policies[schemas.UserKindIndividual]
+}
+
+// accountType resolves the requested account type for a customer kind.
+func (p accountPolicy) accountType(kind, requested string) (string, error) {
+	if requested == "" {
+		return p.types[0], nil
+	}
+	for _, t := range p.types {
+		if t == requested {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("account type %s is not available for %s customers", requested, kind)
+}
+
+// checkHolder enforces the minimum age of individual account holders.
+func (p accountPolicy) checkHolder(user *schemas.User, now time.Time) error {
+	if p.minimumAge == 0 {
+		return nil
+	}
+	if user.BirthDate == nil || user.BirthDate.AddDate(p.minimumAge, 0, 0).After(now) {
+		return fmt.Errorf("account holder must be at least %d years old", p.minimumAge)
+	}
+	return nil
+}
+
+func (p accountPolicy) limit(currency string) money.Money {
+	return money.MustParse(p.withdrawalLimit, currency)
+}
@@ -1,19 +1,338 @@
 package account
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jamadeu/accounts/ledger"
+	"github.com/jamadeu/accounts/metrics"
+	"github.com/jamadeu/accounts/money"
 	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services/uow"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AccountRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	ledger *ledger.Ledger
 }
 
 func NewAccountRepository(db *gorm.DB) *AccountRepository {
-	return &AccountRepository{db: db}
+	return &AccountRepository{db: db, ledger: ledger.New(db)}
+}
+
+// CreateAccount stores the account with a zero balance and posts any
+// opening balance through the ledger, so the cached balance is always backed
+// by postings. Associations are not saved; the holder must already exist.
+func (r *AccountRepository) CreateAccount(ctx context.Context, account *schemas.Account) error {
+	opening := account.Balance
+	account.Balance = money.Zero(account.Currency)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(account).Error; err != nil {
+			return err
+		}
+		holder := schemas.AccountHolder{AccountID: account.ID, UserID: account.HolderID, Role: schemas.HolderRolePrimary}
+		if err := tx.Create(&holder).Error; err != nil {
+			return err
+		}
+		if !opening.IsPositive() {
+			return nil
+		}
+		if _, err := record(tx, account, opening, schemas.TransactionTypeDeposit, schemas.DirectionCredit, ""); err != nil {
+			return err
+		}
+		return r.ledger.Post(tx, ledger.Deposit(account.ID, opening, "opening balance"))
+	})
+	if err != nil {
+		return err
+	}
+	uow.AfterCommit(ctx, func() {
+		metrics.AccountCreated(account.Type)
+		if opening.IsPositive() {
+			metrics.TransactionPosted(schemas.TransactionTypeDeposit, opening)
+		}
+	})
+	return nil
+}
+
+func (r *AccountRepository) FindById(ctx context.Context, id uint) (*schemas.Account, error) {
+	account := schemas.Account{}
+	if err := r.db.WithContext(ctx).Joins("Holder").First(&account, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, schemas.ErrAccountNotFound
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (r *AccountRepository) Deposit(ctx context.Context, id uint, amount money.Money) (*schemas.Transaction, error) {
+	return r.post(ctx, id, amount, schemas.TransactionTypeDeposit, schemas.DirectionCredit)
+}
+
+func (r *AccountRepository) Withdraw(ctx context.Context, id uint, amount money.Money) (*schemas.Transaction, error) {
+	return r.post(ctx, id, amount, schemas.TransactionTypeWithdrawal, schemas.DirectionDebit)
+}
+
+// BalanceAt returns the balance of the account derived from the ledger as of
+// the given instant.
+func (r *AccountRepository) BalanceAt(ctx context.Context, id uint, at time.Time) (money.Money, error) {
+	return r.ledger.BalanceAt(ctx, id, at)
+}
+
+func (r *AccountRepository) ListTransactions(ctx context.Context, id uint, from, to time.Time, after *schemas.StatementCursor, limit int) ([]schemas.Transaction, error) {
+	transactions := []schemas.Transaction{}
+	query := r.db.WithContext(ctx).Where("account_id = ? AND created_at >= ? AND created_at < ?", id, from, to)
+	if after != nil {
+		query = query.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID)
+	}
+	err := query.Order("created_at, id").Limit(limit).Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// BalanceBefore is derived from the ledger, like BalanceAt, so statements
+// agree with the postings of accounts whose history predates transactions.
+func (r *AccountRepository) BalanceBefore(ctx context.Context, id uint, at time.Time) (money.Money, error) {
+	return r.ledger.BalanceBefore(ctx, id, at)
+}
+
+func (r *AccountRepository) Holders(ctx context.Context, id uint) ([]schemas.AccountHolder, error) {
+	if _, err := r.FindById(ctx, id); err != nil {
+		return nil, err
+	}
+	holders := []schemas.AccountHolder{}
+	if err := r.db.WithContext(ctx).Where("account_id = ?", id).Order("user_id").Find(&holders).Error; err != nil {
+		return nil, err
+	}
+	return holders, nil
+}
+
+func (r *AccountRepository) HolderRole(ctx context.Context, id, userID uint) (string, error) {
+	holder := schemas.AccountHolder{}
+	err := r.db.WithContext(ctx).
+		Joins("JOIN accounts ON accounts.id = account_holders.account_id AND accounts.deleted_at IS NULL").
+		Where("account_holders.account_id = ? AND account_holders.user_id = ?", id, userID).
+		Limit(1).
+		Find(&holder).Error
+	if err != nil {
+		return "", err
+	}
+	if holder.Role == "" {
+		return "", schemas.ErrHolderNotFound
+	}
+	return holder.Role, nil
+}
+
+// AddHolder locks the account row so it serializes with RemoveHolder.
+func (r *AccountRepository) AddHolder(ctx context.Context, holder *schemas.AccountHolder) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := lockAccounts(tx, holder.AccountID); err != nil {
+			return err
+		}
+		var existing int64
+		err := tx.Model(&schemas.AccountHolder{}).
+			Where("account_id = ? AND user_id = ?", holder.AccountID, holder.UserID).
+			Count(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing > 0 {
+			return schemas.ErrHolderExists
+		}
+		return tx.Create(holder).Error
+	})
+}
+
+// RemoveHolder locks the account row, so concurrent removals cannot both see
+// another primary holder left and remove the last two.
+func (r *AccountRepository) RemoveHolder(ctx context.Context, id, userID uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		accounts, err := lockAccounts(tx, id)
+		if err != nil {
+			return err
+		}
+		holders := []schemas.AccountHolder{}
+		if err := tx.Where("account_id = ?", id).Order("user_id").Find(&holders).Error; err != nil {
+			return err
+		}
+		var removed *schemas.AccountHolder
+		var successor uint
+		for i, h := range holders {
+			switch {
+			case h.UserID == userID:
+				removed = &holders[i]
+			case h.Role == schemas.HolderRolePrimary && successor == 0:
+				successor = h.UserID
+			}
+		}
+		if removed == nil {
+			return schemas.ErrHolderNotFound
+		}
+		if removed.Role == schemas.HolderRolePrimary && successor == 0 {
+			return schemas.ErrLastPrimaryHolder
+		}
+		if err := tx.Where("account_id = ? AND user_id = ?", id, userID).Delete(&schemas.AccountHolder{}).Error; err != nil {
+			return err
+		}
+		if accounts[id].HolderID != userID {
+			return nil
+		}
+		return tx.Model(accounts[id]).Update("holder_id", successor).Error
+	})
+}
+
+// post locks the account row, records the resulting transaction and posts
+// the matching journal entry, all inside a single DB transaction.
+func (r *AccountRepository) post(ctx context.Context, id uint, amount money.Money, typ, direction string) (*schemas.Transaction, error) {
+	transaction := schemas.Transaction{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		accounts, err := lockAccounts(tx, id)
+		if err != nil {
+			return err
+		}
+		account := accounts[id]
+		if err := sameCurrency(account, amount); err != nil {
+			return err
+		}
+		transaction, err = record(tx, account, amount, typ, direction, "")
+		if err != nil {
+			return err
+		}
+		entry := ledger.Deposit(account.ID, amount, typ)
+		if direction == schemas.DirectionDebit {
+			entry = ledger.Withdrawal(account.ID, amount, typ)
+		}
+		return r.ledger.Post(tx, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	uow.AfterCommit(ctx, func() { metrics.TransactionPosted(typ, transaction.Amount) })
+	return &transaction, nil
+}
+
+// Transfer debits fromId and credits toId in a single DB transaction. Both
+// rows are locked in ID order so concurrent transfers between the same pair
+// of accounts, in either direction, cannot deadlock.
+func (r *AccountRepository) Transfer(ctx context.Context, fromId, toId uint, amount money.Money) (*schemas.Transfer, error) {
+	if fromId == toId {
+		return nil, schemas.ErrSameAccount
+	}
+	transferID, err := newTransferID()
+	if err != nil {
+		return nil, err
+	}
+	transfer := schemas.Transfer{ID: transferID}
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		accounts, err := lockAccounts(tx, fromId, toId)
+		if err != nil {
+			return err
+		}
+		if accounts[fromId].Currency != accounts[toId].Currency {
+			return fmt.Errorf("%w: accounts %d and %d hold %s and %s", money.ErrCurrencyMismatch,
+				fromId, toId, accounts[fromId].Currency, accounts[toId].Currency)
+		}
+		if err := sameCurrency(accounts[fromId], amount); err != nil {
+			return err
+		}
+		transfer.Debit, err = record(tx, accounts[fromId], amount, schemas.TransactionTypeTransfer, schemas.DirectionDebit, transferID)
+		if err != nil {
+			return err
+		}
+		transfer.Credit, err = record(tx, accounts[toId], amount, schemas.TransactionTypeTransfer, schemas.DirectionCredit, transferID)
+		if err != nil {
+			return err
+		}
+		return r.ledger.Post(tx, ledger.Transfer(fromId, toId, amount, transferID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	uow.AfterCommit(ctx, func() { metrics.TransactionPosted(schemas.TransactionTypeTransfer, amount) })
+	return &transfer, nil
+}
+
+// sameCurrency rejects amounts in a currency other than the account's,
+// rather than converting them.
+func sameCurrency(account *schemas.Account, amount money.Money) error {
+	if amount.CurrencyCode() != account.Currency {
+		return fmt.Errorf("%w: account %d holds %s, not %s", money.ErrCurrencyMismatch,
+			account.ID, account.Currency, amount.CurrencyCode())
+	}
+	return nil
+}
+
+// lockAccounts selects the given accounts FOR UPDATE, ordered by ID.
+func lockAccounts(tx *gorm.DB, ids ...uint) (map[uint]*schemas.Account, error) {
+	accounts := []schemas.Account{}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Order("id").
+		Find(&accounts, ids).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) != len(ids) {
+		return nil, schemas.ErrAccountNotFound
+	}
+	locked := make(map[uint]*schemas.Account, len(accounts))
+	for i := range accounts {
+		locked[accounts[i].ID] = &accounts[i]
+	}
+	return locked, nil
+}
+
+// record computes the balance an already locked account will have after the
+// movement, rejecting it when the balance would go negative or a debit
+// exceeds the account withdrawal limit, and stores the
+// customer-facing transaction row. The balance column itself is updated by
+// the ledger when the matching journal entry is posted.
+func record(tx *gorm.DB, account *schemas.Account, amount money.Money, typ, direction, transferID string) (schemas.Transaction, error) {
+	balance, err := account.Balance.Add(amount)
+	if direction == schemas.DirectionDebit {
+		balance, err = account.Balance.Sub(amount)
+	}
+	if err != nil {
+		return schemas.Transaction{}, err
+	}
+	// The limit shares the currency of the balance, which Sub checked
+	if direction == schemas.DirectionDebit && account.WithdrawalLimit.IsPositive() && amount.Cmp(account.WithdrawalLimit) > 0 {
+		return schemas.Transaction{}, schemas.ErrWithdrawalLimit
+	}
+	if balance.IsNegative() {
+		return schemas.Transaction{}, schemas.ErrInsufficientFunds
+	}
+	account.Balance = balance
+
+	transaction := schemas.Transaction{
+		Type:         typ,
+		Direction:    direction,
+		Amount:       amount,
+		BalanceAfter: balance,
+		Currency:     account.Currency,
+		AccountID:    account.ID,
+		TransferID:   transferID,
+	}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return schemas.Transaction{}, err
+	}
+	return transaction, nil
 }
 
-func (r *AccountRepository) CreateAccount(account schemas.Account) error {
-	return r.db.Create(&account).Error
+// newTransferID returns a random RFC 4122 version 4 UUID.
+func newTransferID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
 }
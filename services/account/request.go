@@ -2,11 +2,19 @@ package account
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
 )
 
 type CreateAccountRequest struct {
-	Balance float64 `json:"accountBalance"`
-	UserId  string  `json:"userId"`
+	Balance  money.Money `json:"accountBalance"`
+	Currency string      `json:"currency"`
+	Type     string      `json:"type"`
+	UserId   string      `json:"userId"`
 }
 
 func errParamIsRequired(name, typ string) error {
@@ -14,14 +22,94 @@ func errParamIsRequired(name, typ string) error {
 }
 
 func (r *CreateAccountRequest) Validate() error {
-	if r.Balance < 0 && r.UserId == "" {
+	if r.Balance.IsZero() && r.UserId == "" && r.Currency == "" && r.Type == "" {
 		return fmt.Errorf("reqest body is empty or malformed")
 	}
-	if r.Balance < 0 {
-		return errParamIsRequired("balance", "float64")
+	if r.Balance.IsNegative() {
+		return fmt.Errorf("param: accountBalance (type: decimal) must not be negative")
 	}
 	if r.UserId == "" {
 		return errParamIsRequired("UserId", "uint")
 	}
+	return validateCurrency(r.Currency)
+}
+
+// validateCurrency accepts an empty currency, which means DefaultCurrency,
+// or one of the supported ones.
+func validateCurrency(currency string) error {
+	if currency != "" && !money.Supported(currency) {
+		return fmt.Errorf("param: currency must be one of %s", strings.Join(money.Currencies, ", "))
+	}
+	return nil
+}
+
+type TransactionRequest struct {
+	Amount   money.Money `json:"amount"`
+	Currency string      `json:"currency"`
+}
+
+func (r *TransactionRequest) Validate() error {
+	if !r.Amount.IsPositive() {
+		return fmt.Errorf("param: amount (type: decimal) must be greater than zero")
+	}
+	return validateCurrency(r.Currency)
+}
+
+// amount returns the amount in the requested currency, DefaultCurrency when
+// none was given.
+func (r *TransactionRequest) amount() money.Money {
+	return money.New(r.Amount.Amount, r.Currency)
+}
+
+type TransferRequest struct {
+	FromAccountId uint        `json:"fromAccountId"`
+	ToAccountId   uint        `json:"toAccountId"`
+	Amount        money.Money `json:"amount"`
+	Currency      string      `json:"currency"`
+}
+
+func (r *TransferRequest) Validate() error {
+	if r.FromAccountId == 0 && r.ToAccountId == 0 && r.Amount.IsZero() {
+		return fmt.Errorf("reqest body is empty or malformed")
+	}
+	if r.FromAccountId == 0 {
+		return errParamIsRequired("fromAccountId", "uint")
+	}
+	if r.ToAccountId == 0 {
+		return errParamIsRequired("toAccountId", "uint")
+	}
+	if r.FromAccountId == r.ToAccountId {
+		return fmt.Errorf("param: toAccountId must differ from fromAccountId")
+	}
+	if !r.Amount.IsPositive() {
+		return fmt.Errorf("param: amount (type: decimal) must be greater than zero")
+	}
+	return validateCurrency(r.Currency)
+}
+
+// amount returns the amount in the requested currency, DefaultCurrency when
+// none was given.
+func (r *TransferRequest) amount() money.Money {
+	return money.New(r.Amount.Amount, r.Currency)
+}
+
+type AddHolderRequest struct {
+	UserId string `json:"userId"`
+	Role   string `json:"role"`
+}
+
+func (r *AddHolderRequest) Validate() error {
+	if r.UserId == "" && r.Role == "" {
+		return fmt.Errorf("reqest body is empty or malformed")
+	}
+	if r.UserId == "" {
+		return errParamIsRequired("userId", "uint")
+	}
+	if id, err := strconv.ParseUint(r.UserId, 10, 64); err != nil || id == 0 {
+		return fmt.Errorf("param: userId (type: uint) must be a positive integer")
+	}
+	if !slices.Contains(schemas.HolderRoles, r.Role) {
+		return fmt.Errorf("param: role must be one of %s", strings.Join(schemas.HolderRoles, ", "))
+	}
 	return nil
 }
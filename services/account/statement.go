@@ -0,0 +1,247 @@
+package account
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/export"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+)
+
+const (
+	defaultStatementLimit = 50
+	maxStatementLimit     = 500
+
+	dateLayout = "2006-01-02"
+)
+
+// statementRange is the half-open interval [From, To) covered by a
+// statement.
+type statementRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// parseStatementRange reads the from and to query parameters. Both accept an
+// RFC 3339 timestamp or a plain date; a plain to date includes the whole day.
+// A missing from starts at the beginning of the account history and a
+// missing to ends now.
+func parseStatementRange(ctx *gin.Context) (statementRange, error) {
+	r := statementRange{From: time.Unix(0, 0).UTC(), To: time.Now()}
+	if param := ctx.Query("from"); param != "" {
+		from, _, err := parseStatementTime(param)
+		if err != nil {
+			return r, fmt.Errorf("param: from (type: date or RFC3339 timestamp) is invalid")
+		}
+		r.From = from
+	}
+	if param := ctx.Query("to"); param != "" {
+		to, isDate, err := parseStatementTime(param)
+		if err != nil {
+			return r, fmt.Errorf("param: to (type: date or RFC3339 timestamp) is invalid")
+		}
+		if isDate {
+			to = to.AddDate(0, 0, 1)
+		}
+		r.To = to
+	}
+	if !r.From.Before(r.To) {
+		return r, fmt.Errorf("param: from must be before to")
+	}
+	return r, nil
+}
+
+func parseStatementTime(s string) (time.Time, bool, error) {
+	if t, err := time.Parse(dateLayout, s); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, false, err
+}
+
+func encodeCursor(c schemas.StatementCursor) string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*schemas.StatementCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	nanos, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	i, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &schemas.StatementCursor{CreatedAt: time.Unix(0, n).UTC(), ID: uint(i)}, nil
+}
+
+func statementLimit(ctx *gin.Context) (int, error) {
+	param := ctx.Query("limit")
+	if param == "" {
+		return defaultStatementLimit, nil
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit < 1 || limit > maxStatementLimit {
+		return 0, fmt.Errorf("param: limit must be between 1 and %d", maxStatementLimit)
+	}
+	return limit, nil
+}
+
+// buildStatement loads one page of transactions together with the opening
+// and closing balances of the whole range. A limit of zero loads every line
+// in the range.
+func (ah *AccountHandler) buildStatement(ctx context.Context, id uint, r statementRange, after *schemas.StatementCursor, limit int) (*schemas.Statement, error) {
+	account, err := ah.accountRepo.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	opening, err := ah.accountRepo.BalanceBefore(ctx, id, r.From)
+	if err != nil {
+		return nil, err
+	}
+	closing, err := ah.accountRepo.BalanceBefore(ctx, id, r.To)
+	if err != nil {
+		return nil, err
+	}
+
+	fetch := limit + 1
+	if limit == 0 {
+		fetch = -1
+	}
+	transactions, err := ah.accountRepo.ListTransactions(ctx, id, r.From, r.To, after, fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &schemas.Statement{
+		AccountID:      account.ID,
+		Currency:       account.Currency,
+		From:           r.From,
+		To:             r.To,
+		OpeningBalance: opening,
+		ClosingBalance: closing,
+		Lines:          []schemas.StatementLine{},
+	}
+	if limit > 0 && len(transactions) > limit {
+		transactions = transactions[:limit]
+		last := transactions[limit-1]
+		statement.NextCursor = encodeCursor(schemas.StatementCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	for _, t := range transactions {
+		statement.Lines = append(statement.Lines, schemas.StatementLine{
+			TransactionID:  t.ID,
+			CreatedAt:      t.CreatedAt,
+			Type:           t.Type,
+			Direction:      t.Direction,
+			Amount:         t.Amount,
+			RunningBalance: t.BalanceAfter,
+			TransferID:     t.TransferID,
+		})
+	}
+	return statement, nil
+}
+
+func (ah *AccountHandler) handleStatement(ctx *gin.Context) {
+	id, err := accountIdParam(ctx)
+	if err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	r, err := parseStatementRange(ctx)
+	if err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, err := statementLimit(ctx)
+	if err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	var after *schemas.StatementCursor
+	if param := ctx.Query("cursor"); param != "" {
+		if after, err = decodeCursor(param); err != nil {
+			services.SendError(ctx, http.StatusBadRequest, "param: cursor is invalid")
+			return
+		}
+	}
+
+	if !ah.authorize(ctx, id, permView) {
+		return
+	}
+	statement, err := ah.buildStatement(ctx.Request.Context(), id, r, after, limit)
+	if err != nil {
+		if services.SendContextError(ctx, err) {
+			return
+		}
+		if errors.Is(err, schemas.ErrAccountNotFound) {
+			services.SendError(ctx, http.StatusNotFound, fmt.Sprintf("account with id: %d not found", id))
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "error building statement", "error", err)
+		services.SendError(ctx, http.StatusInternalServerError, "error building statement")
+		return
+	}
+	services.SendSuccess(ctx, "statement", statement)
+}
+
+// handleStatementExport serves the whole statement range, without
+// pagination, in the given export format.
+func (ah *AccountHandler) handleStatementExport(format export.Format) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, err := accountIdParam(ctx)
+		if err != nil {
+			services.SendError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		r, err := parseStatementRange(ctx)
+		if err != nil {
+			services.SendError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !ah.authorize(ctx, id, permView) {
+			return
+		}
+		statement, err := ah.buildStatement(ctx.Request.Context(), id, r, nil, 0)
+		if err != nil {
+			if services.SendContextError(ctx, err) {
+				return
+			}
+			if errors.Is(err, schemas.ErrAccountNotFound) {
+				services.SendError(ctx, http.StatusNotFound, fmt.Sprintf("account with id: %d not found", id))
+				return
+			}
+			slog.ErrorContext(ctx.Request.Context(), "error building statement", "error", err)
+			services.SendError(ctx, http.StatusInternalServerError, "error building statement")
+			return
+		}
+
+		buf := bytes.Buffer{}
+		if err := format.Write(&buf, statement, time.Now()); err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "error exporting statement", "error", err)
+			services.SendError(ctx, http.StatusInternalServerError, "error exporting statement")
+			return
+		}
+		filename := fmt.Sprintf("statement-%d.%s", id, format.Extension)
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		ctx.Data(http.StatusOK, format.ContentType, buf.Bytes())
+	}
+}
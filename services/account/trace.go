@@ -0,0 +1,99 @@
+package account
+
+import (
+	"context"
+	"time"
+
+	"github.com/jamadeu/accounts/money"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/tracing"
+)
+
+// tracedRepository wraps an AccountRepository with one span per call,
+// carrying the account IDs involved. Queries issued by the wrapped
+// repository nest under it.
+type tracedRepository struct {
+	next schemas.AccountRepository
+}
+
+func NewTracedAccountRepository(next schemas.AccountRepository) schemas.AccountRepository {
+	return &tracedRepository{next: next}
+}
+
+func (r *tracedRepository) CreateAccount(ctx context.Context, account *schemas.Account) (err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.CreateAccount", tracing.UserID(account.HolderID))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(tracing.AccountID(account.ID))
+		}
+		tracing.End(span, &err)
+	}()
+	return r.next.CreateAccount(ctx, account)
+}
+
+func (r *tracedRepository) FindById(ctx context.Context, id uint) (_ *schemas.Account, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.FindById", tracing.AccountID(id))
+	defer tracing.End(span, &err)
+	return r.next.FindById(ctx, id)
+}
+
+func (r *tracedRepository) Deposit(ctx context.Context, id uint, amount money.Money) (_ *schemas.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.Deposit", tracing.AccountID(id))
+	defer tracing.End(span, &err)
+	return r.next.Deposit(ctx, id, amount)
+}
+
+func (r *tracedRepository) Withdraw(ctx context.Context, id uint, amount money.Money) (_ *schemas.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.Withdraw", tracing.AccountID(id))
+	defer tracing.End(span, &err)
+	return r.next.Withdraw(ctx, id, amount)
+}
+
+func (r *tracedRepository) Transfer(ctx context.Context, fromId, toId uint, amount money.Money) (_ *schemas.Transfer, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.Transfer",
+		tracing.FromAccountIDKey.Int64(int64(fromId)), tracing.ToAccountIDKey.Int64(int64(toId)))
+	defer tracing.End(span, &err)
+	return r.next.Transfer(ctx, fromId, toId, amount)
+}
+
+func (r *tracedRepository) BalanceAt(ctx context.Context, id uint, at time.Time) (_ money.Money, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.BalanceAt", tracing.AccountID(id))
+	defer tracing.End(span, &err)
+	return r.next.BalanceAt(ctx, id, at)
+}
+
+func (r *tracedRepository) ListTransactions(ctx context.Context, id uint, from, to time.Time, after *schemas.StatementCursor, limit int) (_ []schemas.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.ListTransactions", tracing.AccountID(id))
+	defer tracing.End(span, &err)
+	return r.next.ListTransactions(ctx, id, from, to, after, limit)
+}
+
+func (r *tracedRepository) BalanceBefore(ctx context.Context, id uint, at time.Time) (_ money.Money, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.BalanceBefore", tracing.AccountID(id))
+	defer tracing.End(span, &err)
+	return r.next.BalanceBefore(ctx, id, at)
+}
+
+func (r *tracedRepository) Holders(ctx context.Context, id uint) (_ []schemas.AccountHolder, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.Holders", tracing.AccountID(id))
+	defer tracing.End(span, &err)
+	return r.next.Holders(ctx, id)
+}
+
+func (r *tracedRepository) HolderRole(ctx context.Context, id, userID uint) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.HolderRole", tracing.AccountID(id), tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.HolderRole(ctx, id, userID)
+}
+
+func (r *tracedRepository) AddHolder(ctx context.Context, holder *schemas.AccountHolder) (err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.AddHolder", tracing.AccountID(holder.AccountID), tracing.UserID(holder.UserID))
+	defer tracing.End(span, &err)
+	return r.next.AddHolder(ctx, holder)
+}
+
+func (r *tracedRepository) RemoveHolder(ctx context.Context, id, userID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "AccountRepository.RemoveHolder", tracing.AccountID(id), tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.RemoveHolder(ctx, id, userID)
+}
@@ -0,0 +1,354 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func jsonToString(s interface{}) string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// newAPIKeyRepository serves user 1, a customer, and user 2, a support agent.
+func newAPIKeyRepository() *mockAPIKeyRepository {
+	return &mockAPIKeyRepository{
+		keys: map[uint]*schemas.APIKey{},
+		users: map[uint]schemas.User{
+			1: {Model: gorm.Model{ID: 1}, Role: schemas.RoleCustomer},
+			2: {Model: gorm.Model{ID: 2}, Role: schemas.RoleSupport},
+		},
+	}
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	keyRepo := newAPIKeyRepository()
+	verifier := NewVerifier(keyRepo)
+	handler := NewAPIKeyHandler(keyRepo)
+	router := gin.Default()
+	handler.RegisterRoutes(router, "/api")
+
+	var created CreatedAPIKey
+
+	t.Run("handle create should return the key once and store its hash", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := CreateAPIKeyRequest{Name: "erp", Scopes: []string{"transfers:write", "accounts:read"}}
+		req, err := http.NewRequest("POST", "/api/v1/api-keys", bytes.NewBufferString(jsonToString(payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(asCaller(req, 1)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		decodeData(t, w, &created)
+		stored := keyRepo.keys[created.ID]
+		if !assert.NotNil(t, stored) {
+			return
+		}
+		expectedResponseBody := "{\"data\":" + jsonToString(CreatedAPIKey{schemas.NewAPIKeyResponse(*stored), created.Key}) + "," +
+			"\"message\":\"operation from handler: create-api-key successfull\"}"
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.True(t, strings.HasPrefix(created.Key, created.Prefix+"_"), created.Key)
+		assert.Equal(t, []string{"accounts:read", "transfers:write"}, created.Scopes)
+		assert.NotContains(t, stored.SecretHash, strings.TrimPrefix(created.Key, created.Prefix+"_"))
+	})
+
+	t.Run("handle list should return the keys without their secret", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/api-keys", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expected := []schemas.APIKeyResponse{schemas.NewAPIKeyResponse(*keyRepo.keys[created.ID])}
+		expectedResponseBody := "{\"data\":" + jsonToString(expected) + "," +
+			"\"message\":\"operation from handler: list-api-keys successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.NotContains(t, w.Body.String(), created.Key)
+	})
+
+	t.Run("handle create should return 400 when request is invalid", func(t *testing.T) {
+		for _, tc := range []struct {
+			request CreateAPIKeyRequest
+			message string
+		}{
+			{CreateAPIKeyRequest{Scopes: []string{"accounts:read"}}, "param: name (type: string) is required"},
+			{CreateAPIKeyRequest{Name: "erp"}, "param: scopes (type: []string) is required"},
+			{CreateAPIKeyRequest{Name: "erp", Scopes: []string{"accounts:delete"}}, `param: scopes contains unknown scope \"accounts:delete\"`},
+		} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/api-keys", bytes.NewBufferString(jsonToString(tc.request)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, steppedUp(asCaller(req, 1)))
+
+			expectedResponseBody := "{\"errorCode\":400,\"message\":\"" + tc.message + "\"}"
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, expectedResponseBody, w.Body.String())
+		}
+	})
+
+	t.Run("handle create should return 403 for scopes the role does not grant", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := CreateAPIKeyRequest{Name: "erp", Scopes: []string{"users:list"}}
+		req, err := http.NewRequest("POST", "/api/v1/api-keys", bytes.NewBufferString(jsonToString(payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(asCaller(req, 1)))
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"role customer may not grant scope users:list\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 401 without a fresh second factor", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := CreateAPIKeyRequest{Name: "erp", Scopes: []string{"accounts:read"}}
+		req, err := http.NewRequest("POST", "/api/v1/api-keys", bytes.NewBufferString(jsonToString(payload)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stale := req.WithContext(services.WithSecondFactor(req.Context(), time.Now().Add(-time.Hour)))
+		router.ServeHTTP(w, asCaller(stale, 1))
+
+		expectedResponseBody := "{\"errorCode\":401,\"message\":\"this operation requires a second factor verified in the last 5 minutes, enroll one first if you have not\"}"
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("should return 403 when api keys manage api keys", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/api-keys", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyed := req.WithContext(services.WithScopes(req.Context(), []string{"accounts:read"}))
+		router.ServeHTTP(w, asCaller(keyed, 1))
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"this operation is not available to API keys\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle rotate should keep the old key working for the overlap", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/api-keys/"+strconv.FormatUint(uint64(created.ID), 10)+"/rotate", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(asCaller(req, 1)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		rotated := CreatedAPIKey{}
+		decodeData(t, w, &rotated)
+		stored := keyRepo.keys[rotated.ID]
+		if !assert.NotNil(t, stored) {
+			return
+		}
+		expectedResponseBody := "{\"data\":" + jsonToString(CreatedAPIKey{schemas.NewAPIKeyResponse(*stored), rotated.Key}) + "," +
+			"\"message\":\"operation from handler: rotate-api-key successfull\"}"
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.Equal(t, "erp", rotated.Name)
+		assert.Equal(t, []string{"accounts:read", "transfers:write"}, rotated.Scopes)
+
+		_, err = verifier.Verify(context.Background(), rotated.Key)
+		assert.NoError(t, err)
+		_, err = verifier.Verify(context.Background(), created.Key)
+		assert.NoError(t, err)
+		verifier.now = func() time.Time { return time.Now().Add(DefaultRotationOverlap + time.Minute) }
+		defer func() { verifier.now = time.Now }()
+		_, err = verifier.Verify(context.Background(), created.Key)
+		assert.ErrorIs(t, err, schemas.ErrAPIKeyInvalid)
+		created = rotated
+	})
+
+	t.Run("handle revoke should return 404 for the keys of other users", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/api-keys/"+strconv.FormatUint(uint64(created.ID), 10), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 2))
+
+		expectedResponseBody := "{\"errorCode\":404,\"message\":\"api key with id: " + strconv.FormatUint(uint64(created.ID), 10) + " not found\"}"
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle revoke should stop the key working", func(t *testing.T) {
+		id := strconv.FormatUint(uint64(created.ID), 10)
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/api-keys/"+id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"data\":\"id: " + id + "\",\"message\":\"operation from handler: revoke-api-key successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		_, err = verifier.Verify(context.Background(), created.Key)
+		assert.ErrorIs(t, err, schemas.ErrAPIKeyInvalid)
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("DELETE", "/api/v1/api-keys/"+id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody = "{\"errorCode\":404,\"message\":\"api key with id: " + id + " not found\"}"
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+}
+
+func TestVerifier(t *testing.T) {
+	keyRepo := newAPIKeyRepository()
+	verifier := NewVerifier(keyRepo)
+	prefix, raw, hash, err := newKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := schemas.APIKey{UserID: 1, Name: "erp", Prefix: prefix, SecretHash: hash, Scopes: "accounts:read"}
+	if err := keyRepo.Create(context.Background(), &key); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("should return the key with its user and record the use", func(t *testing.T) {
+		found, err := verifier.Verify(context.Background(), raw)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(1), found.User.ID)
+		assert.Equal(t, []string{"accounts:read"}, found.ScopeList())
+		assert.NotNil(t, keyRepo.keys[key.ID].LastUsedAt)
+		assert.Equal(t, 1, keyRepo.uses)
+
+		_, err = verifier.Verify(context.Background(), raw)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, keyRepo.uses, "uses within a minute are not recorded again")
+	})
+
+	t.Run("should reject keys that do not match", func(t *testing.T) {
+		for _, wrong := range []string{
+			raw + "x",
+			prefix,
+			prefix + "_",
+			"ak_00000000_" + strings.TrimPrefix(raw, prefix+"_"),
+		} {
+			_, err := verifier.Verify(context.Background(), wrong)
+			assert.ErrorIs(t, err, schemas.ErrAPIKeyInvalid, wrong)
+		}
+	})
+
+	t.Run("should reject keys of deleted users", func(t *testing.T) {
+		delete(keyRepo.users, 1)
+		_, err := verifier.Verify(context.Background(), raw)
+		assert.ErrorIs(t, err, schemas.ErrAPIKeyInvalid)
+	})
+}
+
+func decodeData(t *testing.T, w *httptest.ResponseRecorder, data any) {
+	body := struct{ Data any }{Data: data}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func asCaller(req *http.Request, userID uint) *http.Request {
+	return req.WithContext(services.WithCaller(req.Context(), userID))
+}
+
+// steppedUp marks req as coming from a caller who just verified a second
+// factor.
+func steppedUp(req *http.Request) *http.Request {
+	return req.WithContext(services.WithSecondFactor(req.Context(), time.Now()))
+}
+
+type mockAPIKeyRepository struct {
+	keys  map[uint]*schemas.APIKey
+	users map[uint]schemas.User
+	uses  int
+}
+
+func (m *mockAPIKeyRepository) Create(ctx context.Context, key *schemas.APIKey) error {
+	key.ID = uint(len(m.keys) + 1)
+	key.CreatedAt = time.Now()
+	stored := *key
+	m.keys[key.ID] = &stored
+	return nil
+}
+
+func (m *mockAPIKeyRepository) ListByUser(ctx context.Context, userID uint) ([]schemas.APIKey, error) {
+	keys := []schemas.APIKey{}
+	for _, key := range m.keys {
+		if key.UserID == userID {
+			keys = append(keys, *key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (m *mockAPIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*schemas.APIKey, error) {
+	for _, key := range m.keys {
+		if key.Prefix == prefix {
+			found := *key
+			found.User = m.users[key.UserID]
+			return &found, nil
+		}
+	}
+	return nil, schemas.ErrAPIKeyNotFound
+}
+
+func (m *mockAPIKeyRepository) active(id, userID uint) (*schemas.APIKey, bool) {
+	key, ok := m.keys[id]
+	return key, ok && key.UserID == userID && key.Active(time.Now())
+}
+
+func (m *mockAPIKeyRepository) Rotate(ctx context.Context, id, userID uint, replacement *schemas.APIKey, overlap time.Duration) error {
+	old, ok := m.active(id, userID)
+	if !ok {
+		return schemas.ErrAPIKeyNotFound
+	}
+	retire := time.Now().Add(overlap)
+	old.ExpiresAt = &retire
+	replacement.UserID, replacement.Name, replacement.Scopes = old.UserID, old.Name, old.Scopes
+	return m.Create(ctx, replacement)
+}
+
+func (m *mockAPIKeyRepository) Revoke(ctx context.Context, id, userID uint) error {
+	key, ok := m.active(id, userID)
+	if !ok {
+		return schemas.ErrAPIKeyNotFound
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return nil
+}
+
+func (m *mockAPIKeyRepository) RecordUse(ctx context.Context, id uint, at time.Time) error {
+	m.uses++
+	m.keys[id].LastUsedAt = &at
+	return nil
+}
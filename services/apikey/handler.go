@@ -0,0 +1,171 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+)
+
+// DefaultRotationOverlap is how long a rotated key keeps working, for
+// clients to switch to its replacement.
+const DefaultRotationOverlap = 24 * time.Hour
+
+// CreatedAPIKey is the only response carrying the key itself.
+type CreatedAPIKey struct {
+	schemas.APIKeyResponse
+	Key string `json:"key"`
+}
+
+type APIKeyHandler struct {
+	keyRepo   schemas.APIKeyRepository
+	overlap   time.Duration
+	deadlines services.Deadlines
+}
+
+func NewAPIKeyHandler(kr schemas.APIKeyRepository) *APIKeyHandler {
+	return &APIKeyHandler{keyRepo: kr, overlap: DefaultRotationOverlap, deadlines: services.DefaultDeadlines}
+}
+
+// RegisterRoutes serves the caller's API keys. Keys are managed with a user
+// session only, and minting one takes a fresh second factor.
+func (h *APIKeyHandler) RegisterRoutes(router *gin.Engine, basePath string) {
+	read := services.Deadline(h.deadlines.Read)
+	write := services.Deadline(h.deadlines.Write)
+	stepUp := services.RequireSecondFactor()
+	v1 := router.Group(basePath+"/v1/api-keys", services.RequireSession())
+	{
+		v1.POST("", stepUp, write, h.handleCreate)
+		v1.GET("", read, h.handleList)
+		v1.POST("/:id/rotate", stepUp, write, h.handleRotate)
+		v1.DELETE("/:id", write, h.handleRevoke)
+	}
+}
+
+// handleCreate creates a key for the caller. Its scopes must be granted by
+// the caller's role.
+func (h *APIKeyHandler) handleCreate(ctx *gin.Context) {
+	request := CreateAPIKeyRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := ctx.Request.Context()
+	role := services.Role(c)
+	for _, scope := range request.Scopes {
+		if !services.Granted(role, services.Permission(scope)) {
+			services.SendError(ctx, http.StatusForbidden, fmt.Sprintf("role %s may not grant scope %s", role, scope))
+			return
+		}
+	}
+	scopes := slices.Clone(request.Scopes)
+	slices.Sort(scopes)
+	caller, _ := services.Caller(c)
+	prefix, raw, hash, err := newKey()
+	if err != nil {
+		h.sendInternalError(ctx, "error creating api key", err)
+		return
+	}
+	key := schemas.APIKey{
+		UserID:     caller,
+		Name:       request.Name,
+		Prefix:     prefix,
+		SecretHash: hash,
+		Scopes:     strings.Join(slices.Compact(scopes), " "),
+		ExpiresAt:  request.ExpiresAt,
+	}
+	if err := h.keyRepo.Create(c, &key); err != nil {
+		h.sendInternalError(ctx, "error creating api key", err)
+		return
+	}
+	slog.InfoContext(c, "created api key", "user_id", caller, "prefix", prefix, "scopes", key.Scopes)
+	services.SendSuccess(ctx, "create-api-key", CreatedAPIKey{schemas.NewAPIKeyResponse(key), raw})
+}
+
+func (h *APIKeyHandler) handleList(ctx *gin.Context) {
+	caller, _ := services.Caller(ctx.Request.Context())
+	keys, err := h.keyRepo.ListByUser(ctx.Request.Context(), caller)
+	if err != nil {
+		h.sendInternalError(ctx, "error listing api keys", err)
+		return
+	}
+	response := make([]schemas.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, schemas.NewAPIKeyResponse(key))
+	}
+	services.SendSuccess(ctx, "list-api-keys", response)
+}
+
+// handleRotate replaces an active key with a new one, leaving the old key
+// working for the rotation overlap.
+func (h *APIKeyHandler) handleRotate(ctx *gin.Context) {
+	id, ok := keyIdParam(ctx)
+	if !ok {
+		return
+	}
+	c := ctx.Request.Context()
+	caller, _ := services.Caller(c)
+	prefix, raw, hash, err := newKey()
+	if err != nil {
+		h.sendInternalError(ctx, "error rotating api key", err)
+		return
+	}
+	replacement := schemas.APIKey{Prefix: prefix, SecretHash: hash}
+	if err := h.keyRepo.Rotate(c, id, caller, &replacement, h.overlap); err != nil {
+		h.sendKeyError(ctx, id, "error rotating api key", err)
+		return
+	}
+	slog.InfoContext(c, "rotated api key", "user_id", caller, "id", id, "prefix", prefix)
+	services.SendSuccess(ctx, "rotate-api-key", CreatedAPIKey{schemas.NewAPIKeyResponse(replacement), raw})
+}
+
+func (h *APIKeyHandler) handleRevoke(ctx *gin.Context) {
+	id, ok := keyIdParam(ctx)
+	if !ok {
+		return
+	}
+	c := ctx.Request.Context()
+	caller, _ := services.Caller(c)
+	if err := h.keyRepo.Revoke(c, id, caller); err != nil {
+		h.sendKeyError(ctx, id, "error revoking api key", err)
+		return
+	}
+	slog.InfoContext(c, "revoked api key", "user_id", caller, "id", id)
+	services.SendSuccess(ctx, "revoke-api-key", fmt.Sprintf("id: %d", id))
+}
+
+func keyIdParam(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		services.SendError(ctx, http.StatusBadRequest, "param: id (type: pathParameter) must be a positive integer")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// sendKeyError answers 404 for keys the caller has no active one of, which
+// includes the keys of other users.
+func (h *APIKeyHandler) sendKeyError(ctx *gin.Context, id uint, msg string, err error) {
+	if errors.Is(err, schemas.ErrAPIKeyNotFound) {
+		services.SendError(ctx, http.StatusNotFound, fmt.Sprintf("api key with id: %d not found", id))
+		return
+	}
+	h.sendInternalError(ctx, msg, err)
+}
+
+func (h *APIKeyHandler) sendInternalError(ctx *gin.Context, msg string, err error) {
+	if services.SendContextError(ctx, err) {
+		return
+	}
+	slog.ErrorContext(ctx.Request.Context(), msg, "error", err)
+	services.SendError(ctx, http.StatusInternalServerError, msg)
+}
@@ -0,0 +1,102 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jamadeu/accounts/schemas"
+)
+
+// Keys read ak_<8 hex digits>_<secret>. The part before the second
+// underscore is the prefix stored in the clear; the secret is 256 random
+// bits, URL safe.
+const (
+	tag       = "ak_"
+	prefixLen = len(tag) + 8
+)
+
+// lastUsedResolution is how stale the recorded last use of a key may get,
+// so busy keys do not cost a write per request.
+const lastUsedResolution = time.Minute
+
+// IsKey reports whether a Bearer credential is an API key rather than an
+// access token.
+func IsKey(raw string) bool {
+	return strings.HasPrefix(raw, tag)
+}
+
+// newKey returns the prefix, the full key to hand out once and the hash to
+// store.
+func newKey() (prefix, raw, hash string, err error) {
+	b := make([]byte, 4+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	prefix = tag + hex.EncodeToString(b[:4])
+	secret := base64.RawURLEncoding.EncodeToString(b[4:])
+	return prefix, prefix + "_" + secret, hashSecret(secret), nil
+}
+
+// parse splits a key into its prefix and secret.
+func parse(raw string) (prefix, secret string, ok bool) {
+	if !IsKey(raw) || len(raw) <= prefixLen+1 || raw[prefixLen] != '_' {
+		return "", "", false
+	}
+	return raw[:prefixLen], raw[prefixLen+1:], true
+}
+
+// hashSecret is the form secrets are stored in. They carry 256 bits of
+// entropy, so an unsalted fast hash is enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verifier authenticates requests carrying an API key.
+type Verifier struct {
+	repo schemas.APIKeyRepository
+	now  func() time.Time
+}
+
+func NewVerifier(repo schemas.APIKeyRepository) *Verifier {
+	return &Verifier{repo: repo, now: time.Now}
+}
+
+// Verify returns the key raw is, with its user, while the key is active and
+// the user exists. Keys that do not match wrap schemas.ErrAPIKeyInvalid;
+// other errors come from the repository.
+func (v *Verifier) Verify(ctx context.Context, raw string) (*schemas.APIKey, error) {
+	prefix, secret, ok := parse(raw)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed key", schemas.ErrAPIKeyInvalid)
+	}
+	key, err := v.repo.FindByPrefix(ctx, prefix)
+	if errors.Is(err, schemas.ErrAPIKeyNotFound) {
+		return nil, fmt.Errorf("%w: unknown prefix %s", schemas.ErrAPIKeyInvalid, prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, fmt.Errorf("%w: wrong secret for %s", schemas.ErrAPIKeyInvalid, prefix)
+	}
+	now := v.now()
+	if !key.Active(now) || key.User.ID == 0 {
+		return nil, fmt.Errorf("%w: %s is revoked, expired or its user deleted", schemas.ErrAPIKeyInvalid, prefix)
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		if err := v.repo.RecordUse(ctx, key.ID, now); err != nil {
+			slog.WarnContext(ctx, "error recording api key use", "prefix", prefix, "error", err)
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,88 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jamadeu/accounts/schemas"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *schemas.APIKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *APIKeyRepository) ListByUser(ctx context.Context, userID uint) ([]schemas.APIKey, error) {
+	keys := []schemas.APIKey{}
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *APIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*schemas.APIKey, error) {
+	key := schemas.APIKey{}
+	// The join leaves User empty when the user was deleted
+	err := r.db.WithContext(ctx).Joins("User").Where("api_keys.prefix = ?", prefix).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, schemas.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) Rotate(ctx context.Context, id, userID uint, replacement *schemas.APIKey, overlap time.Duration) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		old := schemas.APIKey{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", id, userID, now).
+			First(&old).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return schemas.ErrAPIKeyNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if retire := now.Add(overlap); old.ExpiresAt == nil || retire.Before(*old.ExpiresAt) {
+			if err := tx.Model(&old).Update("expires_at", retire).Error; err != nil {
+				return err
+			}
+		}
+		replacement.UserID = old.UserID
+		replacement.Name = old.Name
+		replacement.Scopes = old.Scopes
+		replacement.ExpiresAt = old.ExpiresAt
+		return tx.Create(replacement).Error
+	})
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, id, userID uint) error {
+	result := r.db.WithContext(ctx).Model(&schemas.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return schemas.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) RecordUse(ctx context.Context, id uint, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&schemas.APIKey{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+}
@@ -0,0 +1,42 @@
+package apikey
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jamadeu/accounts/services"
+)
+
+const maxNameLength = 100
+
+func errParamIsRequired(name, typ string) error {
+	return fmt.Errorf("param: %s (type: %s) is required", name, typ)
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (r *CreateAPIKeyRequest) Validate() error {
+	if r.Name == "" {
+		return errParamIsRequired("name", "string")
+	}
+	if len(r.Name) > maxNameLength {
+		return fmt.Errorf("param: name must be at most %d characters", maxNameLength)
+	}
+	if len(r.Scopes) == 0 {
+		return errParamIsRequired("scopes", "[]string")
+	}
+	for _, scope := range r.Scopes {
+		if !slices.Contains(services.Permissions, services.Permission(scope)) {
+			return fmt.Errorf("param: scopes contains unknown scope %q", scope)
+		}
+	}
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("param: expiresAt must be in the future")
+	}
+	return nil
+}
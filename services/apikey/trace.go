@@ -0,0 +1,61 @@
+package apikey
+
+import (
+	"context"
+	"time"
+
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/tracing"
+)
+
+// tracedRepository wraps an APIKeyRepository with one span per call,
+// carrying the user ID involved. Secrets and their hashes are never
+// recorded.
+type tracedRepository struct {
+	next schemas.APIKeyRepository
+}
+
+func NewTracedAPIKeyRepository(next schemas.APIKeyRepository) schemas.APIKeyRepository {
+	return &tracedRepository{next: next}
+}
+
+func (r *tracedRepository) Create(ctx context.Context, key *schemas.APIKey) (err error) {
+	ctx, span := tracing.Start(ctx, "APIKeyRepository.Create", tracing.UserID(key.UserID))
+	defer tracing.End(span, &err)
+	return r.next.Create(ctx, key)
+}
+
+func (r *tracedRepository) ListByUser(ctx context.Context, userID uint) (_ []schemas.APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "APIKeyRepository.ListByUser", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.ListByUser(ctx, userID)
+}
+
+func (r *tracedRepository) FindByPrefix(ctx context.Context, prefix string) (key *schemas.APIKey, err error) {
+	ctx, span := tracing.Start(ctx, "APIKeyRepository.FindByPrefix")
+	defer func() {
+		if err == nil {
+			span.SetAttributes(tracing.UserID(key.UserID))
+		}
+		tracing.End(span, &err)
+	}()
+	return r.next.FindByPrefix(ctx, prefix)
+}
+
+func (r *tracedRepository) Rotate(ctx context.Context, id, userID uint, replacement *schemas.APIKey, overlap time.Duration) (err error) {
+	ctx, span := tracing.Start(ctx, "APIKeyRepository.Rotate", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.Rotate(ctx, id, userID, replacement, overlap)
+}
+
+func (r *tracedRepository) Revoke(ctx context.Context, id, userID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "APIKeyRepository.Revoke", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.Revoke(ctx, id, userID)
+}
+
+func (r *tracedRepository) RecordUse(ctx context.Context, id uint, at time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "APIKeyRepository.RecordUse")
+	defer tracing.End(span, &err)
+	return r.next.RecordUse(ctx, id, at)
+}
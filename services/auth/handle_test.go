@@ -0,0 +1,964 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/notify"
+	"github.com/jamadeu/accounts/password"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/token"
+	"github.com/jamadeu/accounts/totp"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+const currentPassword = "Correct-Horse-9"
+
+// cheapParams keep hashing fast in tests.
+var cheapParams = password.Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// usersTest are served by every test: 1 has currentPassword and 2 has no
+// password yet.
+var usersTest = []schemas.User{
+	{Model: gorm.Model{ID: 1}, Name: "Ana", Email: "ana@test.com", Document: "52998224725"},
+	{Model: gorm.Model{ID: 2}, Name: "Bia", Email: "bia@test.com", Document: "11144477735"},
+}
+
+func jsonToString(s interface{}) string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func newIssuer(t *testing.T) *token.Issuer {
+	key, err := token.NewHMACKey("test", []byte(strings.Repeat("k", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := token.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.NewIssuer(keys, "accounts", time.Minute, time.Hour)
+}
+
+func newHasher(t *testing.T) *password.Hasher {
+	hasher, err := password.NewHasher(cheapParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func newUserRepository() *mockUserRepository {
+	userRepo := &mockUserRepository{users: map[uint]*schemas.User{}}
+	for _, user := range usersTest {
+		userRepo.users[user.ID] = &user
+	}
+	return userRepo
+}
+
+// newCredentialRepository gives user 1 currentPassword.
+func newCredentialRepository(t *testing.T, hasher *password.Hasher) *mockCredentialRepository {
+	hash, err := hasher.Hash(currentPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mockCredentialRepository{
+		credentials: map[uint]*schemas.Credential{1: {UserID: 1, PasswordHash: hash}},
+		resets:      map[string]*schemas.PasswordReset{},
+	}
+}
+
+// testSettings lock out after three failures, so tests need fewer requests.
+func testSettings() Settings {
+	settings := DefaultSettings
+	settings.MaxFailedLogins = 3
+	return settings
+}
+
+func decodeData(t *testing.T, w *httptest.ResponseRecorder, data any) {
+	body := struct{ Data any }{Data: data}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthHandlers(t *testing.T) {
+	issuer := newIssuer(t)
+	hasher := newHasher(t)
+	userRepo := newUserRepository()
+	credentialRepo := newCredentialRepository(t, hasher)
+	twoFactorRepo := &mockTwoFactorRepository{users: userRepo, codes: map[string]*schemas.RecoveryCode{}}
+	notifier := notify.NewMemory()
+	handler := NewAuthHandler(issuer, userRepo, credentialRepo, twoFactorRepo, hasher, notifier, testSettings())
+	router := gin.Default()
+	handler.RegisterRoutes(router, "/api")
+
+	t.Run("handle refresh should issue a new pair", func(t *testing.T) {
+		pair, err := issuer.Issue(1, schemas.RoleCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewBufferString(jsonToString(RefreshRequest{RefreshToken: pair.RefreshToken})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		refreshed := token.Pair{}
+		decodeData(t, w, &refreshed)
+		claims, err := issuer.Verify(refreshed.AccessToken, token.TypeAccess)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", claims.Subject)
+		expectedResponseBody := "{\"data\":" + jsonToString(refreshed) + "," +
+			"\"message\":\"operation from handler: refresh successfull\"}"
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle refresh should carry the current role of the user", func(t *testing.T) {
+		pair, err := issuer.Issue(1, schemas.RoleCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userRepo.users[1].Role = schemas.RoleSupport
+		defer func() { userRepo.users[1].Role = "" }()
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewBufferString(jsonToString(RefreshRequest{RefreshToken: pair.RefreshToken})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		refreshed := token.Pair{}
+		decodeData(t, w, &refreshed)
+		claims, err := issuer.Verify(refreshed.AccessToken, token.TypeAccess)
+		assert.NoError(t, err)
+		assert.Equal(t, schemas.RoleSupport, claims.Role)
+	})
+
+	t.Run("handle refresh should return 401 for access tokens and missing users", func(t *testing.T) {
+		access, err := issuer.Issue(1, schemas.RoleCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		missing, err := issuer.Issue(3, schemas.RoleCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, raw := range []string{access.AccessToken, missing.RefreshToken} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewBufferString(jsonToString(RefreshRequest{RefreshToken: raw})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, req)
+
+			expectedResponseBody := "{\"errorCode\":401,\"message\":\"invalid or expired refresh token\"}"
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, expectedResponseBody, w.Body.String())
+		}
+	})
+
+	t.Run("handle refresh should return 401 for tokens issued before the password changed", func(t *testing.T) {
+		pair, err := issuer.Issue(1, schemas.RoleCustomer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		credentialRepo.credentials[1].PasswordChangedAt = time.Now().Add(time.Second)
+		defer func() { credentialRepo.credentials[1].PasswordChangedAt = time.Time{} }()
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewBufferString(jsonToString(RefreshRequest{RefreshToken: pair.RefreshToken})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":401,\"message\":\"invalid or expired refresh token\"}"
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle refresh should return 400 when the token is missing", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/refresh", bytes.NewBufferString(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: refreshToken (type: string) is required\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle jwks should publish no HMAC secrets", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"keys":[]}`, w.Body.String())
+	})
+
+	t.Run("handle login should issue a pair for the right password", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(jsonToString(LoginRequest{Email: "ANA@test.com", Password: currentPassword})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		pair := token.Pair{}
+		decodeData(t, w, &pair)
+		claims, err := issuer.Verify(pair.AccessToken, token.TypeAccess)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", claims.Subject)
+		assert.NotNil(t, credentialRepo.credentials[1].LastLoginAt)
+	})
+
+	t.Run("handle login should return 401 alike for unknown emails, missing and wrong passwords", func(t *testing.T) {
+		for _, request := range []LoginRequest{
+			{Email: "nobody@test.com", Password: currentPassword},
+			{Email: "bia@test.com", Password: currentPassword},
+			{Email: "ana@test.com", Password: "Wrong-Horse-9"},
+		} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(jsonToString(request)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, req)
+
+			expectedResponseBody := "{\"errorCode\":401,\"message\":\"invalid email or password\"}"
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Equal(t, expectedResponseBody, w.Body.String())
+		}
+		credentialRepo.credentials[1].FailedLogins = 0
+	})
+
+	t.Run("handle login should return 400 when the password is missing", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(`{"email":"ana@test.com"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: password (type: string) is required\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle login should return 423 after repeated failures", func(t *testing.T) {
+		for _, wrong := range []string{"Wrong-Horse-1", "Wrong-Horse-2"} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(jsonToString(LoginRequest{Email: "ana@test.com", Password: wrong})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+		for _, pwd := range []string{"Wrong-Horse-3", currentPassword} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(jsonToString(LoginRequest{Email: "ana@test.com", Password: pwd})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, req)
+
+			expectedResponseBody := "{\"errorCode\":423,\"message\":\"too many failed logins, try again later\"}"
+			assert.Equal(t, http.StatusLocked, w.Code)
+			assert.Equal(t, expectedResponseBody, w.Body.String())
+			assert.Equal(t, "900", w.Header().Get("Retry-After"))
+		}
+
+		past := time.Now().Add(-time.Second)
+		credentialRepo.credentials[1].LockedUntil = &past
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(jsonToString(LoginRequest{Email: "ana@test.com", Password: currentPassword})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("handle login should upgrade hashes made with other parameters", func(t *testing.T) {
+		weaker, err := password.NewHasher(password.Params{Memory: 32, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+		if err != nil {
+			t.Fatal(err)
+		}
+		old, err := weaker.Hash(currentPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		credentialRepo.credentials[1].PasswordHash = old
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBufferString(jsonToString(LoginRequest{Email: "ana@test.com", Password: currentPassword})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		upgraded := credentialRepo.credentials[1].PasswordHash
+		assert.NotEqual(t, old, upgraded)
+		_, rehash, err := hasher.Verify(currentPassword, upgraded)
+		assert.NoError(t, err)
+		assert.False(t, rehash)
+	})
+
+	t.Run("handle change password should return 401 without a caller", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/password", bytes.NewBufferString(jsonToString(ChangePasswordRequest{CurrentPassword: currentPassword, NewPassword: "Battery-Staple-7"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":401,\"message\":\"authentication required\"}"
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle change password should return 403 and count a wrong current password", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/password", bytes.NewBufferString(jsonToString(ChangePasswordRequest{CurrentPassword: "Wrong-Horse-9", NewPassword: "Battery-Staple-7"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"current password is incorrect\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.Equal(t, 1, credentialRepo.credentials[1].FailedLogins)
+		credentialRepo.credentials[1].FailedLogins = 0
+	})
+
+	t.Run("handle change password should return 422 for passwords the policy refuses", func(t *testing.T) {
+		for next, message := range map[string]string{
+			"short":            "password does not meet the policy: it must have at least 12 characters",
+			"Doc-52998224725!": "password does not meet the policy: it must not contain personal data",
+			currentPassword:    "the new password must differ from the current one",
+		} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/auth/password", bytes.NewBufferString(jsonToString(ChangePasswordRequest{CurrentPassword: currentPassword, NewPassword: next})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, asCaller(req, 1))
+
+			expectedResponseBody := "{\"errorCode\":422,\"message\":\"" + message + "\"}"
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+			assert.Equal(t, expectedResponseBody, w.Body.String())
+		}
+	})
+
+	t.Run("handle change password should return 409 for users without a password", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/password", bytes.NewBufferString(jsonToString(ChangePasswordRequest{CurrentPassword: currentPassword, NewPassword: "Battery-Staple-7"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 2))
+
+		expectedResponseBody := "{\"errorCode\":409,\"message\":\"user has no password, request a password reset\"}"
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle change password should replace the password and tell the user", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/password", bytes.NewBufferString(jsonToString(ChangePasswordRequest{CurrentPassword: currentPassword, NewPassword: "Battery-Staple-7"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"data\":null,\"message\":\"operation from handler: change-password successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		match, _, err := hasher.Verify("Battery-Staple-7", credentialRepo.credentials[1].PasswordHash)
+		assert.NoError(t, err)
+		assert.True(t, match)
+		sent := notifier.Sent("ana@test.com")
+		if assert.Len(t, sent, 1) {
+			assert.Equal(t, notify.TemplatePasswordChanged, sent[0].Template)
+		}
+	})
+
+	t.Run("handle reset should set a password with a single use token and lift the lockout", func(t *testing.T) {
+		locked := time.Now().Add(time.Hour)
+		credentialRepo.credentials[1].LockedUntil = &locked
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/password/reset", bytes.NewBufferString(jsonToString(PasswordResetRequest{Email: "ana@test.com"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"data\":null,\"message\":\"operation from handler: request-password-reset successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		sent := notifier.Sent("ana@test.com")
+		raw := sent[len(sent)-1].Params["token"]
+		assert.NotNil(t, credentialRepo.resets[hashResetToken(raw)], "only the hash of the token is stored")
+
+		for _, expected := range []struct {
+			next string
+			code int
+			body string
+		}{
+			{"Staple-Battery-8", http.StatusOK, "{\"data\":null,\"message\":\"operation from handler: reset-password successfull\"}"},
+			{"Another-Staple-8", http.StatusBadRequest, "{\"errorCode\":400,\"message\":\"" + schemas.ErrResetTokenInvalid.Error() + "\"}"},
+		} {
+			w = httptest.NewRecorder()
+			req, err = http.NewRequest("POST", "/api/v1/auth/password/reset/confirm", bytes.NewBufferString(jsonToString(ConfirmPasswordResetRequest{Token: raw, NewPassword: expected.next})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, expected.code, w.Code)
+			assert.Equal(t, expected.body, w.Body.String())
+		}
+		assert.Nil(t, credentialRepo.credentials[1].LockedUntil)
+		match, _, err := hasher.Verify("Staple-Battery-8", credentialRepo.credentials[1].PasswordHash)
+		assert.NoError(t, err)
+		assert.True(t, match)
+	})
+
+	t.Run("handle reset should let users without a password set one", func(t *testing.T) {
+		defer delete(credentialRepo.credentials, 2)
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/password/reset", bytes.NewBufferString(jsonToString(PasswordResetRequest{Email: "bia@test.com"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+		sent := notifier.Sent("bia@test.com")
+		raw := sent[len(sent)-1].Params["token"]
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/api/v1/auth/password/reset/confirm", bytes.NewBufferString(jsonToString(ConfirmPasswordResetRequest{Token: raw, NewPassword: "Battery-Staple-7"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"data\":null,\"message\":\"operation from handler: reset-password successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.Contains(t, credentialRepo.credentials, uint(2))
+	})
+
+	t.Run("handle reset should answer unknown emails alike without sending a token", func(t *testing.T) {
+		resets := len(credentialRepo.resets)
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/password/reset", bytes.NewBufferString(jsonToString(PasswordResetRequest{Email: "nobody@test.com"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"data\":null,\"message\":\"operation from handler: request-password-reset successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.Empty(t, notifier.Sent("nobody@test.com"))
+		assert.Len(t, credentialRepo.resets, resets)
+	})
+
+	t.Run("handle reset should return 422 for weak passwords and 400 for expired tokens", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/password/reset", bytes.NewBufferString(jsonToString(PasswordResetRequest{Email: "ana@test.com"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+		sent := notifier.Sent("ana@test.com")
+		raw := sent[len(sent)-1].Params["token"]
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/api/v1/auth/password/reset/confirm", bytes.NewBufferString(jsonToString(ConfirmPasswordResetRequest{Token: raw, NewPassword: "weak"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":422,\"message\":\"password does not meet the policy: it must have at least 12 characters\"}"
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+
+		credentialRepo.resets[hashResetToken(raw)].ExpiresAt = time.Now().Add(-time.Second)
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/api/v1/auth/password/reset/confirm", bytes.NewBufferString(jsonToString(ConfirmPasswordResetRequest{Token: raw, NewPassword: "Battery-Staple-7"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody = "{\"errorCode\":400,\"message\":\"" + schemas.ErrResetTokenInvalid.Error() + "\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+}
+
+func TestTwoFactorHandlers(t *testing.T) {
+	issuer := newIssuer(t)
+	hasher := newHasher(t)
+	userRepo := newUserRepository()
+	credentialRepo := newCredentialRepository(t, hasher)
+	twoFactorRepo := &mockTwoFactorRepository{users: userRepo, codes: map[string]*schemas.RecoveryCode{}}
+	handler := NewAuthHandler(issuer, userRepo, credentialRepo, twoFactorRepo, hasher, notify.NewMemory(), testSettings())
+	router := gin.Default()
+	handler.RegisterRoutes(router, "/api")
+
+	now := time.Now()
+	enrollment := TwoFactorEnrollment{}
+	code := func(at time.Time) string {
+		c, err := totp.Code(enrollment.Secret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	recoveryCodes := RecoveryCodes{}
+
+	t.Run("handle verify should return 409 before enrollment", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/verify", bytes.NewBufferString(`{"code":"123456"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"errorCode\":409,\"message\":\"two-factor authentication is not enabled\"}"
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle enroll should return a secret for authenticator apps", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/enroll", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		decodeData(t, w, &enrollment)
+		assert.Equal(t, totp.URI("accounts", "ana@test.com", enrollment.Secret), enrollment.URI)
+		expectedResponseBody := "{\"data\":" + jsonToString(enrollment) + "," +
+			"\"message\":\"operation from handler: enroll-two-factor successfull\"}"
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle confirm should return 403 for a wrong code", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/enroll/confirm", bytes.NewBufferString(jsonToString(ConfirmTwoFactorRequest{Code: "000000"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"invalid second factor\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle confirm should enable two-factor and return hashed recovery codes", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/enroll/confirm", bytes.NewBufferString(jsonToString(ConfirmTwoFactorRequest{Code: code(now)})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		decodeData(t, w, &recoveryCodes)
+		expectedResponseBody := "{\"data\":" + jsonToString(recoveryCodes) + "," +
+			"\"message\":\"operation from handler: confirm-two-factor successfull\"}"
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.Len(t, recoveryCodes.RecoveryCodes, recoveryCodeCount)
+		assert.Regexp(t, `^[A-Z2-7]{4}(-[A-Z2-7]{4}){3}$`, recoveryCodes.RecoveryCodes[0])
+		for _, stored := range twoFactorRepo.codes {
+			assert.NotContains(t, recoveryCodes.RecoveryCodes, stored.CodeHash, "recovery codes are stored hashed")
+		}
+	})
+
+	t.Run("handle enroll should return 409 once enabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/enroll", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"errorCode\":409,\"message\":\"" + schemas.ErrTwoFactorEnabled.Error() + "\"}"
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle verify should step up once per code", func(t *testing.T) {
+		next := code(now.Add(totp.Period))
+		for _, expected := range []struct {
+			code   string
+			status int
+		}{
+			{code(now), http.StatusForbidden},
+			{next, http.StatusOK},
+			{next, http.StatusForbidden},
+		} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/auth/2fa/verify", bytes.NewBufferString(jsonToString(VerifySecondFactorRequest{Code: expected.code})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, asCaller(req, 1))
+
+			assert.Equal(t, expected.status, w.Code)
+			if w.Code != http.StatusOK {
+				assert.Equal(t, "{\"errorCode\":403,\"message\":\"invalid second factor\"}", w.Body.String())
+				continue
+			}
+			pair := token.Pair{}
+			decodeData(t, w, &pair)
+			claims, err := issuer.Verify(pair.AccessToken, token.TypeAccess)
+			assert.NoError(t, err)
+			assert.NotNil(t, claims.MFATime)
+			refreshed, err := issuer.Verify(pair.RefreshToken, token.TypeRefresh)
+			assert.NoError(t, err)
+			assert.Nil(t, refreshed.MFATime, "refresh tokens do not carry the second factor")
+		}
+	})
+
+	t.Run("handle verify should accept each recovery code once", func(t *testing.T) {
+		typed := strings.ToLower(strings.ReplaceAll(recoveryCodes.RecoveryCodes[0], "-", ""))
+		for _, expected := range []struct {
+			recoveryCode string
+			status       int
+		}{
+			{typed, http.StatusOK},
+			{recoveryCodes.RecoveryCodes[0], http.StatusForbidden},
+		} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/auth/2fa/verify", bytes.NewBufferString(jsonToString(VerifySecondFactorRequest{RecoveryCode: expected.recoveryCode})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, asCaller(req, 1))
+
+			assert.Equal(t, expected.status, w.Code)
+		}
+	})
+
+	t.Run("handle verify should return 400 for both kinds of code at once", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/verify", bytes.NewBufferString(jsonToString(VerifySecondFactorRequest{Code: "123456", RecoveryCode: recoveryCodes.RecoveryCodes[1]})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: exactly one of code or recoveryCode (type: string) is required\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle regenerate should return 401 without a fresh second factor", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/recovery-codes", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+
+		expectedResponseBody := "{\"errorCode\":401,\"message\":\"this operation requires a second factor verified in the last 5 minutes, enroll one first if you have not\"}"
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="insufficient_user_authentication"`)
+	})
+
+	t.Run("handle regenerate should void the earlier recovery codes", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/recovery-codes", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(asCaller(req, 1)))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		regenerated := RecoveryCodes{}
+		decodeData(t, w, &regenerated)
+		expectedResponseBody := "{\"data\":" + jsonToString(regenerated) + "," +
+			"\"message\":\"operation from handler: regenerate-recovery-codes successfull\"}"
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/api/v1/auth/2fa/verify", bytes.NewBufferString(jsonToString(VerifySecondFactorRequest{RecoveryCode: recoveryCodes.RecoveryCodes[1]})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+		assert.Equal(t, http.StatusForbidden, w.Code, "earlier codes are void")
+	})
+
+	t.Run("handle verify should return 423 after repeated wrong codes", func(t *testing.T) {
+		userRepo.users[1].TOTPFailedAttempts = 0
+		for i, sent := range []string{"000001", "000002", "000003", code(now.Add(-totp.Period))} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/api/v1/auth/2fa/verify", bytes.NewBufferString(jsonToString(VerifySecondFactorRequest{Code: sent})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, asCaller(req, 1))
+
+			if i < 2 {
+				assert.Equal(t, http.StatusForbidden, w.Code)
+				continue
+			}
+			expectedResponseBody := "{\"errorCode\":423,\"message\":\"too many invalid second factor codes, try again later\"}"
+			assert.Equal(t, http.StatusLocked, w.Code)
+			assert.Equal(t, expectedResponseBody, w.Body.String())
+		}
+		assert.Equal(t, 0, credentialRepo.credentials[1].FailedLogins, "wrong codes do not lock logins")
+	})
+
+	t.Run("handle verify should return 423 for users without a password too", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "/api/v1/auth/2fa/enroll", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 2))
+		own := TwoFactorEnrollment{}
+		decodeData(t, w, &own)
+		valid, err := totp.Code(own.Secret, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("POST", "/api/v1/auth/2fa/enroll/confirm", bytes.NewBufferString(jsonToString(ConfirmTwoFactorRequest{Code: valid})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 2))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		for _, sent := range []string{"000001", "000002", "000003"} {
+			w = httptest.NewRecorder()
+			req, err = http.NewRequest("POST", "/api/v1/auth/2fa/verify", bytes.NewBufferString(jsonToString(VerifySecondFactorRequest{Code: sent})))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, asCaller(req, 2))
+		}
+
+		expectedResponseBody := "{\"errorCode\":423,\"message\":\"too many invalid second factor codes, try again later\"}"
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+		assert.Equal(t, "900", w.Header().Get("Retry-After"))
+		assert.NotContains(t, credentialRepo.credentials, uint(2))
+	})
+}
+
+func asCaller(req *http.Request, userID uint) *http.Request {
+	return req.WithContext(services.WithCaller(req.Context(), userID))
+}
+
+// steppedUp marks req as coming from a caller who just verified a second
+// factor.
+func steppedUp(req *http.Request) *http.Request {
+	return req.WithContext(services.WithSecondFactor(req.Context(), time.Now()))
+}
+
+type mockUserRepository struct {
+	schemas.UserRepository
+	users map[uint]*schemas.User
+}
+
+func (m *mockUserRepository) FindById(ctx context.Context, id string) (*schemas.User, error) {
+	for _, user := range m.users {
+		if id == strconv.FormatUint(uint64(user.ID), 10) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *mockUserRepository) FindByEmail(ctx context.Context, email string) (*schemas.User, error) {
+	for _, user := range m.users {
+		if strings.EqualFold(email, user.Email) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// mockCredentialRepository keeps credentials and reset tokens in memory,
+// with the semantics of the SQL repository.
+type mockCredentialRepository struct {
+	credentials map[uint]*schemas.Credential
+	resets      map[string]*schemas.PasswordReset
+}
+
+func (m *mockCredentialRepository) FindByUser(ctx context.Context, userID uint) (*schemas.Credential, error) {
+	credential, ok := m.credentials[userID]
+	if !ok {
+		return nil, schemas.ErrCredentialNotFound
+	}
+	found := *credential
+	return &found, nil
+}
+
+func (m *mockCredentialRepository) SetPassword(ctx context.Context, userID uint, hash string) error {
+	now := time.Now()
+	m.credentials[userID] = &schemas.Credential{UserID: userID, PasswordHash: hash, PasswordChangedAt: now}
+	for _, reset := range m.resets {
+		if reset.UserID == userID && reset.UsedAt == nil {
+			reset.UsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockCredentialRepository) RecordFailedLogin(ctx context.Context, userID uint, maxFailures int, lockFor time.Duration) (*schemas.Credential, error) {
+	credential, ok := m.credentials[userID]
+	if !ok {
+		return nil, schemas.ErrCredentialNotFound
+	}
+	credential.FailedLogins++
+	if credential.FailedLogins >= maxFailures {
+		until := time.Now().Add(lockFor)
+		credential.FailedLogins, credential.LockedUntil = 0, &until
+	}
+	return m.FindByUser(ctx, userID)
+}
+
+func (m *mockCredentialRepository) RecordLogin(ctx context.Context, userID uint, rehash string) error {
+	credential := m.credentials[userID]
+	now := time.Now()
+	credential.FailedLogins, credential.LockedUntil, credential.LastLoginAt = 0, nil, &now
+	if rehash != "" {
+		credential.PasswordHash = rehash
+	}
+	return nil
+}
+
+func (m *mockCredentialRepository) CreateReset(ctx context.Context, reset *schemas.PasswordReset) error {
+	m.resets[reset.TokenHash] = reset
+	return nil
+}
+
+func (m *mockCredentialRepository) FindReset(ctx context.Context, tokenHash string) (*schemas.PasswordReset, error) {
+	reset, ok := m.resets[tokenHash]
+	if !ok || reset.UsedAt != nil || !time.Now().Before(reset.ExpiresAt) {
+		return nil, schemas.ErrResetTokenInvalid
+	}
+	return reset, nil
+}
+
+func (m *mockCredentialRepository) ResetPassword(ctx context.Context, tokenHash, hash string) (uint, error) {
+	reset, err := m.FindReset(ctx, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return reset.UserID, m.SetPassword(ctx, reset.UserID, hash)
+}
+
+// mockTwoFactorRepository updates the users of a mockUserRepository, with
+// the semantics of the SQL repository.
+type mockTwoFactorRepository struct {
+	users *mockUserRepository
+	codes map[string]*schemas.RecoveryCode
+}
+
+func (m *mockTwoFactorRepository) BeginEnrollment(ctx context.Context, userID uint, secret string) error {
+	user := m.users.users[userID]
+	if user.TwoFactorEnabled() {
+		return schemas.ErrTwoFactorEnabled
+	}
+	user.TOTPSecret = secret
+	return nil
+}
+
+func (m *mockTwoFactorRepository) CompleteEnrollment(ctx context.Context, userID uint, step int64, codeHashes []string) error {
+	user := m.users.users[userID]
+	if user.TwoFactorEnabled() || user.TOTPSecret == "" {
+		return schemas.ErrTwoFactorNotPending
+	}
+	now := time.Now()
+	user.TOTPEnabledAt, user.TOTPLastStep, user.TOTPFailedAttempts = &now, step, 0
+	return m.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+}
+
+func (m *mockTwoFactorRepository) UseStep(ctx context.Context, userID uint, step int64) error {
+	user := m.users.users[userID]
+	if user.TOTPLastStep >= step {
+		return schemas.ErrCodeReused
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+func (m *mockTwoFactorRepository) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) error {
+	code, ok := m.codes[codeHash]
+	if !ok || code.UserID != userID || code.UsedAt != nil {
+		return schemas.ErrRecoveryCodeInvalid
+	}
+	now := time.Now()
+	code.UsedAt = &now
+	return nil
+}
+
+func (m *mockTwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error {
+	for hash, code := range m.codes {
+		if code.UserID == userID {
+			delete(m.codes, hash)
+		}
+	}
+	for _, hash := range codeHashes {
+		m.codes[hash] = &schemas.RecoveryCode{CodeHash: hash, UserID: userID}
+	}
+	return nil
+}
+
+func (m *mockTwoFactorRepository) RecordFailedCode(ctx context.Context, userID uint, maxFailures int, lockFor time.Duration) (*schemas.User, error) {
+	user := m.users.users[userID]
+	user.TOTPFailedAttempts++
+	if user.TOTPFailedAttempts >= maxFailures {
+		until := time.Now().Add(lockFor)
+		user.TOTPFailedAttempts, user.TOTPLockedUntil = 0, &until
+	}
+	return m.users.FindById(ctx, strconv.FormatUint(uint64(userID), 10))
+}
+
+func (m *mockTwoFactorRepository) RecordVerifiedCode(ctx context.Context, userID uint) error {
+	user := m.users.users[userID]
+	user.TOTPFailedAttempts, user.TOTPLockedUntil = 0, nil
+	return nil
+}
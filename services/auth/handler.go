@@ -0,0 +1,440 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/notify"
+	"github.com/jamadeu/accounts/password"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/token"
+	"gorm.io/gorm"
+)
+
+const errInvalidLogin = "invalid email or password"
+
+// Settings tune the password flows.
+type Settings struct {
+	// TOTPIssuer names the service in authenticator apps.
+	TOTPIssuer      string
+	Policy          password.Policy
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+	ResetTokenTTL   time.Duration
+}
+
+var DefaultSettings = Settings{
+	TOTPIssuer:      "accounts",
+	Policy:          password.DefaultPolicy,
+	MaxFailedLogins: 5,
+	LockoutDuration: 15 * time.Minute,
+	ResetTokenTTL:   30 * time.Minute,
+}
+
+type AuthHandler struct {
+	issuer      *token.Issuer
+	userRepo    schemas.UserRepository
+	credentials schemas.CredentialRepository
+	twoFactor   schemas.TwoFactorRepository
+	hasher      *password.Hasher
+	notifier    notify.Notifier
+	settings    Settings
+	deadlines   services.Deadlines
+	// decoy is verified when the user has no password, so that unknown
+	// emails take as long to reject as wrong passwords.
+	decoy string
+}
+
+func NewAuthHandler(issuer *token.Issuer, ur schemas.UserRepository, cr schemas.CredentialRepository, tf schemas.TwoFactorRepository, hasher *password.Hasher, notifier notify.Notifier, settings Settings) *AuthHandler {
+	decoy, _ := hasher.Hash("decoy")
+	return &AuthHandler{
+		issuer:      issuer,
+		userRepo:    ur,
+		credentials: cr,
+		twoFactor:   tf,
+		hasher:      hasher,
+		notifier:    notifier,
+		settings:    settings,
+		deadlines:   services.DefaultDeadlines,
+		decoy:       decoy,
+	}
+}
+
+func (h *AuthHandler) RegisterRoutes(router *gin.Engine, basePath string) {
+	read := services.Deadline(h.deadlines.Read)
+	write := services.Deadline(h.deadlines.Write)
+	authenticated := services.RequireSession()
+	router.GET("/.well-known/jwks.json", h.handleJWKS)
+	v1 := router.Group(basePath + "/v1/auth")
+	{
+		v1.POST("/login", write, h.handleLogin)
+		v1.POST("/refresh", read, h.handleRefresh)
+		v1.POST("/password", authenticated, write, h.handleChangePassword)
+		v1.POST("/password/reset", write, h.handleRequestPasswordReset)
+		v1.POST("/password/reset/confirm", write, h.handleConfirmPasswordReset)
+		v1.POST("/2fa/enroll", authenticated, write, h.handleEnrollTwoFactor)
+		v1.POST("/2fa/enroll/confirm", authenticated, write, h.handleConfirmTwoFactor)
+		v1.POST("/2fa/verify", authenticated, write, h.handleVerifySecondFactor)
+		v1.POST("/2fa/recovery-codes", authenticated, services.RequireSecondFactor(), write, h.handleRegenerateRecoveryCodes)
+	}
+}
+
+func (h *AuthHandler) handleJWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, h.issuer.Keys().JWKS())
+}
+
+// handleLogin exchanges an email and password for a token pair. Unknown
+// emails, users without a password and wrong passwords get the same answer.
+func (h *AuthHandler) handleLogin(ctx *gin.Context) {
+	request := LoginRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := ctx.Request.Context()
+	user, err := h.userRepo.FindByEmail(c, request.Email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			h.sendInternalError(ctx, "error finding user", err)
+			return
+		}
+		h.hasher.Verify(request.Password, h.decoy)
+		services.SendError(ctx, http.StatusUnauthorized, errInvalidLogin)
+		return
+	}
+	credential, ok := h.credential(ctx, user.ID)
+	if !ok {
+		return
+	}
+	if credential == nil {
+		h.hasher.Verify(request.Password, h.decoy)
+		services.SendError(ctx, http.StatusUnauthorized, errInvalidLogin)
+		return
+	}
+	rehash, ok := h.checkPassword(ctx, credential, request.Password, http.StatusUnauthorized, errInvalidLogin)
+	if !ok {
+		return
+	}
+	upgraded := ""
+	if rehash {
+		if upgraded, err = h.hasher.Hash(request.Password); err != nil {
+			slog.WarnContext(c, "error rehashing password", "error", err)
+		}
+	}
+	if err := h.credentials.RecordLogin(c, user.ID, upgraded); err != nil {
+		h.sendInternalError(ctx, "error recording login", err)
+		return
+	}
+	h.issue(ctx, "login", user)
+}
+
+// handleRefresh exchanges a refresh token for a new token pair, as long as
+// the user it was issued to still exists and has not changed or reset their
+// password since. The new access token carries the user's current role.
+func (h *AuthHandler) handleRefresh(ctx *gin.Context) {
+	request := RefreshRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	claims, err := h.issuer.Verify(request.RefreshToken, token.TypeRefresh)
+	if err != nil {
+		slog.InfoContext(ctx.Request.Context(), "rejected refresh token", "error", err)
+		services.SendError(ctx, http.StatusUnauthorized, "invalid or expired refresh token")
+		return
+	}
+	userID, _ := claims.UserID()
+	user, err := h.userRepo.FindById(ctx.Request.Context(), strconv.FormatUint(uint64(userID), 10))
+	if err != nil {
+		if services.SendContextError(ctx, err) {
+			return
+		}
+		services.SendError(ctx, http.StatusUnauthorized, "invalid or expired refresh token")
+		return
+	}
+	credential, ok := h.credential(ctx, user.ID)
+	if !ok {
+		return
+	}
+	// iat has a resolution of seconds
+	if credential != nil && claims.IssuedAt.Before(credential.PasswordChangedAt.Truncate(time.Second)) {
+		slog.InfoContext(ctx.Request.Context(), "rejected refresh token issued before a password change", "user_id", user.ID)
+		services.SendError(ctx, http.StatusUnauthorized, "invalid or expired refresh token")
+		return
+	}
+	h.issue(ctx, "refresh", user)
+}
+
+// handleChangePassword replaces the caller's password, given the current
+// one. Wrong current passwords count towards the lockout like failed logins.
+func (h *AuthHandler) handleChangePassword(ctx *gin.Context) {
+	request := ChangePasswordRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := ctx.Request.Context()
+	user, ok := h.caller(ctx)
+	if !ok {
+		return
+	}
+	credential, ok := h.credential(ctx, user.ID)
+	if !ok {
+		return
+	}
+	if credential == nil {
+		services.SendError(ctx, http.StatusConflict, "user has no password, request a password reset")
+		return
+	}
+	if _, ok := h.checkPassword(ctx, credential, request.CurrentPassword, http.StatusForbidden, "current password is incorrect"); !ok {
+		return
+	}
+	if request.NewPassword == request.CurrentPassword {
+		services.SendError(ctx, http.StatusUnprocessableEntity, "the new password must differ from the current one")
+		return
+	}
+	if !h.setPassword(ctx, user, request.NewPassword, func(hash string) error {
+		return h.credentials.SetPassword(c, user.ID, hash)
+	}) {
+		return
+	}
+	services.SendSuccess(ctx, "change-password", nil)
+}
+
+// handleRequestPasswordReset sends a single use reset token to the user
+// with the email. The answer is the same whether or not the email is known.
+func (h *AuthHandler) handleRequestPasswordReset(ctx *gin.Context) {
+	request := PasswordResetRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := ctx.Request.Context()
+	user, err := h.userRepo.FindByEmail(c, request.Email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		h.sendInternalError(ctx, "error finding user", err)
+		return
+	}
+	if err == nil {
+		raw, err := newResetToken()
+		if err != nil {
+			h.sendInternalError(ctx, "error creating reset token", err)
+			return
+		}
+		reset := schemas.PasswordReset{
+			TokenHash: hashResetToken(raw),
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(h.settings.ResetTokenTTL),
+		}
+		if err := h.credentials.CreateReset(c, &reset); err != nil {
+			h.sendInternalError(ctx, "error creating reset token", err)
+			return
+		}
+		err = h.notifier.Notify(c, notify.Message{
+			To:       user.Email,
+			Template: notify.TemplatePasswordReset,
+			Params: map[string]string{
+				"name":      user.Name,
+				"token":     raw,
+				"expiresAt": reset.ExpiresAt.Format(time.RFC3339),
+			},
+		})
+		if err != nil {
+			slog.ErrorContext(c, "error sending password reset", "user_id", user.ID, "error", err)
+		}
+	}
+	services.SendSuccess(ctx, "request-password-reset", nil)
+}
+
+// handleConfirmPasswordReset sets a new password with a reset token,
+// which also lifts any lockout.
+func (h *AuthHandler) handleConfirmPasswordReset(ctx *gin.Context) {
+	request := ConfirmPasswordResetRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := ctx.Request.Context()
+	tokenHash := hashResetToken(request.Token)
+	reset, err := h.credentials.FindReset(c, tokenHash)
+	if err != nil {
+		h.sendResetError(ctx, err)
+		return
+	}
+	user, err := h.userRepo.FindById(c, strconv.FormatUint(uint64(reset.UserID), 10))
+	if err != nil {
+		if services.SendContextError(ctx, err) {
+			return
+		}
+		services.SendError(ctx, http.StatusBadRequest, schemas.ErrResetTokenInvalid.Error())
+		return
+	}
+	if !h.setPassword(ctx, user, request.NewPassword, func(hash string) error {
+		_, err := h.credentials.ResetPassword(c, tokenHash, hash)
+		return err
+	}) {
+		return
+	}
+	services.SendSuccess(ctx, "reset-password", nil)
+}
+
+// credential loads the user's credential, which is nil when they have no
+// password. It reports false once an error response has been written.
+func (h *AuthHandler) credential(ctx *gin.Context, userID uint) (*schemas.Credential, bool) {
+	credential, err := h.credentials.FindByUser(ctx.Request.Context(), userID)
+	if errors.Is(err, schemas.ErrCredentialNotFound) {
+		return nil, true
+	}
+	if err != nil {
+		h.sendInternalError(ctx, "error finding credential", err)
+		return nil, false
+	}
+	return credential, true
+}
+
+// checkPassword verifies password against a credential that is not locked
+// out, answering status and msg when it is wrong. It reports whether the
+// stored hash should be upgraded.
+func (h *AuthHandler) checkPassword(ctx *gin.Context, credential *schemas.Credential, pwd string, status int, msg string) (bool, bool) {
+	if h.locked(ctx, credential) {
+		return false, false
+	}
+	match, rehash, err := h.hasher.Verify(pwd, credential.PasswordHash)
+	if err != nil {
+		h.sendInternalError(ctx, "error verifying password", err)
+		return false, false
+	}
+	if !match {
+		h.fail(ctx, credential, status, msg)
+		return false, false
+	}
+	return rehash, true
+}
+
+// locked answers 423 and reports true when the credential is locked out.
+func (h *AuthHandler) locked(ctx *gin.Context, credential *schemas.Credential) bool {
+	if !credential.Locked(time.Now()) {
+		return false
+	}
+	h.sendLocked(ctx, credential)
+	return true
+}
+
+// fail counts a wrong password against the credential and answers
+// status and msg, or 423 when the failure reached the limit and locked it.
+func (h *AuthHandler) fail(ctx *gin.Context, credential *schemas.Credential, status int, msg string) {
+	c := ctx.Request.Context()
+	credential, err := h.credentials.RecordFailedLogin(c, credential.UserID, h.settings.MaxFailedLogins, h.settings.LockoutDuration)
+	if err != nil {
+		h.sendInternalError(ctx, "error recording failed login", err)
+		return
+	}
+	if credential.Locked(time.Now()) {
+		slog.WarnContext(c, "locked out after failed logins", "user_id", credential.UserID)
+		h.sendLocked(ctx, credential)
+		return
+	}
+	services.SendError(ctx, status, msg)
+}
+
+// setPassword checks the new password against the policy, hashes it, hands
+// the hash to store and tells the user their password changed. It reports
+// false once an error response has been written.
+func (h *AuthHandler) setPassword(ctx *gin.Context, user *schemas.User, pwd string, store func(hash string) error) bool {
+	c := ctx.Request.Context()
+	if err := h.settings.Policy.Check(pwd, user.Email, user.Document); err != nil {
+		services.SendError(ctx, http.StatusUnprocessableEntity, err.Error())
+		return false
+	}
+	hash, err := h.hasher.Hash(pwd)
+	if err != nil {
+		h.sendInternalError(ctx, "error hashing password", err)
+		return false
+	}
+	if err := store(hash); err != nil {
+		h.sendResetError(ctx, err)
+		return false
+	}
+	err = h.notifier.Notify(c, notify.Message{
+		To:       user.Email,
+		Template: notify.TemplatePasswordChanged,
+		Params:   map[string]string{"name": user.Name},
+	})
+	if err != nil {
+		slog.ErrorContext(c, "error sending password change notice", "user_id", user.ID, "error", err)
+	}
+	return true
+}
+
+func (h *AuthHandler) issue(ctx *gin.Context, op string, user *schemas.User) {
+	pair, err := h.issuer.Issue(user.ID, user.Role)
+	if err != nil {
+		h.sendInternalError(ctx, "error issuing tokens", err)
+		return
+	}
+	services.SendSuccess(ctx, op, pair)
+}
+
+func (h *AuthHandler) sendLocked(ctx *gin.Context, credential *schemas.Credential) {
+	sendRetryLater(ctx, *credential.LockedUntil, "too many failed logins, try again later")
+}
+
+// sendRetryLater answers 423 with msg, telling the client to retry at until.
+func sendRetryLater(ctx *gin.Context, until time.Time, msg string) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	services.SendError(ctx, http.StatusLocked, msg)
+}
+
+func (h *AuthHandler) sendResetError(ctx *gin.Context, err error) {
+	if errors.Is(err, schemas.ErrResetTokenInvalid) {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.sendInternalError(ctx, "error setting password", err)
+}
+
+func (h *AuthHandler) sendInternalError(ctx *gin.Context, msg string, err error) {
+	if services.SendContextError(ctx, err) {
+		return
+	}
+	slog.ErrorContext(ctx.Request.Context(), msg, "error", err)
+	services.SendError(ctx, http.StatusInternalServerError, msg)
+}
+
+// newResetToken returns 256 random bits, URL safe so the token can be put
+// in a link.
+func newResetToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashResetToken is the form reset tokens are stored and looked up in.
+func hashResetToken(raw string) string {
+	return digest(raw)
+}
+
+// digest hashes the random tokens and codes the service hands out. They
+// carry at least 80 bits of entropy, so an unsalted fast hash is enough.
+func digest(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
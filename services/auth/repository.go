@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jamadeu/accounts/schemas"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CredentialRepository struct {
+	db *gorm.DB
+}
+
+func NewCredentialRepository(db *gorm.DB) *CredentialRepository {
+	return &CredentialRepository{db: db}
+}
+
+func (r *CredentialRepository) FindByUser(ctx context.Context, userID uint) (*schemas.Credential, error) {
+	credential := schemas.Credential{}
+	err := r.db.WithContext(ctx).First(&credential, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, schemas.ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func (r *CredentialRepository) SetPassword(ctx context.Context, userID uint, hash string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return setPassword(tx, userID, hash, time.Now())
+	})
+}
+
+// setPassword upserts the credential and voids the reset tokens still
+// pending, which were requested for the previous password.
+func setPassword(tx *gorm.DB, userID uint, hash string, now time.Time) error {
+	credential := schemas.Credential{UserID: userID, PasswordHash: hash, PasswordChangedAt: now}
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"password_hash":       hash,
+			"failed_logins":       0,
+			"locked_until":        nil,
+			"password_changed_at": now,
+			"updated_at":          now,
+		}),
+	}).Create(&credential).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&schemas.PasswordReset{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", now).Error
+}
+
+func (r *CredentialRepository) RecordFailedLogin(ctx context.Context, userID uint, maxFailures int, lockFor time.Duration) (*schemas.Credential, error) {
+	credential := schemas.Credential{}
+	// Both assignments read the row as it was before the update, so
+	// concurrent failures are counted once each
+	result := r.db.WithContext(ctx).Model(&credential).Clauses(clause.Returning{}).
+		Where("user_id = ?", userID).
+		Updates(map[string]interface{}{
+			"failed_logins": gorm.Expr("CASE WHEN failed_logins + 1 >= ? THEN 0 ELSE failed_logins + 1 END", maxFailures),
+			"locked_until":  gorm.Expr("CASE WHEN failed_logins + 1 >= ? THEN ? ELSE locked_until END", maxFailures, time.Now().Add(lockFor)),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, schemas.ErrCredentialNotFound
+	}
+	return &credential, nil
+}
+
+func (r *CredentialRepository) RecordLogin(ctx context.Context, userID uint, rehash string) error {
+	updates := map[string]interface{}{
+		"failed_logins": 0,
+		"locked_until":  nil,
+		"last_login_at": time.Now(),
+	}
+	if rehash != "" {
+		updates["password_hash"] = rehash
+	}
+	return r.db.WithContext(ctx).Model(&schemas.Credential{}).Where("user_id = ?", userID).Updates(updates).Error
+}
+
+func (r *CredentialRepository) CreateReset(ctx context.Context, reset *schemas.PasswordReset) error {
+	return r.db.WithContext(ctx).Create(reset).Error
+}
+
+func (r *CredentialRepository) FindReset(ctx context.Context, tokenHash string) (*schemas.PasswordReset, error) {
+	reset := schemas.PasswordReset{}
+	err := r.db.WithContext(ctx).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).
+		First(&reset).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, schemas.ErrResetTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reset, nil
+}
+
+func (r *CredentialRepository) ResetPassword(ctx context.Context, tokenHash, hash string) (uint, error) {
+	reset := schemas.PasswordReset{}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&reset).Clauses(clause.Returning{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return schemas.ErrResetTokenInvalid
+		}
+		return setPassword(tx, reset.UserID, hash, now)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return reset.UserID, nil
+}
+
+type TwoFactorRepository struct {
+	db *gorm.DB
+}
+
+func NewTwoFactorRepository(db *gorm.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{db: db}
+}
+
+func (r *TwoFactorRepository) BeginEnrollment(ctx context.Context, userID uint, secret string) error {
+	result := r.db.WithContext(ctx).Model(&schemas.User{}).
+		Where("id = ? AND totp_enabled_at IS NULL", userID).
+		Update("totp_secret", secret)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return schemas.ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+func (r *TwoFactorRepository) CompleteEnrollment(ctx context.Context, userID uint, step int64, codeHashes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&schemas.User{}).
+			Where("id = ? AND totp_enabled_at IS NULL AND totp_secret IS NOT NULL", userID).
+			Updates(map[string]interface{}{"totp_enabled_at": time.Now(), "totp_last_step": step, "totp_failed_attempts": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return schemas.ErrTwoFactorNotPending
+		}
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+func (r *TwoFactorRepository) UseStep(ctx context.Context, userID uint, step int64) error {
+	// The comparison and the update are one statement, so two requests
+	// racing with the same code cannot both succeed
+	result := r.db.WithContext(ctx).Model(&schemas.User{}).
+		Where("id = ? AND totp_last_step < ?", userID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return schemas.ErrCodeReused
+	}
+	return nil
+}
+
+func (r *TwoFactorRepository) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) error {
+	result := r.db.WithContext(ctx).Model(&schemas.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return schemas.ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+func (r *TwoFactorRepository) RecordFailedCode(ctx context.Context, userID uint, maxFailures int, lockFor time.Duration) (*schemas.User, error) {
+	user := schemas.User{}
+	// As in RecordFailedLogin, both assignments read the row as it was
+	// before the update
+	result := r.db.WithContext(ctx).Model(&user).Clauses(clause.Returning{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"totp_failed_attempts": gorm.Expr("CASE WHEN totp_failed_attempts + 1 >= ? THEN 0 ELSE totp_failed_attempts + 1 END", maxFailures),
+			"totp_locked_until":    gorm.Expr("CASE WHEN totp_failed_attempts + 1 >= ? THEN ? ELSE totp_locked_until END", maxFailures, time.Now().Add(lockFor)),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &user, nil
+}
+
+func (r *TwoFactorRepository) RecordVerifiedCode(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Model(&schemas.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{"totp_failed_attempts": 0, "totp_locked_until": nil}).Error
+}
+
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&schemas.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]schemas.RecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, schemas.RecoveryCode{CodeHash: hash, UserID: userID})
+	}
+	return tx.Create(&codes).Error
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"net/mail"
+)
+
+func errParamIsRequired(name, typ string) error {
+	return fmt.Errorf("param: %s (type: %s) is required", name, typ)
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (r *RefreshRequest) Validate() error {
+	if r.RefreshToken == "" {
+		return errParamIsRequired("refreshToken", "string")
+	}
+	return nil
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (r *LoginRequest) Validate() error {
+	if r.Email == "" {
+		return errParamIsRequired("email", "string")
+	}
+	if r.Password == "" {
+		return errParamIsRequired("password", "string")
+	}
+	return nil
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+func (r *ChangePasswordRequest) Validate() error {
+	if r.CurrentPassword == "" {
+		return errParamIsRequired("currentPassword", "string")
+	}
+	if r.NewPassword == "" {
+		return errParamIsRequired("newPassword", "string")
+	}
+	return nil
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+func (r *PasswordResetRequest) Validate() error {
+	if _, err := mail.ParseAddress(r.Email); err != nil {
+		return errParamIsRequired("email", "string")
+	}
+	return nil
+}
+
+type ConfirmPasswordResetRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+func (r *ConfirmPasswordResetRequest) Validate() error {
+	if r.Token == "" {
+		return errParamIsRequired("token", "string")
+	}
+	if r.NewPassword == "" {
+		return errParamIsRequired("newPassword", "string")
+	}
+	return nil
+}
+
+type ConfirmTwoFactorRequest struct {
+	Code string `json:"code"`
+}
+
+func (r *ConfirmTwoFactorRequest) Validate() error {
+	if r.Code == "" {
+		return errParamIsRequired("code", "string")
+	}
+	return nil
+}
+
+// VerifySecondFactorRequest carries either a code from the authenticator
+// app or one of the recovery codes.
+type VerifySecondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+func (r *VerifySecondFactorRequest) Validate() error {
+	if (r.Code == "") == (r.RecoveryCode == "") {
+		return fmt.Errorf("param: exactly one of code or recoveryCode (type: string) is required")
+	}
+	return nil
+}
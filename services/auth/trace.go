@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/tracing"
+)
+
+// tracedCredentialRepository wraps a CredentialRepository with one span per call,
+// carrying the user ID involved. Hashes and tokens are never recorded.
+type tracedCredentialRepository struct {
+	next schemas.CredentialRepository
+}
+
+func NewTracedCredentialRepository(next schemas.CredentialRepository) schemas.CredentialRepository {
+	return &tracedCredentialRepository{next: next}
+}
+
+func (r *tracedCredentialRepository) FindByUser(ctx context.Context, userID uint) (_ *schemas.Credential, err error) {
+	ctx, span := tracing.Start(ctx, "CredentialRepository.FindByUser", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.FindByUser(ctx, userID)
+}
+
+func (r *tracedCredentialRepository) SetPassword(ctx context.Context, userID uint, hash string) (err error) {
+	ctx, span := tracing.Start(ctx, "CredentialRepository.SetPassword", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.SetPassword(ctx, userID, hash)
+}
+
+func (r *tracedCredentialRepository) RecordFailedLogin(ctx context.Context, userID uint, maxFailures int, lockFor time.Duration) (_ *schemas.Credential, err error) {
+	ctx, span := tracing.Start(ctx, "CredentialRepository.RecordFailedLogin", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.RecordFailedLogin(ctx, userID, maxFailures, lockFor)
+}
+
+func (r *tracedCredentialRepository) RecordLogin(ctx context.Context, userID uint, rehash string) (err error) {
+	ctx, span := tracing.Start(ctx, "CredentialRepository.RecordLogin", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.RecordLogin(ctx, userID, rehash)
+}
+
+func (r *tracedCredentialRepository) CreateReset(ctx context.Context, reset *schemas.PasswordReset) (err error) {
+	ctx, span := tracing.Start(ctx, "CredentialRepository.CreateReset", tracing.UserID(reset.UserID))
+	defer tracing.End(span, &err)
+	return r.next.CreateReset(ctx, reset)
+}
+
+func (r *tracedCredentialRepository) FindReset(ctx context.Context, tokenHash string) (reset *schemas.PasswordReset, err error) {
+	ctx, span := tracing.Start(ctx, "CredentialRepository.FindReset")
+	defer func() {
+		if err == nil {
+			span.SetAttributes(tracing.UserID(reset.UserID))
+		}
+		tracing.End(span, &err)
+	}()
+	return r.next.FindReset(ctx, tokenHash)
+}
+
+func (r *tracedCredentialRepository) ResetPassword(ctx context.Context, tokenHash, hash string) (userID uint, err error) {
+	ctx, span := tracing.Start(ctx, "CredentialRepository.ResetPassword")
+	defer func() {
+		if err == nil {
+			span.SetAttributes(tracing.UserID(userID))
+		}
+		tracing.End(span, &err)
+	}()
+	return r.next.ResetPassword(ctx, tokenHash, hash)
+}
+
+// tracedTwoFactorRepository wraps a TwoFactorRepository like
+// tracedCredentialRepository. Secrets and codes are never recorded.
+type tracedTwoFactorRepository struct {
+	next schemas.TwoFactorRepository
+}
+
+func NewTracedTwoFactorRepository(next schemas.TwoFactorRepository) schemas.TwoFactorRepository {
+	return &tracedTwoFactorRepository{next: next}
+}
+
+func (r *tracedTwoFactorRepository) BeginEnrollment(ctx context.Context, userID uint, secret string) (err error) {
+	ctx, span := tracing.Start(ctx, "TwoFactorRepository.BeginEnrollment", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.BeginEnrollment(ctx, userID, secret)
+}
+
+func (r *tracedTwoFactorRepository) CompleteEnrollment(ctx context.Context, userID uint, step int64, codeHashes []string) (err error) {
+	ctx, span := tracing.Start(ctx, "TwoFactorRepository.CompleteEnrollment", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.CompleteEnrollment(ctx, userID, step, codeHashes)
+}
+
+func (r *tracedTwoFactorRepository) UseStep(ctx context.Context, userID uint, step int64) (err error) {
+	ctx, span := tracing.Start(ctx, "TwoFactorRepository.UseStep", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.UseStep(ctx, userID, step)
+}
+
+func (r *tracedTwoFactorRepository) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (err error) {
+	ctx, span := tracing.Start(ctx, "TwoFactorRepository.UseRecoveryCode", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.UseRecoveryCode(ctx, userID, codeHash)
+}
+
+func (r *tracedTwoFactorRepository) RecordFailedCode(ctx context.Context, userID uint, maxFailures int, lockFor time.Duration) (_ *schemas.User, err error) {
+	ctx, span := tracing.Start(ctx, "TwoFactorRepository.RecordFailedCode", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.RecordFailedCode(ctx, userID, maxFailures, lockFor)
+}
+
+func (r *tracedTwoFactorRepository) RecordVerifiedCode(ctx context.Context, userID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "TwoFactorRepository.RecordVerifiedCode", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.RecordVerifiedCode(ctx, userID)
+}
+
+func (r *tracedTwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) (err error) {
+	ctx, span := tracing.Start(ctx, "TwoFactorRepository.ReplaceRecoveryCodes", tracing.UserID(userID))
+	defer tracing.End(span, &err)
+	return r.next.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+}
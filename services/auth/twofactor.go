@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/totp"
+)
+
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeSize is 80 bits, written as four groups of four base32
+	// characters.
+	recoveryCodeSize = 10
+
+	errInvalidSecondFactor = "invalid second factor"
+	errTwoFactorLocked     = "too many invalid second factor codes, try again later"
+)
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodes are shown once, when they are generated.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// handleEnrollTwoFactor creates a TOTP secret for the caller, to be added to
+// an authenticator app. It only takes effect once confirmed with a code.
+func (h *AuthHandler) handleEnrollTwoFactor(ctx *gin.Context) {
+	user, ok := h.caller(ctx)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled() {
+		services.SendError(ctx, http.StatusConflict, schemas.ErrTwoFactorEnabled.Error())
+		return
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		h.sendInternalError(ctx, "error creating secret", err)
+		return
+	}
+	if err := h.twoFactor.BeginEnrollment(ctx.Request.Context(), user.ID, secret); err != nil {
+		if errors.Is(err, schemas.ErrTwoFactorEnabled) {
+			services.SendError(ctx, http.StatusConflict, err.Error())
+			return
+		}
+		h.sendInternalError(ctx, "error enrolling second factor", err)
+		return
+	}
+	services.SendSuccess(ctx, "enroll-two-factor", TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(h.settings.TOTPIssuer, user.Email, secret),
+	})
+}
+
+// handleConfirmTwoFactor enables the pending secret given a code it
+// generated, and answers with the recovery codes.
+func (h *AuthHandler) handleConfirmTwoFactor(ctx *gin.Context) {
+	request := ConfirmTwoFactorRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	user, ok := h.caller(ctx)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled() || user.TOTPSecret == "" {
+		services.SendError(ctx, http.StatusConflict, schemas.ErrTwoFactorNotPending.Error())
+		return
+	}
+	if h.twoFactorLocked(ctx, user) {
+		return
+	}
+	step, valid := totp.Validate(user.TOTPSecret, request.Code, time.Now())
+	if !valid {
+		h.failSecondFactor(ctx, user)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		h.sendInternalError(ctx, "error creating recovery codes", err)
+		return
+	}
+	if err := h.twoFactor.CompleteEnrollment(ctx.Request.Context(), user.ID, step, hashes); err != nil {
+		if errors.Is(err, schemas.ErrTwoFactorNotPending) {
+			services.SendError(ctx, http.StatusConflict, err.Error())
+			return
+		}
+		h.sendInternalError(ctx, "error enabling second factor", err)
+		return
+	}
+	services.SendSuccess(ctx, "confirm-two-factor", RecoveryCodes{RecoveryCodes: codes})
+}
+
+// handleVerifySecondFactor steps up the caller's session: given a TOTP code
+// or an unused recovery code, it answers with an access token recording the
+// second factor, which sensitive operations require. Wrong codes lock
+// verification as wrong passwords lock logins, with a count of their own so
+// that users without a password are limited too.
+func (h *AuthHandler) handleVerifySecondFactor(ctx *gin.Context) {
+	request := VerifySecondFactorRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		services.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := ctx.Request.Context()
+	user, ok := h.caller(ctx)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled() {
+		services.SendError(ctx, http.StatusConflict, "two-factor authentication is not enabled")
+		return
+	}
+	if h.twoFactorLocked(ctx, user) {
+		return
+	}
+	now := time.Now()
+	var err error
+	if request.Code != "" {
+		step, valid := totp.Validate(user.TOTPSecret, request.Code, now)
+		err = schemas.ErrCodeReused
+		if valid {
+			err = h.twoFactor.UseStep(c, user.ID, step)
+		}
+	} else {
+		err = h.twoFactor.UseRecoveryCode(c, user.ID, hashRecoveryCode(request.RecoveryCode))
+	}
+	switch {
+	case errors.Is(err, schemas.ErrCodeReused), errors.Is(err, schemas.ErrRecoveryCodeInvalid):
+		h.failSecondFactor(ctx, user)
+		return
+	case err != nil:
+		h.sendInternalError(ctx, "error verifying second factor", err)
+		return
+	}
+	if err := h.twoFactor.RecordVerifiedCode(c, user.ID); err != nil {
+		h.sendInternalError(ctx, "error recording second factor", err)
+		return
+	}
+	pair, err := h.issuer.IssueStepUp(user.ID, user.Role, now)
+	if err != nil {
+		h.sendInternalError(ctx, "error issuing tokens", err)
+		return
+	}
+	services.SendSuccess(ctx, "verify-second-factor", pair)
+}
+
+// handleRegenerateRecoveryCodes replaces all of the caller's recovery codes.
+func (h *AuthHandler) handleRegenerateRecoveryCodes(ctx *gin.Context) {
+	user, ok := h.caller(ctx)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled() {
+		services.SendError(ctx, http.StatusConflict, "two-factor authentication is not enabled")
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		h.sendInternalError(ctx, "error creating recovery codes", err)
+		return
+	}
+	if err := h.twoFactor.ReplaceRecoveryCodes(ctx.Request.Context(), user.ID, hashes); err != nil {
+		h.sendInternalError(ctx, "error replacing recovery codes", err)
+		return
+	}
+	services.SendSuccess(ctx, "regenerate-recovery-codes", RecoveryCodes{RecoveryCodes: codes})
+}
+
+// caller loads the user making the request. It reports false once an error
+// response has been written.
+func (h *AuthHandler) caller(ctx *gin.Context) (*schemas.User, bool) {
+	id, _ := services.Caller(ctx.Request.Context())
+	user, err := h.userRepo.FindById(ctx.Request.Context(), strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		if services.SendContextError(ctx, err) {
+			return nil, false
+		}
+		services.SendError(ctx, http.StatusNotFound, "user not found")
+		return nil, false
+	}
+	return user, true
+}
+
+// twoFactorLocked answers 423 and reports true when too many wrong codes
+// locked the user's second factor.
+func (h *AuthHandler) twoFactorLocked(ctx *gin.Context, user *schemas.User) bool {
+	if !user.TwoFactorLocked(time.Now()) {
+		return false
+	}
+	sendRetryLater(ctx, *user.TOTPLockedUntil, errTwoFactorLocked)
+	return true
+}
+
+// failSecondFactor counts a wrong code against the user and answers 403, or
+// 423 when the failure reached the limit and locked their second factor.
+func (h *AuthHandler) failSecondFactor(ctx *gin.Context, user *schemas.User) {
+	c := ctx.Request.Context()
+	user, err := h.twoFactor.RecordFailedCode(c, user.ID, h.settings.MaxFailedLogins, h.settings.LockoutDuration)
+	if err != nil {
+		h.sendInternalError(ctx, "error recording failed second factor", err)
+		return
+	}
+	if user.TwoFactorLocked(time.Now()) {
+		slog.WarnContext(c, "second factor locked after wrong codes", "user_id", user.ID)
+		sendRetryLater(ctx, *user.TOTPLockedUntil, errTwoFactorLocked)
+		return
+	}
+	services.SendError(ctx, http.StatusForbidden, errInvalidSecondFactor)
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		raw := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		s := encoding.EncodeToString(raw)
+		code := strings.Join([]string{s[0:4], s[4:8], s[8:12], s[12:16]}, "-")
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes, which users may type
+// differently from how the code was shown.
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	return digest(normalized)
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx identifying the user making the request.
+func WithCaller(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, callerKey{}, userID)
+}
+
+// Caller returns the user making the request, when the request identifies
+// one.
+func Caller(ctx context.Context) (uint, bool) {
+	id, ok := ctx.Value(callerKey{}).(uint)
+	return id, ok
+}
+
+// RequireCaller rejects requests that do not identify their user with 401.
+func RequireCaller() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := Caller(ctx.Request.Context()); !ok {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			SendError(ctx, http.StatusUnauthorized, "authentication required")
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
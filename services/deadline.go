@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusClientClosedRequest is the non-standard status recorded when the
+// client goes away before the response is written.
+const StatusClientClosedRequest = 499
+
+// Deadline bounds the request context of the routes it is attached to, so
+// the database queries they issue are cancelled once d has elapsed. The
+// context is cancelled as soon as the handler returns, so middleware working
+// after ctx.Next must not use it.
+func Deadline(d time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(ctx.Request.Context(), d)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(c)
+		ctx.Next()
+	}
+}
+
+// SendContextError answers with 504 when err comes from the request deadline
+// and 499 when the client cancelled the request. It reports whether err was
+// a context error and a response has been written.
+func SendContextError(ctx *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		SendError(ctx, http.StatusGatewayTimeout, "request timed out")
+	case errors.Is(err, context.Canceled):
+		SendError(ctx, StatusClientClosedRequest, "request cancelled")
+	default:
+		return false
+	}
+	return true
+}
+
+// Deadlines are the per-route limits applied by the handlers: Read for
+// lookups, Write for operations that lock rows and Export for full statement
+// downloads.
+type Deadlines struct {
+	Read   time.Duration
+	Write  time.Duration
+	Export time.Duration
+}
+
+var DefaultDeadlines = Deadlines{
+	Read:   5 * time.Second,
+	Write:  10 * time.Second,
+	Export: 30 * time.Second,
+}
@@ -0,0 +1,62 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/jamadeu/accounts/schemas"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IdempotencyRepository struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db}
+}
+
+func (r *IdempotencyRepository) Reserve(ctx context.Context, key *schemas.IdempotencyKey) (*schemas.IdempotencyKey, error) {
+	// An expired record no longer protects anything and must not block a
+	// new request reusing its key.
+	err := r.db.WithContext(ctx).Where("key = ? AND expires_at <= ?", key.Key, time.Now()).
+		Delete(&schemas.IdempotencyKey{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	existing := schemas.IdempotencyKey{}
+	if err := r.db.WithContext(ctx).First(&existing, "key = ?", key.Key).Error; err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (r *IdempotencyRepository) Complete(ctx context.Context, key string, statusCode int, body []byte) error {
+	return r.db.WithContext(ctx).Model(&schemas.IdempotencyKey{}).
+		Where("key = ?", key).
+		Updates(map[string]interface{}{
+			"completed":   true,
+			"status_code": statusCode,
+			"body":        body,
+		}).Error
+}
+
+func (r *IdempotencyRepository) Release(ctx context.Context, key string) error {
+	return r.db.WithContext(ctx).Where("key = ?", key).Delete(&schemas.IdempotencyKey{}).Error
+}
+
+func (r *IdempotencyRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&schemas.IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
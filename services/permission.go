@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/schemas"
+)
+
+// Permission is an operation a role may perform. Permissions without the
+// :any suffix only reach the caller's own user record and the accounts they
+// hold; the :any ones reach everyone's.
+type Permission string
+
+const (
+	PermUsersRead        Permission = "users:read"
+	PermUsersReadAny     Permission = "users:read:any"
+	PermUsersList        Permission = "users:list"
+	PermUsersWrite       Permission = "users:write"
+	PermRolesManage      Permission = "roles:manage"
+	PermAccountsRead     Permission = "accounts:read"
+	PermAccountsReadAny  Permission = "accounts:read:any"
+	PermAccountsWrite    Permission = "accounts:write"
+	PermTransfersWrite   Permission = "transfers:write"
+	PermStatementsExport Permission = "statements:export"
+	// PermDepositsWrite posts cash into any account, opening balances
+	// included. Nothing checks the cash exists, so customers never get it.
+	PermDepositsWrite Permission = "deposits:write"
+)
+
+// Permissions lists every permission, which are also the scopes API keys
+// may be given.
+var Permissions = []Permission{
+	PermUsersRead, PermUsersReadAny, PermUsersList, PermUsersWrite, PermRolesManage,
+	PermAccountsRead, PermAccountsReadAny, PermAccountsWrite, PermTransfersWrite, PermStatementsExport,
+	PermDepositsWrite,
+}
+
+// rolePermissions is the permission matrix. Staff may look customers up but
+// not move their money; only admins change roles and post cash.
+var rolePermissions = map[string][]Permission{
+	schemas.RoleCustomer: {
+		PermUsersRead, PermUsersWrite,
+		PermAccountsRead, PermAccountsWrite, PermTransfersWrite, PermStatementsExport,
+	},
+	schemas.RoleSupport: {
+		PermUsersRead, PermUsersWrite, PermUsersReadAny, PermUsersList,
+		PermAccountsRead, PermAccountsReadAny,
+	},
+	schemas.RoleCompliance: {
+		PermUsersRead, PermUsersWrite, PermUsersReadAny, PermUsersList,
+		PermAccountsRead, PermAccountsReadAny, PermStatementsExport,
+	},
+	schemas.RoleAdmin: {
+		PermUsersRead, PermUsersWrite, PermUsersReadAny, PermUsersList, PermRolesManage,
+		PermAccountsRead, PermAccountsReadAny, PermAccountsWrite, PermTransfersWrite, PermStatementsExport,
+		PermDepositsWrite,
+	},
+}
+
+// Granted reports whether the matrix grants p to role.
+func Granted(role string, p Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+type roleKey struct{}
+
+// WithRole returns a copy of ctx recording the role of the caller.
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// Role returns the role of the caller. Callers that were not given one are
+// customers, the least privileged role.
+func Role(ctx context.Context) string {
+	if role, ok := ctx.Value(roleKey{}).(string); ok && role != "" {
+		return role
+	}
+	return schemas.RoleCustomer
+}
+
+type scopesKey struct{}
+
+// WithScopes returns a copy of ctx limiting the caller to scopes, on top of
+// what their role grants. Requests authenticated with an API key carry the
+// scopes of the key.
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// Scopes returns the scopes the caller is limited to, when they are.
+func Scopes(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(scopesKey{}).([]string)
+	return scopes, ok
+}
+
+// Can reports whether the caller's role grants p and, when the caller is
+// limited to scopes, whether p is one of them.
+func Can(ctx context.Context, p Permission) bool {
+	return Granted(Role(ctx), p) && inScope(ctx, p)
+}
+
+func inScope(ctx context.Context, p Permission) bool {
+	scopes, ok := Scopes(ctx)
+	return !ok || slices.Contains(scopes, string(p))
+}
+
+// RequireSession rejects requests that do not identify their user with 401,
+// as RequireCaller does, and requests authenticated with an API key with
+// 403. It guards the routes that manage credentials, which a leaked key
+// must not reach.
+func RequireSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := Scopes(ctx.Request.Context()); ok {
+			SendError(ctx, http.StatusForbidden, "this operation is not available to API keys")
+			ctx.Abort()
+			return
+		}
+		RequireCaller()(ctx)
+	}
+}
+
+// Require declares the permission a route needs. It rejects anonymous
+// requests with 401, as RequireCaller does, and callers whose role or API
+// key lacks p with 403.
+func Require(p Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c := ctx.Request.Context()
+		if _, ok := Caller(c); !ok {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			SendError(ctx, http.StatusUnauthorized, "authentication required")
+			ctx.Abort()
+			return
+		}
+		if !Granted(Role(c), p) {
+			SendError(ctx, http.StatusForbidden, fmt.Sprintf("role %s lacks permission %s", Role(c), p))
+			ctx.Abort()
+			return
+		}
+		if !inScope(c, p) {
+			SendError(ctx, http.StatusForbidden, fmt.Sprintf("api key lacks scope %s", p))
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,44 @@
+// Package rbactest checks that routes enforce the permission matrix.
+package rbactest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/services"
+)
+
+// Rule names the roles that may reach a route.
+type Rule struct {
+	Method string
+	Path   string
+	Roles  []string
+}
+
+// AssertMatrix sends the request of every rule to router once per role, as
+// caller, and checks that the roles the rule names get past the permission
+// check and every other role is refused by it. Requests have no body, so
+// pick paths the handlers reject without side effects.
+func AssertMatrix(t *testing.T, router http.Handler, caller uint, rules ...Rule) {
+	t.Helper()
+	for _, rule := range rules {
+		for _, role := range schemas.Roles {
+			req, err := http.NewRequest(rule.Method, rule.Path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := services.WithRole(services.WithCaller(req.Context(), caller), role)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req.WithContext(ctx))
+
+			denied := w.Code == http.StatusForbidden && strings.Contains(w.Body.String(), "role "+role+" lacks permission")
+			if want := slices.Contains(rule.Roles, role); denied == want {
+				t.Errorf("%s %s as %s: allowed %t, want %t (got %d %s)", rule.Method, rule.Path, role, !denied, want, w.Code, w.Body.String())
+			}
+		}
+	}
+}
@@ -5,14 +5,23 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/logging"
 )
 
+// SendError writes an error body. The request ID, when the request carries
+// one, is echoed so clients can quote it in support requests.
 func SendError(ctx *gin.Context, code int, msg string) {
-	ctx.Header("Content-type", "application/json")
-	ctx.JSON(code, gin.H{
+	body := gin.H{
 		"message":   msg,
 		"errorCode": code,
-	})
+	}
+	if ctx.Request != nil {
+		if id := logging.RequestID(ctx.Request.Context()); id != "" {
+			body["requestId"] = id
+		}
+	}
+	ctx.Header("Content-type", "application/json")
+	ctx.JSON(code, body)
 }
 
 func SendSuccess(ctx *gin.Context, op string, data interface{}) {
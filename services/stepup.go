@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StepUpMaxAge is how long after verifying a second factor the caller may
+// perform sensitive operations without verifying it again.
+const StepUpMaxAge = 5 * time.Minute
+
+type secondFactorKey struct{}
+
+// WithSecondFactor returns a copy of ctx recording that the caller verified
+// a second factor at t.
+func WithSecondFactor(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, secondFactorKey{}, t)
+}
+
+// SecondFactorAt returns when the caller last verified a second factor, when
+// the request carries one.
+func SecondFactorAt(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(secondFactorKey{}).(time.Time)
+	return t, ok
+}
+
+// FreshSecondFactor reports whether the caller verified a second factor in
+// the last StepUpMaxAge. Otherwise it answers 401 with the RFC 9470
+// insufficient_user_authentication challenge, telling the client to step up
+// and retry.
+//
+// There is no fallback to a second factor other than TOTP: users must enroll
+// one before they may perform these operations, and the answer says so. API
+// keys can never step up, so they are answered 403 instead.
+func FreshSecondFactor(ctx *gin.Context) bool {
+	if t, ok := SecondFactorAt(ctx.Request.Context()); ok && time.Since(t) <= StepUpMaxAge {
+		return true
+	}
+	if _, ok := Scopes(ctx.Request.Context()); ok {
+		SendError(ctx, http.StatusForbidden, "this operation requires a second factor, which API keys cannot provide")
+		return false
+	}
+	ctx.Header("WWW-Authenticate", fmt.Sprintf(
+		`Bearer error="insufficient_user_authentication", error_description="A fresh second factor is required", max_age=%d`,
+		int(StepUpMaxAge.Seconds())))
+	SendError(ctx, http.StatusUnauthorized, fmt.Sprintf(
+		"this operation requires a second factor verified in the last %d minutes, enroll one first if you have not",
+		int(StepUpMaxAge.Minutes())))
+	return false
+}
+
+// RequireSecondFactor rejects requests without a fresh second factor, as
+// FreshSecondFactor does, for routes that always need one.
+func RequireSecondFactor() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !FreshSecondFactor(ctx) {
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,71 @@
+// Package uow runs several repositories inside one database transaction, so
+// that changes spanning users and accounts are committed or rolled back as a
+// single unit.
+package uow
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jamadeu/accounts/schemas"
+	"gorm.io/gorm"
+)
+
+// Factory builds the repositories of a unit of work on top of its
+// transaction.
+type Factory func(tx *gorm.DB) schemas.Repositories
+
+type Manager struct {
+	db           *gorm.DB
+	repositories Factory
+}
+
+func New(db *gorm.DB, repositories Factory) *Manager {
+	return &Manager{db: db, repositories: repositories}
+}
+
+func (m *Manager) WithinTransaction(ctx context.Context, fn func(ctx context.Context, repos schemas.Repositories) error) error {
+	hooks := &commitHooks{}
+	ctx = context.WithValue(ctx, hooksKey{}, hooks)
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(ctx, m.repositories(tx))
+	})
+	if err != nil {
+		return err
+	}
+	hooks.run()
+	return nil
+}
+
+type hooksKey struct{}
+
+type commitHooks struct {
+	mu sync.Mutex
+	fs []func()
+}
+
+func (h *commitHooks) add(f func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fs = append(h.fs, f)
+}
+
+func (h *commitHooks) run() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, f := range h.fs {
+		f()
+	}
+}
+
+// AfterCommit defers f until the unit of work carried by ctx commits, and
+// drops it on rollback. Outside a unit of work f runs immediately, so
+// repositories can report side effects such as metrics the same way whether
+// or not they are part of a larger transaction.
+func AfterCommit(ctx context.Context, f func()) {
+	if hooks, ok := ctx.Value(hooksKey{}).(*commitHooks); ok {
+		hooks.add(f)
+		return
+	}
+	f()
+}
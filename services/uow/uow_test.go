@@ -0,0 +1,128 @@
+package uow
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestAfterCommit(t *testing.T) {
+	t.Run("should run immediately outside a unit of work", func(t *testing.T) {
+		ran := false
+		AfterCommit(context.Background(), func() { ran = true })
+		assert.True(t, ran)
+	})
+
+	t.Run("should wait for the unit of work to commit", func(t *testing.T) {
+		hooks := &commitHooks{}
+		ctx := context.WithValue(context.Background(), hooksKey{}, hooks)
+		ran := 0
+		AfterCommit(ctx, func() { ran++ })
+		AfterCommit(ctx, func() { ran++ })
+		assert.Equal(t, 0, ran)
+
+		hooks.run()
+		assert.Equal(t, 2, ran)
+	})
+}
+
+func TestWithinTransaction(t *testing.T) {
+	conn := &txConn{}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(conn)}), &gorm.Config{DisableAutomaticPing: true})
+	require.NoError(t, err)
+	manager := New(db, func(tx *gorm.DB) schemas.Repositories { return schemas.Repositories{} })
+
+	t.Run("should commit and run hooks when fn succeeds", func(t *testing.T) {
+		conn.reset()
+		ran := false
+		err := manager.WithinTransaction(context.Background(), func(ctx context.Context, repos schemas.Repositories) error {
+			AfterCommit(ctx, func() { ran = true })
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"begin", "commit"}, conn.calls())
+		assert.True(t, ran)
+	})
+
+	t.Run("should roll back and drop hooks when fn fails", func(t *testing.T) {
+		conn.reset()
+		ran := false
+		boom := errors.New("boom")
+		err := manager.WithinTransaction(context.Background(), func(ctx context.Context, repos schemas.Repositories) error {
+			AfterCommit(ctx, func() { ran = true })
+			return boom
+		})
+
+		assert.ErrorIs(t, err, boom)
+		assert.Equal(t, []string{"begin", "rollback"}, conn.calls())
+		assert.False(t, ran)
+	})
+
+	t.Run("should roll back and drop hooks when fn panics", func(t *testing.T) {
+		conn.reset()
+		ran := false
+		assert.PanicsWithValue(t, "boom", func() {
+			manager.WithinTransaction(context.Background(), func(ctx context.Context, repos schemas.Repositories) error {
+				AfterCommit(ctx, func() { ran = true })
+				panic("boom")
+			})
+		})
+
+		assert.Equal(t, []string{"begin", "rollback"}, conn.calls())
+		assert.False(t, ran)
+	})
+}
+
+// txConn is a database/sql connection, and its own connector, that only
+// records transactions being begun, committed and rolled back.
+type txConn struct {
+	mu  sync.Mutex
+	log []string
+}
+
+func (c *txConn) record(call string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = append(c.log, call)
+}
+
+func (c *txConn) calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.log...)
+}
+
+func (c *txConn) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = nil
+}
+
+func (c *txConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *txConn) Driver() driver.Driver                        { return nil }
+
+func (c *txConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("txConn does not run statements")
+}
+
+func (c *txConn) Close() error { return nil }
+
+func (c *txConn) Begin() (driver.Tx, error) {
+	c.record("begin")
+	return txRecorder{c}, nil
+}
+
+type txRecorder struct{ conn *txConn }
+
+func (t txRecorder) Commit() error   { t.conn.record("commit"); return nil }
+func (t txRecorder) Rollback() error { t.conn.record("rollback"); return nil }
@@ -2,6 +2,7 @@ package user
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -14,23 +15,39 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/jamadeu/accounts/schemas"
+	s "github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/services/rbactest"
 	"github.com/stretchr/testify/assert"
 )
 
 var today = time.Now()
+var birthDateTest = time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
 var userTest = schemas.User{
 	Model: gorm.Model{
 		ID:        1,
 		CreatedAt: today,
 		UpdatedAt: today,
 	},
-	Name:     "Test",
-	Document: "12345678901",
-	Email:    "test@test.com",
+	Name:      "Test",
+	Document:  "52998224725",
+	Email:     "test@test.com",
+	Kind:      schemas.UserKindIndividual,
+	BirthDate: &birthDateTest,
 }
 
 var updatedUserTest = userTest
 
+// takenEmail and takenDocument belong to users other than the ones above.
+const (
+	takenEmail    = "taken@test.com"
+	takenDocument = "11144477735"
+)
+
+var accountsTest = []schemas.Account{
+	{Model: gorm.Model{ID: 1, CreatedAt: today, UpdatedAt: today}, Type: schemas.AccountTypeChecking, Currency: "BRL", HolderID: 1},
+	{Model: gorm.Model{ID: 4, CreatedAt: today, UpdatedAt: today}, Type: schemas.AccountTypeSavings, Currency: "BRL", HolderID: 1},
+}
+
 func jsonToString(s interface{}) string {
 	b, err := json.Marshal(s)
 	if err != nil {
@@ -43,6 +60,7 @@ func TestUserHandlers(t *testing.T) {
 	userRepo := &mockUserRepository{}
 	handler := NewUserHandler(userRepo)
 	router := gin.Default()
+	router.Use(withCaller(1))
 	handler.RegisterRoutes(router, "/api")
 
 	t.Run("handle find should get user by ID", func(t *testing.T) {
@@ -55,7 +73,7 @@ func TestUserHandlers(t *testing.T) {
 		router.ServeHTTP(w, req)
 
 		expectedBody := "{" +
-			"\"data\":" + jsonToString(userTest) + "," +
+			"\"data\":" + jsonToString(schemas.NewUserResponse(userTest)) + "," +
 			"\"message\":\"operation from handler: find-user-by-id successfull\"" +
 			"}"
 
@@ -70,14 +88,51 @@ func TestUserHandlers(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		router.ServeHTTP(w, req)
+		router.ServeHTTP(w, asCaller(req, 2))
 
 		expectedResponseBody := "{\"errorCode\":404,\"message\":\"user with id: " + userId + " not found\"}"
 		assert.Equal(t, http.StatusNotFound, w.Code)
 		assert.Equal(t, expectedResponseBody, w.Body.String())
 	})
 
-	t.Run("handle list should return a list of users", func(t *testing.T) {
+	t.Run("handle find should return 403 for the record of another user", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/user?id=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 2))
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"users may only access their own record\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle find should get the record of another user for staff", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/user?id=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(asCaller(req, 2), schemas.RoleCompliance))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("handle find should return 400 for staff when id is not numeric", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/user?id=1%20OR%201=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(asCaller(req, 2), schemas.RoleCompliance))
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: id (type: queryParameter) must be a positive integer\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle list should return 403 for customers", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req, err := http.NewRequest("GET", "/api/v1/users", nil)
 		if err != nil {
@@ -85,18 +140,83 @@ func TestUserHandlers(t *testing.T) {
 		}
 		router.ServeHTTP(w, req)
 
-		expectedResponseBody := "{\"data\":[" + jsonToString(userTest) + "]," +
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"role customer lacks permission users:list\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle delete should return 403 for the record of another user", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/user?id=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 2))
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("handle list should return a list of users", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/users", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleSupport))
+
+		expectedResponseBody := "{\"data\":[" + jsonToString(schemas.NewUserResponse(userTest)) + "]," +
 			"\"message\":\"operation from handler: list-users successfull\"}"
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, expectedResponseBody, w.Body.String())
 	})
 
+	t.Run("handle list accounts should return the accounts held by the user", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/user/1/accounts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"data\":[" +
+			jsonToString(schemas.NewAccountResponse(accountsTest[0])) + "," +
+			jsonToString(schemas.NewAccountResponse(accountsTest[1])) + "]," +
+			"\"message\":\"operation from handler: list-user-accounts successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle list accounts should return 404 when user is not found", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/user/2/accounts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 2))
+
+		expectedResponseBody := "{\"errorCode\":404,\"message\":\"user with id: 2 not found\"}"
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle list accounts should return 400 when user id is invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/user/abc/accounts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
 	t.Run("handle create should return created user", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		payload := CreateUserRequest{
-			Name:     userTest.Name,
-			Document: userTest.Document,
-			Email:    userTest.Email,
+			Name:      userTest.Name,
+			Document:  userTest.Document,
+			Email:     userTest.Email,
+			BirthDate: "1990-05-17",
 		}
 		b, err := json.Marshal(payload)
 		if err != nil {
@@ -109,12 +229,35 @@ func TestUserHandlers(t *testing.T) {
 		}
 		router.ServeHTTP(w, req)
 
-		expectedResponseBody := "{\"data\":" + jsonToString(userTest) + "," +
+		expectedResponseBody := "{\"data\":" + jsonToString(schemas.NewUserResponse(userTest)) + "," +
 			"\"message\":\"operation from handler: create-user successfull\"}"
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, expectedResponseBody, w.Body.String())
 	})
 
+	t.Run("handle create should return 409 when the document is taken", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := CreateUserRequest{
+			Name:      userTest.Name,
+			Document:  takenDocument,
+			Email:     userTest.Email,
+			BirthDate: "1990-05-17",
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/v1/user", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":409,\"message\":\"document is already registered to another user\"}"
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
 	t.Run("handle create should return 400 when request boddy is empty", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		b, err := json.Marshal("{}")
@@ -176,6 +319,76 @@ func TestUserHandlers(t *testing.T) {
 		assert.Equal(t, expectedResponseBody, w.Body.String())
 	})
 
+	t.Run("handle create should return 400 when document is invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := CreateUserRequest{
+			Name:     userTest.Name,
+			Document: "529.982.247-26",
+			Email:    userTest.Email,
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/api/v1/user", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: document is invalid: CPF check digits do not match\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 400 when individual has no birth date", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := CreateUserRequest{
+			Name:     userTest.Name,
+			Document: userTest.Document,
+			Email:    userTest.Email,
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/api/v1/user", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: birthDate (type: date) is required for individual customers\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle create should return 400 when company has no legal name", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := CreateUserRequest{
+			Name:      userTest.Name,
+			Document:  "11.222.333/0001-81",
+			Email:     userTest.Email,
+			TradeName: "Test Store",
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/api/v1/user", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: legalName (type: string) is required for company customers\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
 	t.Run("handle create should return 400 when email is empty", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		payload := CreateUserRequest{
@@ -225,7 +438,7 @@ func TestUserHandlers(t *testing.T) {
 		w := httptest.NewRecorder()
 		payload := UpdateUserRequest{
 			Name:     "Updated Name",
-			Document: "10987654321",
+			Document: "10987654357",
 			Email:    "updated_email@test.com",
 		}
 		updatedUserTest.Name = payload.Name
@@ -242,19 +455,36 @@ func TestUserHandlers(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		router.ServeHTTP(w, req)
+		router.ServeHTTP(w, steppedUp(req))
 
-		expectedResponseBody := "{\"data\":" + jsonToString(updatedUserTest) + "," +
+		expectedResponseBody := "{\"data\":" + jsonToString(schemas.NewUserResponse(updatedUserTest)) + "," +
 			"\"message\":\"operation from handler: update-user successfull\"}"
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, expectedResponseBody, w.Body.String())
 	})
 
+	t.Run("handle update should return 409 when the email is taken", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		b, err := json.Marshal(UpdateUserRequest{Email: takenEmail})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("PUT", "/api/v1/user?id=1", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(req))
+
+		expectedResponseBody := "{\"errorCode\":409,\"message\":\"email is already registered to another user\"}"
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
 	t.Run("should handle update return 400 when user id is empty", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		payload := UpdateUserRequest{
 			Name:     "Updated Name",
-			Document: "10987654321",
+			Document: "10987654357",
 			Email:    "updated_email@test.com",
 		}
 		updatedUserTest.Name = payload.Name
@@ -281,7 +511,7 @@ func TestUserHandlers(t *testing.T) {
 		w := httptest.NewRecorder()
 		payload := UpdateUserRequest{
 			Name:     "Updated Name",
-			Document: "10987654321",
+			Document: "10987654357",
 			Email:    "updated_email@test.com",
 		}
 		updatedUserTest.Name = payload.Name
@@ -298,7 +528,7 @@ func TestUserHandlers(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		router.ServeHTTP(w, req)
+		router.ServeHTTP(w, asCaller(req, 2))
 
 		expectedResponseBody := "{\"errorCode\":404,\"message\":\"user with id: " + userId + " not found\"}"
 		assert.Equal(t, http.StatusNotFound, w.Code)
@@ -349,6 +579,134 @@ func TestUserHandlers(t *testing.T) {
 		assert.Equal(t, expectedResponseBody, w.Body.String())
 	})
 
+	t.Run("handle update should return 400 when document is invalid", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		payload := UpdateUserRequest{
+			Document: "11.222.333/0001",
+		}
+
+		b, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		userId := strconv.Itoa(int(userTest.ID))
+		req, err := http.NewRequest("PUT", "/api/v1/user?id="+userId, bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, req)
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: document is invalid: invalid document length: expected 11 characters for CPF or 14 for CNPJ, got 12\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle update should require a second factor to change email or document", func(t *testing.T) {
+		for _, payload := range []UpdateUserRequest{
+			{Email: "another_email@test.com"},
+			{Document: "11144477735"},
+		} {
+			w := httptest.NewRecorder()
+			b, _ := json.Marshal(payload)
+			req, err := http.NewRequest("PUT", "/api/v1/user?id=1", bytes.NewBuffer(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.Contains(t, w.Header().Get("WWW-Authenticate"), "insufficient_user_authentication")
+		}
+	})
+
+	t.Run("handle delete should tell API keys they cannot step up", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/user?id=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyed := req.WithContext(s.WithScopes(req.Context(), []string{string(s.PermUsersWrite)}))
+		router.ServeHTTP(w, asCaller(keyed, 1))
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"this operation requires a second factor, which API keys cannot provide\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle delete should require a second factor", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/user?id=1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 1))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = httptest.NewRecorder()
+		stale := req.WithContext(s.WithSecondFactor(req.Context(), time.Now().Add(-s.StepUpMaxAge-time.Second)))
+		router.ServeHTTP(w, asCaller(stale, 1))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("handle update role should change the role of another user", func(t *testing.T) {
+		defer func(role string) { userTest.Role = role }(userTest.Role)
+		b, _ := json.Marshal(UpdateRoleRequest{Role: schemas.RoleSupport})
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", "/api/v1/user/1/role", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(asRole(asCaller(req, 2), schemas.RoleAdmin)))
+
+		promoted := userTest
+		promoted.Role = schemas.RoleSupport
+		expectedResponseBody := "{\"data\":" + jsonToString(schemas.NewUserResponse(promoted)) + "," +
+			"\"message\":\"operation from handler: update-role successfull\"}"
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle update role should return 400 when role is unknown", func(t *testing.T) {
+		b, _ := json.Marshal(UpdateRoleRequest{Role: "root"})
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", "/api/v1/user/1/role", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(asRole(asCaller(req, 2), schemas.RoleAdmin)))
+
+		expectedResponseBody := "{\"errorCode\":400,\"message\":\"param: role must be one of customer, support_agent, compliance_officer, admin\"}"
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle update role should return 403 for the admin's own role", func(t *testing.T) {
+		b, _ := json.Marshal(UpdateRoleRequest{Role: schemas.RoleCustomer})
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", "/api/v1/user/1/role", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(asRole(req, schemas.RoleAdmin)))
+
+		expectedResponseBody := "{\"errorCode\":403,\"message\":\"admins may not change their own role\"}"
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle update role should require a second factor", func(t *testing.T) {
+		b, _ := json.Marshal(UpdateRoleRequest{Role: schemas.RoleSupport})
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", "/api/v1/user/1/role", bytes.NewBuffer(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(asCaller(req, 2), schemas.RoleAdmin))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
 	t.Run("handle delete should user by ID", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		userId := strconv.Itoa(int(userTest.ID))
@@ -356,7 +714,7 @@ func TestUserHandlers(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		router.ServeHTTP(w, req)
+		router.ServeHTTP(w, steppedUp(req))
 
 		expectedBody := "{" +
 			"\"data\":\"id: " + userId + "\"," +
@@ -387,7 +745,7 @@ func TestUserHandlers(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		router.ServeHTTP(w, req)
+		router.ServeHTTP(w, steppedUp(asCaller(req, 2)))
 
 		expectedResponseBody := "{\"errorCode\":404,\"message\":\"user with id: " + userId + " not found\"}"
 		assert.Equal(t, http.StatusNotFound, w.Code)
@@ -395,26 +753,181 @@ func TestUserHandlers(t *testing.T) {
 	})
 }
 
+func TestUserPermissions(t *testing.T) {
+	router := gin.Default()
+	NewUserHandler(&mockUserRepository{}).RegisterRoutes(router, "/api")
+
+	staff := []string{schemas.RoleSupport, schemas.RoleCompliance, schemas.RoleAdmin}
+	rbactest.AssertMatrix(t, router, 1,
+		rbactest.Rule{Method: "POST", Path: "/api/v1/user", Roles: schemas.Roles},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/user", Roles: schemas.Roles},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/users", Roles: staff},
+		rbactest.Rule{Method: "GET", Path: "/api/v1/user/x/accounts", Roles: schemas.Roles},
+		rbactest.Rule{Method: "PUT", Path: "/api/v1/user", Roles: schemas.Roles},
+		rbactest.Rule{Method: "PUT", Path: "/api/v1/user/x/role", Roles: []string{schemas.RoleAdmin}},
+		rbactest.Rule{Method: "DELETE", Path: "/api/v1/user", Roles: schemas.Roles},
+	)
+}
+
+func TestUserHandlersContext(t *testing.T) {
+	handler := NewUserHandler(&mockUserRepository{})
+	handler.deadlines = s.Deadlines{Read: 20 * time.Millisecond, Write: 20 * time.Millisecond}
+	router := gin.Default()
+	router.Use(withCaller(1))
+	handler.RegisterRoutes(router, "/api")
+
+	t.Run("handle find should return 504 when the read deadline expires", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/v1/user?id="+slowUserId, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asCaller(req, 99))
+
+		expectedResponseBody := "{\"errorCode\":504,\"message\":\"request timed out\"}"
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+
+	t.Run("handle delete should return 504 when the write deadline expires", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", "/api/v1/user?id="+slowUserId, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, steppedUp(asCaller(req, 99)))
+
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	})
+
+	t.Run("handle list should return 499 when the client cancelled the request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, err := http.NewRequestWithContext(cancelled, "GET", "/api/v1/users", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(w, asRole(req, schemas.RoleAdmin))
+
+		expectedResponseBody := "{\"errorCode\":499,\"message\":\"request cancelled\"}"
+		assert.Equal(t, s.StatusClientClosedRequest, w.Code)
+		assert.Equal(t, expectedResponseBody, w.Body.String())
+	})
+}
+
+// asCaller identifies userID as the user making req.
+func asCaller(req *http.Request, userID uint) *http.Request {
+	return req.WithContext(s.WithCaller(req.Context(), userID))
+}
+
+// asRole gives the caller of req role.
+func asRole(req *http.Request, role string) *http.Request {
+	return req.WithContext(s.WithRole(req.Context(), role))
+}
+
+// steppedUp marks req as coming from a caller who just verified a second
+// factor.
+func steppedUp(req *http.Request) *http.Request {
+	return req.WithContext(s.WithSecondFactor(req.Context(), time.Now()))
+}
+
+// withCaller identifies userID on every request that does not name its own
+// caller, standing in for the token middleware.
+func withCaller(userID uint) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := s.Caller(ctx.Request.Context()); !ok {
+			ctx.Request = asCaller(ctx.Request, userID)
+		}
+		ctx.Next()
+	}
+}
+
+// slowUserId identifies a user whose lookup never finishes on its own, so it
+// only returns once the request context is done.
+const slowUserId = "99"
+
+// query stands in for a database round trip. Like the real repository it
+// requires a bounded context and gives up with the context error once the
+// context is done.
+func query(ctx context.Context, slow bool) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("query issued without a deadline")
+	}
+	if slow {
+		<-ctx.Done()
+	}
+	return ctx.Err()
+}
+
 type mockUserRepository struct{}
 
-func (m *mockUserRepository) FindById(id string) (*schemas.User, error) {
+func (m *mockUserRepository) FindById(ctx context.Context, id string) (*schemas.User, error) {
+	if err := query(ctx, id == slowUserId); err != nil {
+		return nil, err
+	}
 	if id == "1" {
-		return &userTest, nil
+		user := userTest
+		return &user, nil
 	} else {
 		return nil, errors.New("user not found")
 	}
 }
 
-func (m *mockUserRepository) ListUsers() (*[]schemas.User, error) {
+func (m *mockUserRepository) FindByEmail(ctx context.Context, email string) (*schemas.User, error) {
+	if err := query(ctx, false); err != nil {
+		return nil, err
+	}
+	if email == userTest.Email {
+		return &userTest, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func (m *mockUserRepository) FindWithAccounts(ctx context.Context, id string) (*schemas.User, error) {
+	user, err := m.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	withAccounts := *user
+	withAccounts.Accounts = accountsTest
+	return &withAccounts, nil
+}
+
+func (m *mockUserRepository) ListUsers(ctx context.Context) (*[]schemas.User, error) {
+	if err := query(ctx, false); err != nil {
+		return nil, err
+	}
 	listUser := []schemas.User{userTest}
 	return &listUser, nil
 }
-func (m *mockUserRepository) Create(user *schemas.User) (schemas.User, error) {
+func (m *mockUserRepository) Create(ctx context.Context, user *schemas.User) (schemas.User, error) {
+	if err := query(ctx, false); err != nil {
+		return schemas.User{}, err
+	}
+	if err := conflict(user); err != nil {
+		return schemas.User{}, err
+	}
 	return userTest, nil
 }
-func (m *mockUserRepository) Update(user *schemas.User) error {
-	return nil
+func (m *mockUserRepository) Update(ctx context.Context, user *schemas.User) error {
+	if err := query(ctx, false); err != nil {
+		return err
+	}
+	return conflict(user)
 }
-func (m *mockUserRepository) Delete(user *schemas.User) error {
+
+// conflict stands in for the unique constraints on users, which takenEmail
+// and takenDocument already hit.
+func conflict(user *schemas.User) error {
+	switch {
+	case user.Email == takenEmail:
+		return schemas.ErrEmailTaken
+	case user.Document == takenDocument:
+		return schemas.ErrDocumentTaken
+	}
 	return nil
 }
+func (m *mockUserRepository) Delete(ctx context.Context, user *schemas.User) error {
+	return query(ctx, false)
+}
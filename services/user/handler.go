@@ -1,77 +1,180 @@
 package user
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/document"
 	"github.com/jamadeu/accounts/schemas"
 	s "github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/tracing"
 )
 
 type UserHandler struct {
-	userRepo schemas.UserRepository
+	userRepo  schemas.UserRepository
+	deadlines s.Deadlines
 }
 
 func NewUserHandler(ur schemas.UserRepository) *UserHandler {
-	return &UserHandler{ur}
+	return &UserHandler{userRepo: ur, deadlines: s.DefaultDeadlines}
 }
 
 func (h *UserHandler) RegisterRoutes(router *gin.Engine, basePath string) {
+	read := s.Deadline(h.deadlines.Read)
+	write := s.Deadline(h.deadlines.Write)
 	v1 := router.Group(basePath + "/v1")
 	{
-		v1.POST("/user", h.handleCreateUser)
-		v1.GET("/user", h.handleFindUserById)
-		v1.GET("/users", h.handleListUsers)
-		v1.PUT("/user", h.handleUpdateUser)
-		v1.DELETE("/user", h.handleDeleteUser)
+		v1.POST("/user", write, h.handleCreateUser)
+		v1.GET("/user", s.Require(s.PermUsersRead), read, h.handleFindUserById)
+		v1.GET("/users", s.Require(s.PermUsersList), read, h.handleListUsers)
+		v1.GET("/user/:id/accounts", s.Require(s.PermUsersRead), read, h.handleListUserAccounts)
+		v1.PUT("/user", s.Require(s.PermUsersWrite), write, h.handleUpdateUser)
+		v1.PUT("/user/:id/role", s.Require(s.PermRolesManage), s.RequireSecondFactor(), write, h.handleUpdateRole)
+		v1.DELETE("/user", s.Require(s.PermUsersWrite), write, h.handleDeleteUser)
 	}
 }
 
+// ownRecord reports whether id names the caller, answering 403 otherwise.
+func ownRecord(ctx *gin.Context, id string) bool {
+	caller, _ := s.Caller(ctx.Request.Context())
+	if id != strconv.FormatUint(uint64(caller), 10) {
+		s.SendError(ctx, http.StatusForbidden, "users may only access their own record")
+		return false
+	}
+	return true
+}
+
+// idQuery reads the id query parameter, answering 400 when it is missing or
+// not a positive integer.
+func idQuery(ctx *gin.Context) (string, bool) {
+	id := ctx.Query("id")
+	if id == "" {
+		s.SendError(ctx, http.StatusBadRequest, errParamIsRequired("id", "queryParameter").Error())
+		return "", false
+	}
+	parsed, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || parsed == 0 {
+		s.SendError(ctx, http.StatusBadRequest, "param: id (type: queryParameter) must be a positive integer")
+		return "", false
+	}
+	return strconv.FormatUint(parsed, 10), true
+}
+
+// readableRecord is ownRecord for reads, which staff may make on any record.
+func readableRecord(ctx *gin.Context, id string) bool {
+	return s.Can(ctx.Request.Context(), s.PermUsersReadAny) || ownRecord(ctx, id)
+}
+
+// sendConflict answers 409 when err is a document or email already
+// registered to another user. It reports whether it answered.
+func sendConflict(ctx *gin.Context, err error) bool {
+	if !errors.Is(err, schemas.ErrDocumentTaken) && !errors.Is(err, schemas.ErrEmailTaken) {
+		return false
+	}
+	s.SendError(ctx, http.StatusConflict, err.Error())
+	return true
+}
+
 func (h *UserHandler) handleCreateUser(ctx *gin.Context) {
 	var err error
 	request := CreateUserRequest{}
 	ctx.BindJSON(&request)
 	if err = request.Validate(); err != nil {
-		fmt.Printf("validation error: %v", err.Error())
+		slog.WarnContext(ctx.Request.Context(), "invalid create user request", "error", err)
 		s.SendError(ctx, http.StatusBadRequest, err.Error())
 		return
 	}
+	birthDate, _ := parseBirthDate(request.BirthDate)
 	user := schemas.User{
 		Name:      request.Name,
-		Document:  request.Document,
+		Document:  document.Normalize(request.Document),
 		Email:     request.Email,
-		AccountID: 0,
+		BirthDate: birthDate,
+		LegalName: request.LegalName,
+		TradeName: request.TradeName,
+	}
+	if err = validateKind(&user); err != nil {
+		s.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	user, err = h.userRepo.Create(&user)
+	user, err = h.userRepo.Create(ctx.Request.Context(), &user)
 	if err != nil {
+		if s.SendContextError(ctx, err) || sendConflict(ctx, err) {
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "creating user on database", "error", err)
 		s.SendError(ctx, http.StatusInternalServerError, "creating account on database")
 		return
 	}
-	s.SendSuccess(ctx, "create-user", user)
+	s.SendSuccess(ctx, "create-user", schemas.NewUserResponse(user))
 }
 
 func (h *UserHandler) handleFindUserById(ctx *gin.Context) {
-	id := ctx.Query("id")
-	if id == "" {
-		s.SendError(ctx, http.StatusBadRequest, errParamIsRequired("id", "queryParameter").Error())
+	id, ok := idQuery(ctx)
+	if !ok {
+		return
+	}
+	tracing.SetAttributes(ctx.Request.Context(), tracing.UserIDString(id))
+	if !readableRecord(ctx, id) {
 		return
 	}
-	user, err := h.userRepo.FindById(id)
+	user, err := h.userRepo.FindById(ctx.Request.Context(), id)
 	if err != nil {
+		if s.SendContextError(ctx, err) {
+			return
+		}
 		s.SendError(ctx, http.StatusNotFound, fmt.Sprintf("user with id: %s not found", id))
 		return
 	}
-	s.SendSuccess(ctx, "find-user-by-id", user)
+	s.SendSuccess(ctx, "find-user-by-id", schemas.NewUserResponse(*user))
+}
+
+func (h *UserHandler) handleListUserAccounts(ctx *gin.Context) {
+	id := ctx.Param("id")
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		s.SendError(ctx, http.StatusBadRequest, "param: id (type: pathParameter) must be a positive integer")
+		return
+	}
+	tracing.SetAttributes(ctx.Request.Context(), tracing.UserIDString(id))
+	if !readableRecord(ctx, id) {
+		return
+	}
+	user, err := h.userRepo.FindWithAccounts(ctx.Request.Context(), id)
+	if err != nil {
+		if s.SendContextError(ctx, err) {
+			return
+		}
+		s.SendError(ctx, http.StatusNotFound, fmt.Sprintf("user with id: %s not found", id))
+		return
+	}
+	accounts := make([]schemas.AccountResponse, 0, len(user.Accounts))
+	for _, account := range user.Accounts {
+		accounts = append(accounts, schemas.NewAccountResponse(account))
+	}
+	s.SendSuccess(ctx, "list-user-accounts", accounts)
 }
 
 func (h *UserHandler) handleListUsers(ctx *gin.Context) {
-	users, err := h.userRepo.ListUsers()
+	users, err := h.userRepo.ListUsers(ctx.Request.Context())
 	if err != nil {
+		if s.SendContextError(ctx, err) {
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "error to list users", "error", err)
 		s.SendError(ctx, http.StatusInternalServerError, "error to list users")
+		return
 	}
-	s.SendSuccess(ctx, "list-users", users)
+	response := make([]schemas.UserResponse, 0, len(*users))
+	for _, user := range *users {
+		response = append(response, schemas.NewUserResponse(user))
+	}
+	s.SendSuccess(ctx, "list-users", response)
 }
 
 func (h *UserHandler) handleUpdateUser(ctx *gin.Context) {
@@ -81,47 +184,127 @@ func (h *UserHandler) handleUpdateUser(ctx *gin.Context) {
 		s.SendError(ctx, http.StatusBadRequest, err.Error())
 		return
 	}
-	id := ctx.Query("id")
-	if id == "" {
-		s.SendError(ctx, http.StatusBadRequest, errParamIsRequired("id",
-			"queryParameter").Error())
+	id, ok := idQuery(ctx)
+	if !ok {
+		return
+	}
+	tracing.SetAttributes(ctx.Request.Context(), tracing.UserIDString(id))
+	if !ownRecord(ctx, id) {
 		return
 	}
-	user, err := h.userRepo.FindById(id)
+	user, err := h.userRepo.FindById(ctx.Request.Context(), id)
 	if err != nil {
+		if s.SendContextError(ctx, err) {
+			return
+		}
 		s.SendError(ctx, http.StatusNotFound, fmt.Sprintf("user with id: %s not found", id))
 		return
 	}
+	// Email and document identify the user for logins and password resets
+	if request.Email != "" && !strings.EqualFold(request.Email, user.Email) ||
+		request.Document != "" && document.Normalize(request.Document) != user.Document {
+		if !s.FreshSecondFactor(ctx) {
+			return
+		}
+	}
 	if request.Name != "" {
 		user.Name = request.Name
 	}
 	if request.Document != "" {
-		user.Document = request.Document
+		user.Document = document.Normalize(request.Document)
 	}
 	if request.Email != "" {
 		user.Email = request.Email
 	}
+	if request.BirthDate != "" {
+		user.BirthDate, _ = parseBirthDate(request.BirthDate)
+	}
+	if request.LegalName != "" {
+		user.LegalName = request.LegalName
+	}
+	if request.TradeName != "" {
+		user.TradeName = request.TradeName
+	}
+	if err = validateKind(user); err != nil {
+		s.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	if err = h.userRepo.Update(user); err != nil {
+	if err = h.userRepo.Update(ctx.Request.Context(), user); err != nil {
+		if s.SendContextError(ctx, err) || sendConflict(ctx, err) {
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "error updating user", "error", err)
 		s.SendError(ctx, http.StatusInternalServerError, "error updating user")
 		return
 	}
-	s.SendSuccess(ctx, "update-user", user)
+	s.SendSuccess(ctx, "update-user", schemas.NewUserResponse(*user))
+}
+
+// handleUpdateRole changes the role of a user. Admins may not change their
+// own, so the last admin cannot demote themselves by mistake.
+func (h *UserHandler) handleUpdateRole(ctx *gin.Context) {
+	request := UpdateRoleRequest{}
+	ctx.BindJSON(&request)
+	if err := request.Validate(); err != nil {
+		s.SendError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := ctx.Param("id")
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		s.SendError(ctx, http.StatusBadRequest, "param: id (type: pathParameter) must be a positive integer")
+		return
+	}
+	tracing.SetAttributes(ctx.Request.Context(), tracing.UserIDString(id))
+	if caller, _ := s.Caller(ctx.Request.Context()); id == strconv.FormatUint(uint64(caller), 10) {
+		s.SendError(ctx, http.StatusForbidden, "admins may not change their own role")
+		return
+	}
+	user, err := h.userRepo.FindById(ctx.Request.Context(), id)
+	if err != nil {
+		if s.SendContextError(ctx, err) {
+			return
+		}
+		s.SendError(ctx, http.StatusNotFound, fmt.Sprintf("user with id: %s not found", id))
+		return
+	}
+	previous := user.Role
+	user.Role = request.Role
+	if err = h.userRepo.Update(ctx.Request.Context(), user); err != nil {
+		if s.SendContextError(ctx, err) {
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "error updating role", "error", err)
+		s.SendError(ctx, http.StatusInternalServerError, "error updating role")
+		return
+	}
+	slog.InfoContext(ctx.Request.Context(), "changed user role", "user_id", user.ID, "from", previous, "to", user.Role)
+	s.SendSuccess(ctx, "update-role", schemas.NewUserResponse(*user))
 }
 
 func (h *UserHandler) handleDeleteUser(ctx *gin.Context) {
-	id := ctx.Query("id")
-	if id == "" {
-		s.SendError(ctx, http.StatusBadRequest, errParamIsRequired("id", "queryParameter").Error())
+	id, ok := idQuery(ctx)
+	if !ok {
+		return
+	}
+	tracing.SetAttributes(ctx.Request.Context(), tracing.UserIDString(id))
+	if !ownRecord(ctx, id) || !s.FreshSecondFactor(ctx) {
 		return
 	}
-	user, err := h.userRepo.FindById(id)
+	user, err := h.userRepo.FindById(ctx.Request.Context(), id)
 	if err != nil {
+		if s.SendContextError(ctx, err) {
+			return
+		}
 		s.SendError(ctx, http.StatusNotFound, fmt.Sprintf("user with id: %s not found", id))
 		return
 	}
-	err = h.userRepo.Delete(user)
+	err = h.userRepo.Delete(ctx.Request.Context(), user)
 	if err != nil {
+		if s.SendContextError(ctx, err) {
+			return
+		}
+		slog.ErrorContext(ctx.Request.Context(), "error deleting user", "id", id, "error", err)
 		s.SendError(ctx, http.StatusInternalServerError, fmt.Sprintf("error deleteing car with id: %s", id))
 		return
 	}
@@ -1,11 +1,38 @@
 package user
 
 import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jamadeu/accounts/schemas"
 
 	"gorm.io/gorm"
 )
 
+// uniqueViolation is the SQLSTATE Postgres reports when a unique
+// constraint or index rejects a row.
+const uniqueViolation = "23505"
+
+// conflictErrors maps the unique constraints on users to the error naming
+// the field they guard.
+var conflictErrors = map[string]error{
+	"uni_users_document": schemas.ErrDocumentTaken,
+	"idx_users_email":    schemas.ErrEmailTaken,
+}
+
+// translateConflict returns the error naming the duplicated field when err
+// is a unique violation on users, and err otherwise.
+func translateConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		if conflict, ok := conflictErrors[pgErr.ConstraintName]; ok {
+			return conflict
+		}
+	}
+	return err
+}
+
 type UserRepository struct {
 	db *gorm.DB
 }
@@ -14,38 +41,63 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) FindById(id string) (*schemas.User, error) {
+func (r *UserRepository) FindById(ctx context.Context, id string) (*schemas.User, error) {
+	user := schemas.User{}
+	if err := r.db.WithContext(ctx).First(&user, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*schemas.User, error) {
 	user := schemas.User{}
-	if err := r.db.First(&user, id).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("lower(email) = lower(?)", email).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *UserRepository) Create(user *schemas.User) (schemas.User, error) {
-	if err := r.db.Create(&user).Error; err != nil {
-		return schemas.User{}, err
+// FindWithAccounts loads every account the user holds, in any role.
+func (r *UserRepository) FindWithAccounts(ctx context.Context, id string) (*schemas.User, error) {
+	user := schemas.User{}
+	if err := r.db.WithContext(ctx).First(&user, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	err := r.db.WithContext(ctx).
+		Joins("JOIN account_holders ON account_holders.account_id = accounts.id").
+		Where("account_holders.user_id = ?", user.ID).
+		Order("accounts.id").
+		Find(&user.Accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *schemas.User) (schemas.User, error) {
+	if err := r.db.WithContext(ctx).Create(&user).Error; err != nil {
+		return schemas.User{}, translateConflict(err)
 	}
 	return *user, nil
 }
 
-func (r *UserRepository) ListUsers() (*[]schemas.User, error) {
+func (r *UserRepository) ListUsers(ctx context.Context) (*[]schemas.User, error) {
 	users := []schemas.User{}
-	if err := r.db.Find(&users).Error; err != nil {
+	if err := r.db.WithContext(ctx).Find(&users).Error; err != nil {
 		return nil, err
 	}
 	return &users, nil
 }
 
-func (r *UserRepository) Update(user *schemas.User) error {
-	if err := r.db.Save(&user).Error; err != nil {
-		return err
+func (r *UserRepository) Update(ctx context.Context, user *schemas.User) error {
+	if err := r.db.WithContext(ctx).Save(&user).Error; err != nil {
+		return translateConflict(err)
 	}
 	return nil
 }
 
-func (r *UserRepository) Delete(user *schemas.User) error {
-	if err := r.db.Delete(&user, user.ID).Error; err != nil {
+func (r *UserRepository) Delete(ctx context.Context, user *schemas.User) error {
+	if err := r.db.WithContext(ctx).Delete(&user, user.ID).Error; err != nil {
 		return err
 	}
 	return nil
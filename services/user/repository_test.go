@@ -0,0 +1,27 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateConflict(t *testing.T) {
+	violation := func(constraint string) error {
+		return fmt.Errorf("saving user: %w", &pgconn.PgError{Code: uniqueViolation, ConstraintName: constraint})
+	}
+
+	assert.ErrorIs(t, translateConflict(violation("uni_users_document")), schemas.ErrDocumentTaken)
+	assert.ErrorIs(t, translateConflict(violation("idx_users_email")), schemas.ErrEmailTaken)
+
+	other := violation("uni_something_else")
+	assert.Equal(t, other, translateConflict(other))
+	notNull := &pgconn.PgError{Code: "23502", ConstraintName: "idx_users_email"}
+	assert.Equal(t, error(notNull), translateConflict(notNull))
+	boom := errors.New("boom")
+	assert.Equal(t, boom, translateConflict(boom))
+}
@@ -3,16 +3,31 @@ package user
 import (
 	"fmt"
 	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jamadeu/accounts/document"
+	"github.com/jamadeu/accounts/schemas"
 )
 
+const dateLayout = "2006-01-02"
+
 func errParamIsRequired(name, typ string) error {
 	return fmt.Errorf("param: %s (type: %s) is required", name, typ)
 }
 
+func errParamIsInvalid(name string, err error) error {
+	return fmt.Errorf("param: %s is invalid: %v", name, err)
+}
+
 type CreateUserRequest struct {
-	Name     string `json:"name"`
-	Document string `json:"document"`
-	Email    string `json:"email"`
+	Name      string `json:"name"`
+	Document  string `json:"document"`
+	Email     string `json:"email"`
+	BirthDate string `json:"birthDate,omitempty"`
+	LegalName string `json:"legalName,omitempty"`
+	TradeName string `json:"tradeName,omitempty"`
 }
 
 func (r *CreateUserRequest) Validate() error {
@@ -25,9 +40,15 @@ func (r *CreateUserRequest) Validate() error {
 	if r.Document == "" {
 		return errParamIsRequired("document", "string")
 	}
+	if _, err := document.Validate(r.Document); err != nil {
+		return errParamIsInvalid("document", err)
+	}
 	if r.Email == "" || validEmailFormat(r.Email) {
 		return errParamIsRequired("email", "string")
 	}
+	if _, err := parseBirthDate(r.BirthDate); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -36,18 +57,85 @@ func validEmailFormat(email string) bool {
 	return err != nil
 }
 
+// parseBirthDate reads an optional YYYY-MM-DD birth date, which cannot be in
+// the future.
+func parseBirthDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return nil, fmt.Errorf("param: birthDate (type: date YYYY-MM-DD) is invalid")
+	}
+	if t.After(time.Now()) {
+		return nil, fmt.Errorf("param: birthDate cannot be in the future")
+	}
+	return &t, nil
+}
+
+// validateKind derives the customer kind from the user's document and checks
+// the fields each kind requires: a birth date for individuals, and legal and
+// trade names for companies.
+func validateKind(user *schemas.User) error {
+	t, err := document.Detect(user.Document)
+	if err != nil {
+		return errParamIsInvalid("document", err)
+	}
+	user.Kind = schemas.UserKindFor(t)
+	switch user.Kind {
+	case schemas.UserKindIndividual:
+		if user.BirthDate == nil {
+			return fmt.Errorf("param: birthDate (type: date) is required for individual customers")
+		}
+		user.LegalName = ""
+		user.TradeName = ""
+	case schemas.UserKindCompany:
+		if user.LegalName == "" {
+			return fmt.Errorf("param: legalName (type: string) is required for company customers")
+		}
+		if user.TradeName == "" {
+			return fmt.Errorf("param: tradeName (type: string) is required for company customers")
+		}
+		user.BirthDate = nil
+	}
+	return nil
+}
+
 type UpdateUserRequest struct {
-	Name     string `json:"name"`
-	Document string `json:"document"`
-	Email    string `json:"email"`
+	Name      string `json:"name"`
+	Document  string `json:"document"`
+	Email     string `json:"email"`
+	BirthDate string `json:"birthDate,omitempty"`
+	LegalName string `json:"legalName,omitempty"`
+	TradeName string `json:"tradeName,omitempty"`
 }
 
 func (r *UpdateUserRequest) Validate() error {
 	if r.Email != "" && validEmailFormat(r.Email) {
 		return errParamIsRequired("email", "string")
 	}
-	if r.Name != "" || r.Document != "" || r.Email != "" {
+	if r.Document != "" {
+		if _, err := document.Validate(r.Document); err != nil {
+			return errParamIsInvalid("document", err)
+		}
+	}
+	if _, err := parseBirthDate(r.BirthDate); err != nil {
+		return err
+	}
+	if r.Name != "" || r.Document != "" || r.Email != "" ||
+		r.BirthDate != "" || r.LegalName != "" || r.TradeName != "" {
 		return nil
 	}
 	return fmt.Errorf("at least one valid field must be provided")
 }
+
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (r *UpdateRoleRequest) Validate() error {
+	if !slices.Contains(schemas.Roles, r.Role) {
+		return fmt.Errorf("param: role must be one of %s", strings.Join(schemas.Roles, ", "))
+	}
+	return nil
+}
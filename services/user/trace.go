@@ -0,0 +1,71 @@
+package user
+
+import (
+	"context"
+
+	"github.com/jamadeu/accounts/schemas"
+	"github.com/jamadeu/accounts/tracing"
+)
+
+// tracedRepository wraps a UserRepository with one span per call, carrying
+// the user ID involved. Queries issued by the wrapped repository nest under
+// it.
+type tracedRepository struct {
+	next schemas.UserRepository
+}
+
+func NewTracedUserRepository(next schemas.UserRepository) schemas.UserRepository {
+	return &tracedRepository{next: next}
+}
+
+func (r *tracedRepository) FindById(ctx context.Context, id string) (_ *schemas.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserRepository.FindById", tracing.UserIDString(id))
+	defer tracing.End(span, &err)
+	return r.next.FindById(ctx, id)
+}
+
+func (r *tracedRepository) FindByEmail(ctx context.Context, email string) (user *schemas.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserRepository.FindByEmail")
+	defer func() {
+		if err == nil {
+			span.SetAttributes(tracing.UserID(user.ID))
+		}
+		tracing.End(span, &err)
+	}()
+	return r.next.FindByEmail(ctx, email)
+}
+
+func (r *tracedRepository) FindWithAccounts(ctx context.Context, id string) (_ *schemas.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserRepository.FindWithAccounts", tracing.UserIDString(id))
+	defer tracing.End(span, &err)
+	return r.next.FindWithAccounts(ctx, id)
+}
+
+func (r *tracedRepository) ListUsers(ctx context.Context) (_ *[]schemas.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserRepository.ListUsers")
+	defer tracing.End(span, &err)
+	return r.next.ListUsers(ctx)
+}
+
+func (r *tracedRepository) Create(ctx context.Context, user *schemas.User) (_ schemas.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserRepository.Create")
+	defer func() {
+		if err == nil {
+			span.SetAttributes(tracing.UserID(user.ID))
+		}
+		tracing.End(span, &err)
+	}()
+	return r.next.Create(ctx, user)
+}
+
+func (r *tracedRepository) Update(ctx context.Context, user *schemas.User) (err error) {
+	ctx, span := tracing.Start(ctx, "UserRepository.Update", tracing.UserID(user.ID))
+	defer tracing.End(span, &err)
+	return r.next.Update(ctx, user)
+}
+
+func (r *tracedRepository) Delete(ctx context.Context, user *schemas.User) (err error) {
+	ctx, span := tracing.Start(ctx, "UserRepository.Delete", tracing.UserID(user.ID))
+	defer tracing.End(span, &err)
+	return r.next.Delete(ctx, user)
+}
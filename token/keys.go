@@ -0,0 +1,192 @@
+package token
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Algorithms accepted by LoadKeys.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+)
+
+// minHMACKeyLen is the shortest secret accepted for HS256, matching the
+// size of the hash.
+const minHMACKeyLen = 32
+
+var ErrUnknownKey = errors.New("token signed with an unknown key")
+
+// Key is a signing key identified by the kid header of the tokens it signs.
+type Key struct {
+	ID     string
+	method jwt.SigningMethod
+	sign   any
+	verify any
+}
+
+func NewHMACKey(id string, secret []byte) (Key, error) {
+	if len(secret) < minHMACKeyLen {
+		return Key{}, fmt.Errorf("key %s: HS256 secrets must be at least %d bytes", id, minHMACKeyLen)
+	}
+	return Key{ID: id, method: jwt.SigningMethodHS256, sign: secret, verify: secret}, nil
+}
+
+func NewRSAKey(id string, key *rsa.PrivateKey) Key {
+	return Key{ID: id, method: jwt.SigningMethodRS256, sign: key, verify: &key.PublicKey}
+}
+
+// ParseRSAKey reads a PEM encoded PKCS #1 or PKCS #8 private key.
+func ParseRSAKey(id string, pem []byte) (Key, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	if err != nil {
+		return Key{}, fmt.Errorf("key %s: %w", id, err)
+	}
+	return NewRSAKey(id, key), nil
+}
+
+// KeySet signs with its first key and verifies tokens signed by any of its
+// keys. Rotating means prepending the new key and keeping the previous ones
+// until the tokens they signed have expired.
+type KeySet struct {
+	keys []Key
+}
+
+func NewKeySet(keys ...Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("signing keys must have an id")
+		}
+		if seen[k.ID] {
+			return nil, fmt.Errorf("duplicate signing key id %s", k.ID)
+		}
+		seen[k.ID] = true
+	}
+	return &KeySet{keys: keys}, nil
+}
+
+// LoadKeys builds the key set described by the configuration. hmacKeys is a
+// comma separated list of id:secret pairs and rsaKeys a list of id:path
+// pairs naming PEM private keys; only the list of the chosen algorithm is
+// used, and its first entry is the active key.
+func LoadKeys(algorithm, hmacKeys, rsaKeys string) (*KeySet, error) {
+	var keys []Key
+	switch algorithm {
+	case AlgorithmHS256:
+		for _, entry := range splitList(hmacKeys) {
+			id, secret, ok := strings.Cut(entry, ":")
+			if !ok {
+				return nil, fmt.Errorf("AUTH_HMAC_KEYS: expected id:secret")
+			}
+			key, err := NewHMACKey(id, []byte(secret))
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	case AlgorithmRS256:
+		for _, entry := range splitList(rsaKeys) {
+			id, path, ok := strings.Cut(entry, ":")
+			if !ok {
+				return nil, fmt.Errorf("AUTH_RSA_KEYS: expected id:path")
+			}
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", id, err)
+			}
+			key, err := ParseRSAKey(id, pem)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	return NewKeySet(keys...)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func (ks *KeySet) active() Key {
+	return ks.keys[0]
+}
+
+// keyFunc picks the verification key named by the kid header, refusing
+// tokens whose algorithm differs from the key's so an RSA public key can
+// never be used as an HMAC secret.
+func (ks *KeySet) keyFunc(t *jwt.Token) (any, error) {
+	id, _ := t.Header["kid"].(string)
+	for _, k := range ks.keys {
+		if k.ID != id {
+			continue
+		}
+		if t.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("key %s does not sign with %s", id, t.Method.Alg())
+		}
+		return k.verify, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (ks *KeySet) algorithms() []string {
+	algs := []string{}
+	for _, k := range ks.keys {
+		algs = append(algs, k.method.Alg())
+	}
+	return algs
+}
+
+// JWK is the public part of an RSA key as published in a JWKS document.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys of the set. HMAC secrets are never published,
+// so a set of HS256 keys has an empty document.
+func (ks *KeySet) JWKS() JWKS {
+	doc := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		pub, ok := k.verify.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		doc.Keys = append(doc.Keys, JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: k.method.Alg(),
+			KeyID:     k.ID,
+			Modulus:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	return doc
+}
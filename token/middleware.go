@@ -0,0 +1,49 @@
+package token
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/services"
+)
+
+// Middleware authenticates requests carrying an "Authorization: Bearer"
+// access token and stores the user in the request context. Requests without
+// the header pass through anonymous, and routes that need a user reject
+// them with services.RequireCaller; a token that fails verification is
+// rejected right away.
+func Middleware(issuer *Issuer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.GetHeader("Authorization")
+		if header == "" {
+			ctx.Next()
+			return
+		}
+		scheme, raw, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			unauthorized(ctx, "authorization header must be a Bearer token")
+			return
+		}
+		claims, err := issuer.Verify(strings.TrimSpace(raw), TypeAccess)
+		if err != nil {
+			slog.InfoContext(ctx.Request.Context(), "rejected access token", "error", err)
+			unauthorized(ctx, "invalid or expired access token")
+			return
+		}
+		userID, _ := claims.UserID()
+		c := services.WithRole(services.WithCaller(ctx.Request.Context(), userID), claims.Role)
+		if claims.MFATime != nil {
+			c = services.WithSecondFactor(c, claims.MFATime.Time)
+		}
+		ctx.Request = ctx.Request.WithContext(c)
+		ctx.Next()
+	}
+}
+
+func unauthorized(ctx *gin.Context, msg string) {
+	ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	services.SendError(ctx, http.StatusUnauthorized, msg)
+	ctx.Abort()
+}
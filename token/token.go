@@ -0,0 +1,139 @@
+// Package token issues and verifies the signed JWTs that authenticate API
+// requests.
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token types, carried in the typ claim so a refresh token is never accepted
+// as an access token or the other way round.
+const (
+	TypeAccess  = "access"
+	TypeRefresh = "refresh"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type Claims struct {
+	jwt.RegisteredClaims
+	Type string `json:"typ"`
+	// Role is the user's role when the token was issued. Only access tokens
+	// carry it; refreshing reads the role again, so a change takes effect
+	// within one access token lifetime.
+	Role string `json:"role,omitempty"`
+	// MFATime is when the user last verified a second factor. Only access
+	// tokens issued by a step-up carry it.
+	MFATime *jwt.NumericDate `json:"mfa_time,omitempty"`
+}
+
+// UserID returns the user the token was issued to.
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%w: malformed subject", ErrInvalidToken)
+	}
+	return uint(id), nil
+}
+
+// Pair is the response of a successful login or refresh.
+type Pair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+type Issuer struct {
+	keys       *KeySet
+	issuer     string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+func NewIssuer(keys *KeySet, issuer string, accessTTL, refreshTTL time.Duration) *Issuer {
+	return &Issuer{keys: keys, issuer: issuer, accessTTL: accessTTL, refreshTTL: refreshTTL, now: time.Now}
+}
+
+// Keys returns the key set tokens are signed with.
+func (i *Issuer) Keys() *KeySet {
+	return i.keys
+}
+
+// Issue signs a new access and refresh token pair for a user with role.
+func (i *Issuer) Issue(userID uint, role string) (Pair, error) {
+	return i.issue(userID, role, nil)
+}
+
+// IssueStepUp is Issue for a user who verified a second factor at
+// verifiedAt. Only the access token records it, so refreshing drops it.
+func (i *Issuer) IssueStepUp(userID uint, role string, verifiedAt time.Time) (Pair, error) {
+	return i.issue(userID, role, jwt.NewNumericDate(verifiedAt))
+}
+
+func (i *Issuer) issue(userID uint, role string, mfaTime *jwt.NumericDate) (Pair, error) {
+	access, err := i.sign(userID, TypeAccess, i.accessTTL, role, mfaTime)
+	if err != nil {
+		return Pair{}, err
+	}
+	refresh, err := i.sign(userID, TypeRefresh, i.refreshTTL, "", nil)
+	if err != nil {
+		return Pair{}, err
+	}
+	return Pair{AccessToken: access, RefreshToken: refresh, TokenType: "Bearer", ExpiresIn: int(i.accessTTL.Seconds())}, nil
+}
+
+func (i *Issuer) sign(userID uint, typ string, ttl time.Duration, role string, mfaTime *jwt.NumericDate) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	now := i.now()
+	key := i.keys.active()
+	t := jwt.NewWithClaims(key.method, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			Issuer:    i.issuer,
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Type:    typ,
+		Role:    role,
+		MFATime: mfaTime,
+	})
+	t.Header["kid"] = key.ID
+	return t.SignedString(key.sign)
+}
+
+// Verify checks the signature, issuer, lifetime and type of a token. Every
+// failure wraps ErrInvalidToken.
+func (i *Issuer) Verify(raw, typ string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(raw, claims, i.keys.keyFunc,
+		jwt.WithValidMethods(i.keys.algorithms()),
+		jwt.WithIssuer(i.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithTimeFunc(i.now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.Type != typ {
+		return nil, fmt.Errorf("%w: expected a %s token", ErrInvalidToken, typ)
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,139 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hmacKey(t *testing.T, id string) Key {
+	key, err := NewHMACKey(id, []byte(strings.Repeat(id, 32)))
+	require.NoError(t, err)
+	return key
+}
+
+func TestIssuer(t *testing.T) {
+	current, previous := hmacKey(t, "b"), hmacKey(t, "a")
+	oldKeys, err := NewKeySet(previous)
+	require.NoError(t, err)
+	keys, err := NewKeySet(current, previous)
+	require.NoError(t, err)
+	issuer := NewIssuer(keys, "accounts", time.Minute, time.Hour)
+
+	t.Run("should verify issued tokens by type", func(t *testing.T) {
+		pair, err := issuer.Issue(7, "support_agent")
+		require.NoError(t, err)
+		assert.Equal(t, "Bearer", pair.TokenType)
+		assert.Equal(t, 60, pair.ExpiresIn)
+
+		claims, err := issuer.Verify(pair.AccessToken, TypeAccess)
+		require.NoError(t, err)
+		id, _ := claims.UserID()
+		assert.Equal(t, uint(7), id)
+		assert.Equal(t, "support_agent", claims.Role)
+		refresh, err := issuer.Verify(pair.RefreshToken, TypeRefresh)
+		require.NoError(t, err)
+		assert.Empty(t, refresh.Role)
+		_, err = issuer.Verify(pair.RefreshToken, TypeAccess)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		_, err = issuer.Verify(pair.AccessToken, TypeRefresh)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("should accept tokens signed by a rotated key", func(t *testing.T) {
+		pair, err := NewIssuer(oldKeys, "accounts", time.Minute, time.Hour).Issue(7, "support_agent")
+		require.NoError(t, err)
+		_, err = issuer.Verify(pair.AccessToken, TypeAccess)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should reject unknown keys, other issuers and expired tokens", func(t *testing.T) {
+		unknown, _ := NewKeySet(hmacKey(t, "c"))
+		pair, _ := NewIssuer(unknown, "accounts", time.Minute, time.Hour).Issue(7, "support_agent")
+		_, err := issuer.Verify(pair.AccessToken, TypeAccess)
+		assert.ErrorIs(t, err, ErrUnknownKey)
+
+		pair, _ = NewIssuer(keys, "elsewhere", time.Minute, time.Hour).Issue(7, "support_agent")
+		_, err = issuer.Verify(pair.AccessToken, TypeAccess)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		expired := NewIssuer(keys, "accounts", time.Minute, time.Hour)
+		expired.now = func() time.Time { return time.Now().Add(-2 * time.Minute) }
+		pair, _ = expired.Issue(7, "support_agent")
+		_, err = issuer.Verify(pair.AccessToken, TypeAccess)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("should refuse an RSA public key used as an HMAC secret", func(t *testing.T) {
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		rsaKeys, _ := NewKeySet(NewRSAKey("r", private))
+		jwks := rsaKeys.JWKS()
+		require.Len(t, jwks.Keys, 1)
+		assert.Equal(t, "r", jwks.Keys[0].KeyID)
+		assert.Empty(t, keys.JWKS().Keys)
+
+		forged, _ := NewKeySet(Key{ID: "r", method: current.method, sign: private.PublicKey.N.Bytes()})
+		pair, _ := NewIssuer(forged, "accounts", time.Minute, time.Hour).Issue(7, "support_agent")
+		_, err = NewIssuer(rsaKeys, "accounts", time.Minute, time.Hour).Verify(pair.AccessToken, TypeAccess)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("should reject short secrets and unknown algorithms", func(t *testing.T) {
+		_, err := LoadKeys(AlgorithmHS256, "k:short", "")
+		assert.ErrorContains(t, err, "at least 32 bytes")
+		_, err = LoadKeys("none", "", "")
+		assert.ErrorContains(t, err, "unsupported")
+		_, err = LoadKeys(AlgorithmHS256, "", "")
+		assert.Error(t, err)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	keys, err := NewKeySet(hmacKey(t, "a"))
+	require.NoError(t, err)
+	issuer := NewIssuer(keys, "accounts", time.Minute, time.Hour)
+	pair, err := issuer.Issue(7, "support_agent")
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(Middleware(issuer))
+	router.GET("/me", func(ctx *gin.Context) {
+		caller, ok := services.Caller(ctx.Request.Context())
+		_, stepUp := services.SecondFactorAt(ctx.Request.Context())
+		ctx.JSON(http.StatusOK, gin.H{"caller": caller, "ok": ok, "role": services.Role(ctx.Request.Context()), "stepUp": stepUp})
+	})
+
+	send := func(header string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/me", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("")
+	assert.Equal(t, `{"caller":0,"ok":false,"role":"customer","stepUp":false}`, w.Body.String())
+	w = send("Bearer " + pair.AccessToken)
+	assert.Equal(t, `{"caller":7,"ok":true,"role":"support_agent","stepUp":false}`, w.Body.String())
+	stepUp, err := issuer.IssueStepUp(7, "support_agent", time.Now())
+	require.NoError(t, err)
+	w = send("Bearer " + stepUp.AccessToken)
+	assert.Equal(t, `{"caller":7,"ok":true,"role":"support_agent","stepUp":true}`, w.Body.String())
+	for _, header := range []string{"Bearer " + pair.RefreshToken, "Basic dXNlcjpwYXNz", "Bearer"} {
+		w = send(header)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, header)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+	}
+}
@@ -0,0 +1,139 @@
+// Package tracing configures OpenTelemetry and provides the helpers used to
+// start spans in handlers and repositories.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/jamadeu/accounts"
+
+// Exporters accepted by Setup.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Attribute keys shared by handler and repository spans.
+const (
+	UserIDKey        = attribute.Key("user.id")
+	AccountIDKey     = attribute.Key("account.id")
+	FromAccountIDKey = attribute.Key("account.from_id")
+	ToAccountIDKey   = attribute.Key("account.to_id")
+)
+
+type Options struct {
+	// Exporter is one of none, stdout or otlp. The OTLP exporter is
+	// configured by the standard OTEL_EXPORTER_OTLP_* variables.
+	Exporter    string
+	ServiceName string
+	// SampleRatio is the fraction of new traces recorded. Traces started
+	// by a sampled upstream are always recorded.
+	SampleRatio float64
+	// Stdout is where the stdout exporter writes; os.Stdout when nil.
+	Stdout io.Writer
+}
+
+// Setup installs the global tracer provider and W3C propagators. The
+// returned function flushes pending spans and must be called on exit.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w := opts.Stdout
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start begins a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on span and ends it. It is meant to be deferred
+// with a pointer to the named error result:
+//
+//	ctx, span := tracing.Start(ctx, "Repo.Method")
+//	defer tracing.End(span, &err)
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// UserID returns the user.id attribute.
+func UserID(id uint) attribute.KeyValue {
+	return UserIDKey.Int64(int64(id))
+}
+
+// UserIDString returns the user.id attribute for an ID received as text. It
+// is an integer, like the one set by UserID, whenever the text is one.
+func UserIDString(id string) attribute.KeyValue {
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return UserIDKey.Int64(n)
+	}
+	return UserIDKey.String(id)
+}
+
+// AccountID returns the account.id attribute.
+func AccountID(id uint) attribute.KeyValue {
+	return AccountIDKey.Int64(int64(id))
+}
+
+// SetAttributes adds attrs to the span in ctx, typically the server span of
+// the current request.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
@@ -0,0 +1,91 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jamadeu/accounts/services"
+	"github.com/jamadeu/accounts/services/user"
+	"github.com/jamadeu/accounts/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestSpansNestFromRouteToQuery(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(tracing.GormPlugin{}))
+
+	router := gin.New()
+	router.Use(otelgin.Middleware("accounts-test"))
+	user.NewUserHandler(user.NewTracedUserRepository(user.NewUserRepository(db))).RegisterRoutes(router, "api")
+
+	req, err := http.NewRequest("GET", "/api/v1/user?id=42", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(httptest.NewRecorder(), req.WithContext(services.WithCaller(req.Context(), 42)))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	server, ok := spans["/api/v1/user"]
+	require.True(t, ok, "missing server span")
+	repo, ok := spans["UserRepository.FindById"]
+	require.True(t, ok, "missing repository span")
+	query, ok := spans["gorm.query users"]
+	require.True(t, ok, "missing query span")
+
+	assert.Equal(t, server.SpanContext().SpanID(), repo.Parent().SpanID())
+	assert.Equal(t, repo.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Equal(t, int64(42), attr(server, tracing.UserIDKey).AsInt64())
+	assert.Equal(t, int64(42), attr(repo, tracing.UserIDKey).AsInt64())
+	assert.Contains(t, attr(query, "db.query.text").AsString(), `FROM "users"`)
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	buf := &bytes.Buffer{}
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    tracing.ExporterStdout,
+		ServiceName: "accounts-test",
+		SampleRatio: 1,
+		Stdout:      buf,
+	})
+	require.NoError(t, err)
+	_, span := tracing.Start(context.Background(), "unit", tracing.AccountID(7))
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name":"unit"`)
+	assert.Contains(t, buf.String(), `"account.id"`)
+
+	_, err = tracing.Setup(context.Background(), tracing.Options{Exporter: "zipkin"})
+	assert.ErrorContains(t, err, "unknown trace exporter")
+}